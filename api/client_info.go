@@ -0,0 +1,148 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"finanstar/server/session"
+)
+
+const (
+	MAX_USER_AGENT_LENGTH   = 512
+	MAX_DEVICE_LABEL_LENGTH = 64
+)
+
+type userAgentToken struct {
+	token string
+	label string
+}
+
+// Checked in order, first match wins
+var operatingSystems = []userAgentToken{
+	{"iPhone", "iPhone"},
+	{"iPad", "iPad"},
+	{"Android", "Android"},
+	{"Windows", "Windows"},
+	{"Macintosh", "macOS"},
+	{"CrOS", "ChromeOS"},
+	{"Linux", "Linux"},
+}
+
+var browsers = []userAgentToken{
+	{"Edg/", "Edge"},
+	{"OPR/", "Opera"},
+	{"Firefox/", "Firefox"},
+	{"Chrome/", "Chrome"},
+	{"Safari/", "Safari"},
+}
+
+// Collects client details stored with session. Device label provided by
+// client takes precedence over the one derived from User-Agent.
+func clientInfoFromRequest(
+	r *http.Request,
+	device string,
+	trustedProxies []netip.Prefix,
+) session.ClientInfo {
+	userAgent := truncate(r.UserAgent(), MAX_USER_AGENT_LENGTH)
+
+	if len(device) == 0 {
+		device = deviceLabel(userAgent)
+	}
+
+	return session.ClientInfo{
+		Ip:        clientIp(r, trustedProxies),
+		UserAgent: userAgent,
+		Device:    truncate(device, MAX_DEVICE_LABEL_LENGTH),
+	}
+}
+
+// Returns peer address unless it is a trusted proxy. X-Forwarded-For is then
+// walked from the right, as proxies append to it, and the first address not
+// belonging to a trusted proxy is the client. Entries left of it are set by
+// the client and can't be trusted
+func clientIp(r *http.Request, trustedProxies []netip.Prefix) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(ip)
+
+	if err != nil || !isTrustedProxy(addr, trustedProxies) {
+		return ip
+	}
+
+	forwarded := strings.Split(
+		strings.Join(r.Header.Values("X-Forwarded-For"), ","),
+		",",
+	)
+
+	for index := len(forwarded) - 1; index >= 0; index-- {
+		addr, err = netip.ParseAddr(strings.TrimSpace(forwarded[index]))
+
+		// Malformed entry, the last trusted proxy is the best guess
+		if err != nil {
+			return ip
+		}
+
+		ip = addr.Unmap().String()
+
+		if !isTrustedProxy(addr, trustedProxies) {
+			return ip
+		}
+	}
+
+	return ip
+}
+
+func isTrustedProxy(addr netip.Addr, trustedProxies []netip.Prefix) bool {
+	addr = addr.Unmap()
+
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Makes label like "Firefox on Linux" from User-Agent
+func deviceLabel(userAgent string) string {
+	browser := matchUserAgent(userAgent, browsers)
+	os := matchUserAgent(userAgent, operatingSystems)
+
+	switch {
+	case len(browser) != 0 && len(os) != 0:
+		return browser + " on " + os
+	case len(os) != 0:
+		return os
+	case len(browser) != 0:
+		return browser
+	default:
+		return "Unknown device"
+	}
+}
+
+func matchUserAgent(userAgent string, tokens []userAgentToken) string {
+	for _, token := range tokens {
+		if strings.Contains(userAgent, token.token) {
+			return token.label
+		}
+	}
+
+	return ""
+}
+
+func truncate(value string, length int) string {
+	runes := []rune(value)
+
+	if len(runes) <= length {
+		return value
+	}
+
+	return string(runes[:length])
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeviceLabel(t *testing.T) {
+	t.Parallel()
+
+	subtests := []struct {
+		name      string
+		userAgent string
+		label     string
+	}{
+		{
+			`FirefoxOnLinux`,
+			`Mozilla/5.0 (X11; Linux x86_64; rv:131.0) Gecko/20100101 Firefox/131.0`,
+			`Firefox on Linux`,
+		},
+		{
+			`ChromeOnWindows`,
+			`Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36`,
+			`Chrome on Windows`,
+		},
+		{
+			`EdgeOnWindows`,
+			`Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36 Edg/129.0.0.0`,
+			`Edge on Windows`,
+		},
+		{
+			`SafariOnIPhone`,
+			`Mozilla/5.0 (iPhone; CPU iPhone OS 17_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.6 Mobile/15E148 Safari/604.1`,
+			`Safari on iPhone`,
+		},
+		{`UnknownClient`, `curl/8.0`, `Unknown device`},
+	}
+
+	for _, test := range subtests {
+		t.Run(test.name, func(t *testing.T) {
+			require.Equal(t, test.label, deviceLabel(test.userAgent))
+		})
+	}
+}
+
+func TestClientInfoFromRequest(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	request := httptest.NewRequest(http.MethodPost, `/sessions`, nil)
+	request.RemoteAddr = `192.0.2.1:54321`
+	request.Header.Set(`User-Agent`, `curl/8.0`)
+
+	client := clientInfoFromRequest(request, ``, nil)
+
+	require.Equal(`192.0.2.1`, client.Ip)
+	require.Equal(`curl/8.0`, client.UserAgent)
+	require.Equal(`Unknown device`, client.Device)
+
+	client = clientInfoFromRequest(request, `Work laptop`, nil)
+
+	require.Equal(`Work laptop`, client.Device)
+}
+
+func TestClientIp(t *testing.T) {
+	t.Parallel()
+
+	trustedProxies := []netip.Prefix{netip.MustParsePrefix(`10.0.0.0/8`)}
+	subtests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		ip         string
+	}{
+		{`UsesPeerWithoutHeader`, `192.0.2.1:54321`, nil, `192.0.2.1`},
+		{
+			`IgnoresHeaderOfUntrustedPeer`,
+			`192.0.2.1:54321`,
+			[]string{`198.51.100.1`},
+			`192.0.2.1`,
+		},
+		{
+			`SkipsTrustedProxies`,
+			`10.0.0.1:54321`,
+			[]string{`203.0.113.7, 198.51.100.1`, `10.0.0.2`},
+			`198.51.100.1`,
+		},
+		{
+			`UsesLastProxyOnMalformedEntry`,
+			`10.0.0.1:54321`,
+			[]string{`unknown, 10.0.0.2`},
+			`10.0.0.2`,
+		},
+		{`UsesPeerWithoutForwarding`, `10.0.0.1:54321`, nil, `10.0.0.1`},
+	}
+
+	for _, test := range subtests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, `/sessions`, nil)
+			request.RemoteAddr = test.remoteAddr
+
+			for _, value := range test.forwarded {
+				request.Header.Add(`X-Forwarded-For`, value)
+			}
+
+			require.Equal(t, test.ip, clientIp(request, trustedProxies))
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"net/http"
+)
+
+type resendEmailVerificationRequest struct {
+	Login string `json:"login"`
+}
+
+type confirmEmailRequest struct {
+	Token string `json:"token"`
+}
+
+// Rejects the request when email verification policy doesn't let the user
+// change anything. Requires session in request context
+func (self *Server) requireVerifiedEmail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sData := SessionDataFromContext(r.Context())
+
+		if err := self.verification.CheckWrite(r.Context(), sData.UserId); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Always accepted, so response doesn't reveal whether the login exists or
+// resending is throttled
+func (self *Server) resendEmailVerification(w http.ResponseWriter, r *http.Request) {
+	var body resendEmailVerificationRequest
+
+	if err := readJson(w, r, &body); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if len(body.Login) == 0 {
+		writeError(w, ErrInvalidRequestBody)
+		return
+	}
+
+	err := self.verification.ResendVerification(r.Context(), body.Login)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (self *Server) confirmEmail(w http.ResponseWriter, r *http.Request) {
+	var body confirmEmailRequest
+
+	if err := readJson(w, r, &body); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if len(body.Token) == 0 {
+		writeError(w, ErrInvalidRequestBody)
+		return
+	}
+
+	if err := self.verification.ConfirmEmail(r.Context(), body.Token); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
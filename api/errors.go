@@ -0,0 +1,103 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"finanstar/server/auth"
+	"finanstar/server/session"
+	"finanstar/server/user"
+)
+
+const (
+	UNAUTHORIZED_ERROR    = "Authorization required"
+	INTERNAL_SERVER_ERROR = "Internal server error"
+)
+
+const (
+	INTERNAL_ERROR_CODE = "internal_error"
+)
+
+var (
+	ErrUnauthorized = errors.New(UNAUTHORIZED_ERROR)
+)
+
+type errorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type errorResponse struct {
+	Error errorBody `json:"error"`
+}
+
+type errorMapping struct {
+	err    error
+	status int
+	code   string
+}
+
+// Domain errors exposed to clients, matched with errors.Is in order.
+// Everything else is reported as internal error.
+var errorMappings = []errorMapping{
+	{user.ErrUserNotFound, http.StatusNotFound, "user_not_found"},
+	{user.ErrUserAlreadyExists, http.StatusConflict, "user_already_exists"},
+	{user.ErrUserConflict, http.StatusConflict, "user_conflict"},
+	{user.ErrThereIsNoUpdateParams, http.StatusBadRequest, "no_update_params"},
+	{session.ErrSessionNotFound, http.StatusUnauthorized, "session_not_found"},
+	{session.ErrSessionDataInvalid, http.StatusUnauthorized, "session_invalid"},
+	{session.ErrSessionHandleNotFound, http.StatusNotFound, "session_handle_not_found"},
+	{session.ErrSessionLimitReached, http.StatusConflict, "session_limit_reached"},
+	{session.ErrSessionIdCollision, http.StatusServiceUnavailable, "session_id_collision"},
+	{auth.ErrInvalidCredentials, http.StatusUnauthorized, "invalid_credentials"},
+	{auth.ErrLoginThrottled, http.StatusTooManyRequests, "login_throttled"},
+	{auth.ErrSecondFactorRequired, http.StatusUnauthorized, "second_factor_required"},
+	{auth.ErrSecondFactorNotPending, http.StatusConflict, "second_factor_not_pending"},
+	{auth.ErrSecondFactorExpired, http.StatusUnauthorized, "second_factor_expired"},
+	{auth.ErrInvalidSecondFactor, http.StatusUnauthorized, "invalid_second_factor"},
+	{auth.ErrTotpNotEnrolled, http.StatusConflict, "totp_not_enrolled"},
+	{auth.ErrTotpAlreadyEnabled, http.StatusConflict, "totp_already_enabled"},
+	{auth.ErrPasswordResetTokenInvalid, http.StatusBadRequest, "password_reset_token_invalid"},
+	{auth.ErrEmailNotVerified, http.StatusForbidden, "email_not_verified"},
+	{
+		auth.ErrEmailVerificationTokenInvalid,
+		http.StatusBadRequest,
+		"email_verification_token_invalid",
+	},
+	{ErrInvalidRequestBody, http.StatusBadRequest, "invalid_request_body"},
+	{ErrUnauthorized, http.StatusUnauthorized, "unauthorized"},
+}
+
+// Returns HTTP status and machine-readable code for the error
+func MapError(err error) (int, string) {
+	for _, mapping := range errorMappings {
+		if errors.Is(err, mapping.err) {
+			return mapping.status, mapping.code
+		}
+	}
+
+	return http.StatusInternalServerError, INTERNAL_ERROR_CODE
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status, code := MapError(err)
+	message := err.Error()
+
+	var throttled *auth.LoginThrottledError
+
+	if errors.As(err, &throttled) {
+		// Rounded up, so retrying right away isn't throttled again
+		retryAfter := (throttled.RetryAfter + time.Second - 1) / time.Second
+		w.Header().Set("Retry-After", strconv.FormatInt(int64(retryAfter), 10))
+	}
+
+	if code == INTERNAL_ERROR_CODE {
+		log.Printf("Unhandled error: %v", err)
+		message = INTERNAL_SERVER_ERROR
+	}
+
+	writeJson(w, status, errorResponse{errorBody{code, message}})
+}
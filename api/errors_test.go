@@ -0,0 +1,43 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/require"
+
+	"finanstar/server/apperror"
+	"finanstar/server/session"
+	"finanstar/server/user"
+)
+
+func TestMapError(t *testing.T) {
+	t.Parallel()
+
+	subtests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{`WrappedUserNotFound`, apperror.Wrap(user.ErrUserNotFound, pgx.ErrNoRows), http.StatusNotFound, `user_not_found`},
+		{`FmtWrappedSessionNotFound`, fmt.Errorf(`renewal: %w`, session.ErrSessionNotFound), http.StatusUnauthorized, `session_not_found`},
+		{`SessionHandleNotFound`, session.ErrSessionHandleNotFound, http.StatusNotFound, `session_handle_not_found`},
+		{`SessionLimitReached`, session.ErrSessionLimitReached, http.StatusConflict, `session_limit_reached`},
+		{`SessionIdCollision`, session.ErrSessionIdCollision, http.StatusServiceUnavailable, `session_id_collision`},
+		{`UserAlreadyExists`, user.ErrUserAlreadyExists, http.StatusConflict, `user_already_exists`},
+		{`UnknownError`, errors.New(`connection refused`), http.StatusInternalServerError, INTERNAL_ERROR_CODE},
+	}
+
+	for _, test := range subtests {
+		t.Run(test.name, func(t *testing.T) {
+			status, code := MapError(test.err)
+
+			require.Equal(t, test.status, status)
+			require.Equal(t, test.code, code)
+		})
+	}
+}
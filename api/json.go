@@ -0,0 +1,41 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"finanstar/server/apperror"
+)
+
+const (
+	MAX_REQUEST_BODY_SIZE = 1 << 20
+)
+
+const (
+	INVALID_REQUEST_BODY_ERROR = "Request body is not a valid JSON"
+)
+
+var (
+	ErrInvalidRequestBody = errors.New(INVALID_REQUEST_BODY_ERROR)
+)
+
+func readJson(w http.ResponseWriter, r *http.Request, dst any) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, MAX_REQUEST_BODY_SIZE))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(dst); err != nil {
+		return apperror.Wrap(ErrInvalidRequestBody, err)
+	}
+
+	return nil
+}
+
+func writeJson(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if body != nil {
+		json.NewEncoder(w).Encode(body)
+	}
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"net/http"
+)
+
+type requestPasswordResetRequest struct {
+	Login string `json:"login"`
+}
+
+type confirmPasswordResetRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// Always accepted, so response doesn't reveal whether the login exists
+func (self *Server) requestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var body requestPasswordResetRequest
+
+	if err := readJson(w, r, &body); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if len(body.Login) == 0 {
+		writeError(w, ErrInvalidRequestBody)
+		return
+	}
+
+	if err := self.reset.RequestReset(r.Context(), body.Login); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (self *Server) confirmPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var body confirmPasswordResetRequest
+
+	if err := readJson(w, r, &body); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if len(body.Token) == 0 || len(body.Password) == 0 {
+		writeError(w, ErrInvalidRequestBody)
+		return
+	}
+
+	err := self.reset.ConfirmReset(r.Context(), body.Token, body.Password)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	clearSessionCookie(w)
+	w.WriteHeader(http.StatusNoContent)
+}
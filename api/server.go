@@ -0,0 +1,112 @@
+package api
+
+import (
+	"net/http"
+	"net/netip"
+
+	"finanstar/server/auth"
+	"finanstar/server/session"
+	"finanstar/server/user"
+)
+
+// HTTP REST API exposing user and session management
+
+const (
+	SESSION_COOKIE_NAME = "sid"
+)
+
+type Server struct {
+	users        *user.UserService
+	auth         *auth.AuthService
+	totp         *auth.TotpService
+	reset        *auth.PasswordResetService
+	verification *auth.EmailVerificationService
+	sessions     session.SessionManager
+	options      ServerOptions
+	mux          *http.ServeMux
+}
+
+type ServerOptions struct {
+	Session SessionMiddlewareOptions
+	// Reverse proxies in front of the server. Client IP is taken from
+	// X-Forwarded-For set by them, it is the peer address when empty
+	TrustedProxies []netip.Prefix
+}
+
+func NewServer(
+	users *user.UserService,
+	auth *auth.AuthService,
+	totp *auth.TotpService,
+	reset *auth.PasswordResetService,
+	verification *auth.EmailVerificationService,
+	sessions session.SessionManager,
+	options *ServerOptions,
+) *Server {
+	server := &Server{
+		users:        users,
+		auth:         auth,
+		totp:         totp,
+		reset:        reset,
+		verification: verification,
+		sessions:     sessions,
+		mux:          http.NewServeMux(),
+	}
+
+	if options != nil {
+		server.options = *options
+	}
+
+	server.options.Session = withDefaultSessionOptions(&server.options.Session)
+	authenticated := NewSessionMiddleware(sessions, &server.options.Session).Wrap
+	// Changes are rejected while email verification policy keeps user
+	// read-only
+	writable := func(handler http.HandlerFunc) http.Handler {
+		return authenticated(server.requireVerifiedEmail(handler))
+	}
+
+	server.mux.HandleFunc("POST /users", server.signUp)
+	server.mux.Handle(
+		"GET /users/me",
+		authenticated(http.HandlerFunc(server.whoAmI)),
+	)
+	// Checks verified email itself, so mistyped login can be fixed before that
+	server.mux.Handle(
+		"PATCH /users/me",
+		authenticated(http.HandlerFunc(server.updateProfile)),
+	)
+	server.mux.Handle("POST /users/me/totp", writable(server.beginTotpEnrollment))
+	server.mux.Handle(
+		"POST /users/me/totp/confirm",
+		writable(server.confirmTotpEnrollment),
+	)
+	server.mux.Handle("DELETE /users/me/totp", writable(server.disableTotp))
+	server.mux.HandleFunc("POST /password-reset", server.requestPasswordReset)
+	server.mux.HandleFunc("POST /password-reset/confirm", server.confirmPasswordReset)
+	server.mux.HandleFunc("POST /email-verification", server.resendEmailVerification)
+	server.mux.HandleFunc("POST /email-verification/confirm", server.confirmEmail)
+	server.mux.HandleFunc("POST /sessions", server.signIn)
+	// Takes pending session, so it isn't wrapped with authenticated
+	server.mux.HandleFunc("POST /sessions/second-factor", server.confirmSecondFactor)
+	server.mux.Handle(
+		"GET /sessions",
+		authenticated(http.HandlerFunc(server.listSessions)),
+	)
+	server.mux.Handle(
+		"DELETE /sessions/current",
+		authenticated(http.HandlerFunc(server.signOut)),
+	)
+	server.mux.Handle(
+		"DELETE /sessions/others",
+		authenticated(http.HandlerFunc(server.signOutOthers)),
+	)
+	server.mux.Handle(
+		"DELETE /sessions/{handle}",
+		authenticated(http.HandlerFunc(server.revokeSession)),
+	)
+
+	return server
+}
+
+func (self *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	self.mux.ServeHTTP(w, r)
+}
@@ -0,0 +1,898 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/require"
+
+	"finanstar/server/auth"
+	"finanstar/server/crypto"
+	"finanstar/server/mail"
+	"finanstar/server/session"
+	"finanstar/server/user"
+	utils_pgx "finanstar/server/utils"
+)
+
+type testServer struct {
+	server   *Server
+	db       pgxmock.PgxPoolIface
+	sessions *session.MemorySessionManager
+	clock    *session.ManualClock
+}
+
+type testServerOptions struct {
+	// Email verification policy, defaults to none
+	policy string
+	// Defaults to auth.NopLoginLimiter
+	limiter auth.LoginLimiter
+}
+
+func newTestServer(t *testing.T) testServer {
+	return newConfiguredTestServer(t, testServerOptions{})
+}
+
+func newConfiguredTestServer(t *testing.T, options testServerOptions) testServer {
+	if options.limiter == nil {
+		options.limiter = auth.NopLoginLimiter{}
+	}
+
+	db, err := pgxmock.NewPool()
+
+	require.Nil(t, err)
+
+	clock := session.NewManualClock(time.Now())
+	userRepository := user.NewPostgresqlUserRepository(db)
+	userService := user.NewUserService(&userRepository)
+	sessionManager := session.NewMemorySessionManager(
+		&session.MemorySessionManagerOptions{Clock: clock},
+	)
+	totpRepository := auth.NewPostgresqlTotpRepository(db)
+	totpService := auth.NewTotpService(
+		&totpRepository,
+		&auth.TotpServiceOptions{Clock: clock},
+	)
+	emailVerificationRepository := auth.NewPostgresqlEmailVerificationRepository(db)
+	emailVerificationService := auth.NewEmailVerificationService(
+		&userService,
+		&emailVerificationRepository,
+		mail.NewLogSender(io.Discard),
+		&auth.EmailVerificationServiceOptions{Policy: options.policy, Clock: clock},
+	)
+	authService := auth.NewAuthService(
+		&userService,
+		sessionManager,
+		&totpService,
+		&emailVerificationService,
+		options.limiter,
+		&auth.AuthServiceOptions{Clock: clock},
+	)
+	passwordResetRepository := auth.NewPostgresqlPasswordResetRepository(db)
+	passwordResetService := auth.NewPasswordResetService(
+		&userService,
+		sessionManager,
+		&passwordResetRepository,
+		utils_pgx.NewTxManager(db, nil),
+		mail.NewLogSender(io.Discard),
+		&auth.PasswordResetServiceOptions{Clock: clock},
+	)
+	server := NewServer(
+		&userService,
+		&authService,
+		&totpService,
+		&passwordResetService,
+		&emailVerificationService,
+		sessionManager,
+		&ServerOptions{Session: SessionMiddlewareOptions{Clock: clock}},
+	)
+
+	return testServer{
+		server:   server,
+		db:       db,
+		sessions: sessionManager,
+		clock:    clock,
+	}
+}
+
+func (self *testServer) do(
+	method string,
+	path string,
+	sId string,
+	body any,
+) *httptest.ResponseRecorder {
+	var payload bytes.Buffer
+
+	if body != nil {
+		json.NewEncoder(&payload).Encode(body)
+	}
+
+	request := httptest.NewRequest(method, path, &payload)
+
+	if len(sId) != 0 {
+		request.Header.Set("Authorization", "Bearer "+sId)
+	}
+
+	recorder := httptest.NewRecorder()
+	self.server.ServeHTTP(recorder, request)
+
+	return recorder
+}
+
+// Creates session of the user the way signing in does
+func (self *testServer) createSession(t *testing.T, userId uint32) string {
+	sId, err := self.sessions.CreateSession(
+		context.Background(),
+		&session.SessionData{UserId: userId},
+	)
+
+	require.Nil(t, err)
+
+	return sId
+}
+
+// Expects verification token to be emailed to the user
+func (self *testServer) expectEmailVerificationToken(userId uint32, login string) {
+	self.db.
+		ExpectExec(`INSERT INTO email_verification_tokens`).
+		WithArgs(
+			pgxmock.AnyArg(),
+			userId,
+			login,
+			self.clock.Now(),
+			pgxmock.AnyArg(),
+			pgxmock.AnyArg(),
+		).
+		WillReturnResult(pgxmock.NewResult(`INSERT`, 1))
+}
+
+// Expects lookup of user TOTP secret, nil confirmedAt means second factor is
+// disabled
+func (self *testServer) expectTotp(userId uint32, confirmedAt *time.Time) {
+	self.db.
+		ExpectQuery(`SELECT secret, confirmed_at, last_used_step FROM user_totp`).
+		WithArgs(userId).
+		WillReturnRows(
+			self.db.
+				NewRows([]string{`secret`, `confirmed_at`, `last_used_step`}).
+				AddRow(`GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ`, confirmedAt, uint64(0)),
+		)
+}
+
+func decodeError(t *testing.T, recorder *httptest.ResponseRecorder) errorBody {
+	var response errorResponse
+
+	require.Nil(t, json.NewDecoder(recorder.Body).Decode(&response))
+
+	return response.Error
+}
+
+func TestSignUp(t *testing.T) {
+	t.Parallel()
+
+	subtests := []struct {
+		name         string
+		body         any
+		dbError      error
+		expectQuery  bool
+		status       int
+		expectedCode string
+	}{
+		{
+			name:        `CreatesUser`,
+			body:        signUpRequest{Login: `test@example.com`, Password: `secure-password`},
+			expectQuery: true,
+			status:      http.StatusCreated,
+		},
+		{
+			name: `ReturnsConflictForDuplicate`,
+			body: signUpRequest{Login: `test@example.com`, Password: `secure-password`},
+			dbError: &pgconn.PgError{
+				Code:           utils_pgx.UNIQUE_VIOLATION_CODE,
+				ConstraintName: user.USERS_LOGIN_CONSTRAINT,
+			},
+			expectQuery:  true,
+			status:       http.StatusConflict,
+			expectedCode: `user_already_exists`,
+		},
+		{
+			name:         `RejectsEmptyPassword`,
+			body:         signUpRequest{Login: `test@example.com`},
+			status:       http.StatusBadRequest,
+			expectedCode: `invalid_request_body`,
+		},
+		{
+			name:         `RejectsUnknownFields`,
+			body:         map[string]string{`username`: `test`},
+			status:       http.StatusBadRequest,
+			expectedCode: `invalid_request_body`,
+		},
+	}
+
+	for _, test := range subtests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+			ts := newTestServer(t)
+
+			if test.expectQuery {
+				query := ts.db.
+					ExpectQuery(`INSERT INTO users`).
+					WithArgs(`test@example.com`, pgxmock.AnyArg())
+
+				if test.dbError != nil {
+					query.WillReturnError(test.dbError)
+				} else {
+					query.WillReturnRows(
+						ts.db.
+							NewRows([]string{`id`, `login`, `password`, `email_verified_at`}).
+							AddRow(uint32(1), `test@example.com`, `hash`, nil),
+					)
+					ts.expectEmailVerificationToken(1, `test@example.com`)
+				}
+			}
+
+			recorder := ts.do(http.MethodPost, `/users`, ``, test.body)
+
+			require.Equal(test.status, recorder.Code)
+
+			if len(test.expectedCode) != 0 {
+				require.Equal(test.expectedCode, decodeError(t, recorder).Code)
+			} else {
+				var response userResponse
+
+				require.Nil(json.NewDecoder(recorder.Body).Decode(&response))
+				require.Equal(userResponse{Id: 1, Login: `test@example.com`}, response)
+				require.NotContains(recorder.Body.String(), `hash`)
+			}
+
+			require.Nil(ts.db.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestSignIn(t *testing.T) {
+	t.Parallel()
+
+	hashedPassword, err := crypto.HashPassword(`secure-password`)
+
+	require.Nil(t, err)
+
+	subtests := []struct {
+		name         string
+		password     string
+		userExists   bool
+		status       int
+		expectedCode string
+	}{
+		{`CreatesSession`, `secure-password`, true, http.StatusCreated, ``},
+		{`RejectsWrongPassword`, `wrong-password`, true, http.StatusUnauthorized, `invalid_credentials`},
+		{`RejectsUnknownLogin`, `secure-password`, false, http.StatusUnauthorized, `invalid_credentials`},
+	}
+
+	for _, test := range subtests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+			ts := newTestServer(t)
+			rows := ts.db.NewRows([]string{`id`, `login`, `password`, `email_verified_at`})
+
+			if test.userExists {
+				rows.AddRow(uint32(1), `test@example.com`, hashedPassword, nil)
+			}
+
+			ts.db.
+				ExpectQuery(`SELECT id, login, password, email_verified_at FROM users`).
+				WithArgs(`test@example.com`).
+				WillReturnRows(rows)
+
+			if test.userExists && test.status == http.StatusCreated {
+				ts.expectTotp(1, nil)
+			}
+
+			recorder := ts.do(
+				http.MethodPost,
+				`/sessions`,
+				``,
+				signInRequest{Login: `test@example.com`, Password: test.password},
+			)
+
+			require.Equal(test.status, recorder.Code)
+
+			if len(test.expectedCode) != 0 {
+				require.Equal(test.expectedCode, decodeError(t, recorder).Code)
+			} else {
+				var response signInResponse
+
+				require.Nil(json.NewDecoder(recorder.Body).Decode(&response))
+				require.Len(response.SessionId, session.SESSION_ID_LENGTH*2)
+				require.Contains(
+					recorder.Header().Get(`Set-Cookie`),
+					SESSION_COOKIE_NAME+`=`+response.SessionId,
+				)
+
+				sData, err := ts.sessions.GetSessionData(
+					context.Background(),
+					response.SessionId,
+				)
+
+				require.Nil(err)
+				require.Equal(uint32(1), sData.UserId)
+			}
+
+			require.Nil(ts.db.ExpectationsWereMet())
+		})
+	}
+}
+
+// Rejects every attempt as throttled
+type throttlingLoginLimiter struct {
+	auth.NopLoginLimiter
+}
+
+func (throttlingLoginLimiter) Attempt(
+	ctx context.Context,
+	login string,
+	ip string,
+) (*auth.LoginAttempt, error) {
+	return nil, &auth.LoginThrottledError{RetryAfter: 1500 * time.Millisecond}
+}
+
+func TestSignInThrottled(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ts := newConfiguredTestServer(t, testServerOptions{limiter: throttlingLoginLimiter{}})
+
+	recorder := ts.do(
+		http.MethodPost,
+		`/sessions`,
+		``,
+		signInRequest{Login: `test@example.com`, Password: `secure-password`},
+	)
+
+	require.Equal(http.StatusTooManyRequests, recorder.Code)
+	require.Equal(`2`, recorder.Header().Get(`Retry-After`))
+	require.Equal(`login_throttled`, decodeError(t, recorder).Code)
+	require.Nil(ts.db.ExpectationsWereMet())
+}
+
+func TestSignInWithSecondFactor(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ts := newTestServer(t)
+	hashedPassword, err := crypto.HashPassword(`secure-password`)
+
+	require.Nil(err)
+
+	confirmedAt := ts.clock.Now().Add(-time.Hour)
+
+	ts.db.
+		ExpectQuery(`SELECT id, login, password, email_verified_at FROM users`).
+		WithArgs(`test@example.com`).
+		WillReturnRows(
+			ts.db.NewRows([]string{`id`, `login`, `password`, `email_verified_at`}).
+				AddRow(uint32(1), `test@example.com`, hashedPassword, nil),
+		)
+	ts.expectTotp(1, &confirmedAt)
+
+	recorder := ts.do(
+		http.MethodPost,
+		`/sessions`,
+		``,
+		signInRequest{Login: `test@example.com`, Password: `secure-password`},
+	)
+
+	require.Equal(http.StatusCreated, recorder.Code)
+
+	var pending signInResponse
+
+	require.Nil(json.NewDecoder(recorder.Body).Decode(&pending))
+	require.True(pending.SecondFactorRequired)
+
+	// Pending session doesn't authenticate requests
+	recorder = ts.do(http.MethodGet, `/users/me`, pending.SessionId, nil)
+
+	require.Equal(http.StatusUnauthorized, recorder.Code)
+	require.Equal(`second_factor_required`, decodeError(t, recorder).Code)
+
+	code, err := crypto.TotpCode(
+		`GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ`,
+		crypto.TotpStep(ts.clock.Now()),
+	)
+
+	require.Nil(err)
+
+	ts.expectTotp(1, &confirmedAt)
+	ts.db.
+		ExpectExec(`UPDATE user_totp SET last_used_step = \$2`).
+		WithArgs(uint32(1), int64(crypto.TotpStep(ts.clock.Now()))).
+		WillReturnResult(pgxmock.NewResult(`UPDATE`, 1))
+	ts.db.
+		ExpectQuery(`SELECT id, login, password, email_verified_at FROM users WHERE id = \$1;`).
+		WithArgs(uint32(1)).
+		WillReturnRows(
+			ts.db.NewRows([]string{`id`, `login`, `password`, `email_verified_at`}).
+				AddRow(uint32(1), `test@example.com`, hashedPassword, nil),
+		)
+
+	recorder = ts.do(
+		http.MethodPost,
+		`/sessions/second-factor`,
+		pending.SessionId,
+		confirmSecondFactorRequest{Code: code},
+	)
+
+	require.Equal(http.StatusCreated, recorder.Code)
+
+	var confirmed signInResponse
+
+	require.Nil(json.NewDecoder(recorder.Body).Decode(&confirmed))
+	require.False(confirmed.SecondFactorRequired)
+	require.NotEqual(pending.SessionId, confirmed.SessionId)
+	require.Equal(
+		http.StatusOK,
+		ts.do(http.MethodGet, `/users/me`, confirmed.SessionId, nil).Code,
+	)
+	require.Nil(ts.db.ExpectationsWereMet())
+}
+
+func TestBeginTotpEnrollment(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ts := newTestServer(t)
+	sId := ts.createSession(t, 1337)
+
+	ts.db.
+		ExpectQuery(`SELECT id, login, password, email_verified_at FROM users WHERE id = \$1;`).
+		WithArgs(uint32(1337)).
+		WillReturnRows(
+			ts.db.NewRows([]string{`id`, `login`, `password`, `email_verified_at`}).
+				AddRow(uint32(1337), `test@example.com`, `hash`, nil),
+		)
+	ts.db.
+		ExpectExec(`INSERT INTO user_totp`).
+		WithArgs(uint32(1337), pgxmock.AnyArg()).
+		WillReturnResult(pgxmock.NewResult(`INSERT`, 1))
+
+	recorder := ts.do(http.MethodPost, `/users/me/totp`, sId, nil)
+
+	require.Equal(http.StatusCreated, recorder.Code)
+
+	var response totpEnrollmentResponse
+
+	require.Nil(json.NewDecoder(recorder.Body).Decode(&response))
+	require.Len(response.Secret, 32)
+	require.Contains(response.Uri, `otpauth://totp/Finanstar:test@example.com?`)
+	require.Nil(ts.db.ExpectationsWereMet())
+}
+
+func TestPasswordReset(t *testing.T) {
+	t.Parallel()
+
+	t.Run(`AcceptsUnknownLogin`, func(t *testing.T) {
+		require := require.New(t)
+		ts := newTestServer(t)
+
+		ts.db.
+			ExpectExec(`INSERT INTO password_reset_tokens`).
+			WithArgs(
+				pgxmock.AnyArg(),
+				`unknown@example.com`,
+				ts.clock.Now(),
+				pgxmock.AnyArg(),
+				pgxmock.AnyArg(),
+			).
+			WillReturnResult(pgxmock.NewResult(`INSERT`, 0))
+
+		recorder := ts.do(
+			http.MethodPost,
+			`/password-reset`,
+			``,
+			requestPasswordResetRequest{Login: `unknown@example.com`},
+		)
+
+		require.Equal(http.StatusAccepted, recorder.Code)
+		require.Nil(ts.db.ExpectationsWereMet())
+	})
+
+	t.Run(`RejectsInvalidToken`, func(t *testing.T) {
+		require := require.New(t)
+		ts := newTestServer(t)
+
+		ts.db.ExpectBegin()
+		ts.db.
+			ExpectQuery(`DELETE FROM password_reset_tokens`).
+			WithArgs(crypto.HashToken(`token`), ts.clock.Now()).
+			WillReturnRows(ts.db.NewRows([]string{`user_id`}))
+		ts.db.ExpectRollback()
+
+		recorder := ts.do(
+			http.MethodPost,
+			`/password-reset/confirm`,
+			``,
+			confirmPasswordResetRequest{Token: `token`, Password: `new-password`},
+		)
+
+		require.Equal(http.StatusBadRequest, recorder.Code)
+		require.Equal(`password_reset_token_invalid`, decodeError(t, recorder).Code)
+		require.Nil(ts.db.ExpectationsWereMet())
+	})
+}
+
+func TestEmailVerification(t *testing.T) {
+	t.Parallel()
+
+	t.Run(`ResendAcceptsUnknownLogin`, func(t *testing.T) {
+		require := require.New(t)
+		ts := newTestServer(t)
+
+		ts.db.
+			ExpectQuery(`SELECT id, login, password, email_verified_at FROM users`).
+			WithArgs(`unknown@example.com`).
+			WillReturnRows(
+				ts.db.NewRows([]string{`id`, `login`, `password`, `email_verified_at`}),
+			)
+
+		recorder := ts.do(
+			http.MethodPost,
+			`/email-verification`,
+			``,
+			resendEmailVerificationRequest{Login: `unknown@example.com`},
+		)
+
+		require.Equal(http.StatusAccepted, recorder.Code)
+		require.Nil(ts.db.ExpectationsWereMet())
+	})
+
+	t.Run(`ConfirmsEmail`, func(t *testing.T) {
+		require := require.New(t)
+		ts := newTestServer(t)
+		verifiedAt := ts.clock.Now()
+
+		ts.db.
+			ExpectQuery(`DELETE FROM email_verification_tokens`).
+			WithArgs(crypto.HashToken(`token`), ts.clock.Now()).
+			WillReturnRows(
+				ts.db.NewRows([]string{`user_id`, `login`}).
+					AddRow(uint32(1), `test@example.com`),
+			)
+		ts.db.
+			ExpectQuery(`UPDATE users SET email_verified_at`).
+			WithArgs(uint32(1), `test@example.com`, ts.clock.Now()).
+			WillReturnRows(
+				ts.db.NewRows([]string{`login`, `password`, `email_verified_at`}).
+					AddRow(`test@example.com`, `hash`, &verifiedAt),
+			)
+		ts.db.
+			ExpectExec(`DELETE FROM email_verification_tokens WHERE user_id = \$1`).
+			WithArgs(uint32(1)).
+			WillReturnResult(pgxmock.NewResult(`DELETE`, 0))
+
+		recorder := ts.do(
+			http.MethodPost,
+			`/email-verification/confirm`,
+			``,
+			confirmEmailRequest{Token: `token`},
+		)
+
+		require.Equal(http.StatusNoContent, recorder.Code)
+		require.Nil(ts.db.ExpectationsWereMet())
+	})
+
+	t.Run(`RejectsInvalidToken`, func(t *testing.T) {
+		require := require.New(t)
+		ts := newTestServer(t)
+
+		ts.db.
+			ExpectQuery(`DELETE FROM email_verification_tokens`).
+			WithArgs(crypto.HashToken(`token`), ts.clock.Now()).
+			WillReturnRows(ts.db.NewRows([]string{`user_id`, `login`}))
+
+		recorder := ts.do(
+			http.MethodPost,
+			`/email-verification/confirm`,
+			``,
+			confirmEmailRequest{Token: `token`},
+		)
+
+		require.Equal(http.StatusBadRequest, recorder.Code)
+		require.Equal(`email_verification_token_invalid`, decodeError(t, recorder).Code)
+		require.Nil(ts.db.ExpectationsWereMet())
+	})
+
+	t.Run(`ReadOnlyPolicyRejectsChanges`, func(t *testing.T) {
+		require := require.New(t)
+		ts := newConfiguredTestServer(
+			t,
+			testServerOptions{policy: auth.EMAIL_VERIFICATION_POLICY_READ_ONLY},
+		)
+		sId := ts.createSession(t, 1)
+
+		ts.db.
+			ExpectQuery(`SELECT id, login, password, email_verified_at FROM users WHERE id = \$1;`).
+			WithArgs(uint32(1)).
+			WillReturnRows(
+				ts.db.NewRows([]string{`id`, `login`, `password`, `email_verified_at`}).
+					AddRow(uint32(1), `test@example.com`, `hash`, nil),
+			)
+
+		password := `new-password`
+		recorder := ts.do(
+			http.MethodPatch,
+			`/users/me`,
+			sId,
+			updateProfileRequest{Password: &password},
+		)
+
+		require.Equal(http.StatusForbidden, recorder.Code)
+		require.Equal(`email_not_verified`, decodeError(t, recorder).Code)
+
+		// Reading is still allowed
+		recorder = ts.do(http.MethodGet, `/users/me`, sId, nil)
+
+		require.Equal(http.StatusOK, recorder.Code)
+		require.Nil(ts.db.ExpectationsWereMet())
+	})
+
+	t.Run(`ReadOnlyPolicyAllowsLoginChange`, func(t *testing.T) {
+		require := require.New(t)
+		ts := newConfiguredTestServer(
+			t,
+			testServerOptions{policy: auth.EMAIL_VERIFICATION_POLICY_READ_ONLY},
+		)
+		sId := ts.createSession(t, 1)
+
+		ts.db.
+			ExpectQuery(`UPDATE users`).
+			WithArgs(uint32(1), `fixed@example.com`).
+			WillReturnRows(
+				ts.db.NewRows([]string{`login`, `password`, `email_verified_at`}).
+					AddRow(`fixed@example.com`, `hash`, nil),
+			)
+		ts.expectEmailVerificationToken(1, `fixed@example.com`)
+
+		login := `fixed@example.com`
+		recorder := ts.do(
+			http.MethodPatch,
+			`/users/me`,
+			sId,
+			updateProfileRequest{Login: &login},
+		)
+
+		require.Equal(http.StatusOK, recorder.Code)
+		require.Nil(ts.db.ExpectationsWereMet())
+	})
+}
+
+func TestWhoAmI(t *testing.T) {
+	t.Parallel()
+
+	t.Run(`ReturnsSessionUser`, func(t *testing.T) {
+		require := require.New(t)
+		ts := newTestServer(t)
+		sId := ts.createSession(t, 1337)
+
+		recorder := ts.do(http.MethodGet, `/users/me`, sId, nil)
+
+		require.Equal(http.StatusOK, recorder.Code)
+		require.JSONEq(`{"userId":1337}`, recorder.Body.String())
+	})
+
+	t.Run(`RejectsUnknownSession`, func(t *testing.T) {
+		require := require.New(t)
+		ts := newTestServer(t)
+		sId, err := crypto.GenerateSecureId(session.SESSION_ID_LENGTH)
+
+		require.Nil(err)
+
+		recorder := ts.do(http.MethodGet, `/users/me`, sId, nil)
+
+		require.Equal(http.StatusUnauthorized, recorder.Code)
+		require.Equal(`session_not_found`, decodeError(t, recorder).Code)
+	})
+
+	t.Run(`RejectsExpiredSession`, func(t *testing.T) {
+		require := require.New(t)
+		ts := newTestServer(t)
+		sId := ts.createSession(t, 1337)
+
+		ts.clock.Advance(session.SESSION_IDLE_TIMEOUT)
+
+		recorder := ts.do(http.MethodGet, `/users/me`, sId, nil)
+
+		require.Equal(http.StatusUnauthorized, recorder.Code)
+		require.Equal(`session_not_found`, decodeError(t, recorder).Code)
+	})
+}
+
+func TestUpdateProfile(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ts := newTestServer(t)
+	sId := ts.createSession(t, 1)
+
+	ts.db.
+		ExpectQuery(`UPDATE users`).
+		WithArgs(uint32(1), `new@example.com`).
+		WillReturnRows(
+			ts.db.
+				NewRows([]string{`login`, `password`, `email_verified_at`}).
+				AddRow(`new@example.com`, `hash`, nil),
+		)
+	ts.expectEmailVerificationToken(1, `new@example.com`)
+
+	login := `new@example.com`
+	recorder := ts.do(
+		http.MethodPatch,
+		`/users/me`,
+		sId,
+		updateProfileRequest{Login: &login},
+	)
+
+	require.Equal(http.StatusOK, recorder.Code)
+	require.JSONEq(
+		`{"id":1,"login":"new@example.com","emailVerified":false}`,
+		recorder.Body.String(),
+	)
+	require.Nil(ts.db.ExpectationsWereMet())
+}
+
+func TestSignOut(t *testing.T) {
+	t.Parallel()
+
+	t.Run(`DeletesSession`, func(t *testing.T) {
+		require := require.New(t)
+		ts := newTestServer(t)
+		sId := ts.createSession(t, 1)
+
+		recorder := ts.do(http.MethodDelete, `/sessions/current`, sId, nil)
+
+		require.Equal(http.StatusNoContent, recorder.Code)
+
+		_, err := ts.sessions.GetSessionData(context.Background(), sId)
+
+		require.ErrorIs(err, session.ErrSessionNotFound)
+	})
+
+	t.Run(`RequiresSession`, func(t *testing.T) {
+		require := require.New(t)
+		ts := newTestServer(t)
+
+		recorder := ts.do(http.MethodDelete, `/sessions/current`, ``, nil)
+
+		require.Equal(http.StatusUnauthorized, recorder.Code)
+		require.Equal(`unauthorized`, decodeError(t, recorder).Code)
+	})
+}
+
+func TestListSessions(t *testing.T) {
+	t.Parallel()
+
+	require := require.New(t)
+	ts := newTestServer(t)
+	expiredSId := ts.createSession(t, 1)
+
+	ts.clock.Advance(session.SESSION_IDLE_TIMEOUT - time.Hour)
+
+	otherSId, err := ts.sessions.CreateSession(
+		context.Background(),
+		&session.SessionData{
+			UserId:     1,
+			ClientInfo: session.ClientInfo{Device: `Firefox on Linux`},
+		},
+	)
+
+	require.Nil(err)
+	ts.clock.Advance(time.Hour)
+
+	sId := ts.createSession(t, 1)
+
+	ts.createSession(t, 2)
+
+	recorder := ts.do(http.MethodGet, `/sessions`, sId, nil)
+
+	require.Equal(http.StatusOK, recorder.Code)
+
+	var response []sessionResponse
+
+	require.Nil(json.NewDecoder(recorder.Body).Decode(&response))
+	require.Len(response, 2)
+	require.Equal(session.SessionHandle(sId), response[0].Handle)
+	require.True(response[0].Current)
+	require.Equal(session.SessionHandle(otherSId), response[1].Handle)
+	require.False(response[1].Current)
+	require.Equal(`Firefox on Linux`, response[1].Device)
+	require.NotContains(recorder.Body.String(), expiredSId)
+	require.NotContains(recorder.Body.String(), sId)
+}
+
+func TestRevokeSession(t *testing.T) {
+	t.Parallel()
+
+	t.Run(`RevokesOtherSession`, func(t *testing.T) {
+		require := require.New(t)
+		ts := newTestServer(t)
+		sId := ts.createSession(t, 1)
+		otherSId := ts.createSession(t, 1)
+
+		recorder := ts.do(
+			http.MethodDelete,
+			`/sessions/`+session.SessionHandle(otherSId),
+			sId,
+			nil,
+		)
+
+		require.Equal(http.StatusNoContent, recorder.Code)
+		require.Empty(recorder.Result().Cookies())
+
+		_, err := ts.sessions.GetSessionData(context.Background(), otherSId)
+
+		require.ErrorIs(err, session.ErrSessionNotFound)
+	})
+
+	t.Run(`RevokesCurrentSession`, func(t *testing.T) {
+		require := require.New(t)
+		ts := newTestServer(t)
+		sId := ts.createSession(t, 1)
+
+		recorder := ts.do(
+			http.MethodDelete,
+			`/sessions/`+session.SessionHandle(sId),
+			sId,
+			nil,
+		)
+
+		require.Equal(http.StatusNoContent, recorder.Code)
+		require.Contains(recorder.Header().Get(`Set-Cookie`), `Max-Age=0`)
+	})
+
+	t.Run(`RejectsSessionOfOtherUser`, func(t *testing.T) {
+		require := require.New(t)
+		ts := newTestServer(t)
+		sId := ts.createSession(t, 1)
+		otherSId := ts.createSession(t, 2)
+
+		recorder := ts.do(
+			http.MethodDelete,
+			`/sessions/`+session.SessionHandle(otherSId),
+			sId,
+			nil,
+		)
+
+		require.Equal(http.StatusNotFound, recorder.Code)
+		require.Equal(`session_handle_not_found`, decodeError(t, recorder).Code)
+
+		_, err := ts.sessions.GetSessionData(context.Background(), otherSId)
+
+		require.Nil(err)
+	})
+}
+
+func TestSignOutOthers(t *testing.T) {
+	t.Parallel()
+
+	require := require.New(t)
+	ts := newTestServer(t)
+	sId := ts.createSession(t, 1)
+	otherSId := ts.createSession(t, 1)
+	otherUserSId := ts.createSession(t, 2)
+
+	recorder := ts.do(http.MethodDelete, `/sessions/others`, sId, nil)
+
+	require.Equal(http.StatusNoContent, recorder.Code)
+
+	_, err := ts.sessions.GetSessionData(context.Background(), otherSId)
+
+	require.ErrorIs(err, session.ErrSessionNotFound)
+
+	for _, keptSId := range []string{sId, otherUserSId} {
+		_, err = ts.sessions.GetSessionData(context.Background(), keptSId)
+
+		require.Nil(err)
+	}
+}
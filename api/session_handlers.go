@@ -0,0 +1,179 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"finanstar/server/auth"
+)
+
+type signInRequest struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+	// Optional device label shown in sessions list
+	Device string `json:"device"`
+}
+
+type signInResponse struct {
+	SessionId string `json:"sessionId"`
+	// Session is pending until POST /sessions/second-factor confirms it
+	SecondFactorRequired bool `json:"secondFactorRequired"`
+}
+
+type confirmSecondFactorRequest struct {
+	// TOTP code or recovery code
+	Code string `json:"code"`
+}
+
+type sessionResponse struct {
+	Handle string `json:"handle"`
+	// Whether it is the session the request was made with
+	Current    bool      `json:"current"`
+	Device     string    `json:"device"`
+	Ip         string    `json:"ip"`
+	UserAgent  string    `json:"userAgent"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+func (self *Server) signIn(w http.ResponseWriter, r *http.Request) {
+	var body signInRequest
+
+	if err := readJson(w, r, &body); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	result, err := self.auth.Login(
+		r.Context(),
+		body.Login,
+		body.Password,
+		clientInfoFromRequest(r, body.Device, self.options.TrustedProxies),
+	)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if result.SecondFactorRequired {
+		setSessionCookie(w, result.SessionId, auth.SECOND_FACTOR_TIMEOUT)
+	} else {
+		self.setNewSessionCookie(w, result.SessionId)
+	}
+
+	writeJson(w, http.StatusCreated, signInResponse{
+		SessionId:            result.SessionId,
+		SecondFactorRequired: result.SecondFactorRequired,
+	})
+}
+
+// Exchanges session pending second factor for a full one
+func (self *Server) confirmSecondFactor(w http.ResponseWriter, r *http.Request) {
+	pendingSId := sessionIdFromRequest(r)
+
+	if len(pendingSId) == 0 {
+		writeError(w, ErrUnauthorized)
+		return
+	}
+
+	var body confirmSecondFactorRequest
+
+	if err := readJson(w, r, &body); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	sId, err := self.auth.ConfirmSecondFactor(r.Context(), pendingSId, body.Code)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	self.setNewSessionCookie(w, sId)
+	writeJson(w, http.StatusCreated, signInResponse{SessionId: sId})
+}
+
+func (self *Server) setNewSessionCookie(w http.ResponseWriter, sId string) {
+	now := self.options.Session.Clock.Now()
+
+	setSessionCookie(w, sId, self.options.Session.ExpiresAt(now, now).Sub(now))
+}
+
+func (self *Server) signOut(w http.ResponseWriter, r *http.Request) {
+	err := self.sessions.DeleteSession(
+		r.Context(),
+		SessionIdFromContext(r.Context()),
+	)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	clearSessionCookie(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (self *Server) listSessions(w http.ResponseWriter, r *http.Request) {
+	sData := SessionDataFromContext(r.Context())
+	sessions, err := self.sessions.ListSessions(r.Context(), sData.UserId)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	currentHandle := sData.Handle
+	response := make([]sessionResponse, len(sessions))
+
+	for index, userSession := range sessions {
+		response[index] = sessionResponse{
+			Handle:     userSession.Handle,
+			Current:    userSession.Handle == currentHandle,
+			Device:     userSession.Device,
+			Ip:         userSession.Ip,
+			UserAgent:  userSession.UserAgent,
+			CreatedAt:  userSession.CreatedAt,
+			LastSeenAt: userSession.LastSeenAt,
+			ExpiresAt:  userSession.ExpiresAt,
+		}
+	}
+
+	writeJson(w, http.StatusOK, response)
+}
+
+func (self *Server) revokeSession(w http.ResponseWriter, r *http.Request) {
+	handle := r.PathValue("handle")
+	sData := SessionDataFromContext(r.Context())
+	err := self.sessions.RevokeSession(r.Context(), sData.UserId, handle)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if handle == sData.Handle {
+		clearSessionCookie(w)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Signs out everywhere except the session the request was made with
+func (self *Server) signOutOthers(w http.ResponseWriter, r *http.Request) {
+	err := self.sessions.ResetOtherSessions(
+		r.Context(),
+		SessionDataFromContext(r.Context()).UserId,
+		SessionIdFromContext(r.Context()),
+	)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
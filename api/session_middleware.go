@@ -0,0 +1,187 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"finanstar/server/auth"
+	"finanstar/server/session"
+)
+
+const (
+	DEFAULT_SESSION_RENEWAL_THRESHOLD = 24 * time.Hour
+)
+
+type sessionContextKey struct{}
+
+type sessionContextValue struct {
+	sId   string
+	sData *session.SessionData
+}
+
+type SessionMiddlewareOptions struct {
+	// Must match options SessionManager is configured with
+	session.ExpirationOptions
+	// Session is renewed once renewal extends it by at least this duration,
+	// i.e. it was issued or last renewed longer than this duration ago
+	RenewalThreshold time.Duration
+	// Defaults to session.SystemClock
+	Clock session.Clock
+}
+
+type SessionMiddleware struct {
+	sessions session.SessionManager
+	options  SessionMiddlewareOptions
+}
+
+func NewSessionMiddleware(
+	sessions session.SessionManager,
+	options *SessionMiddlewareOptions,
+) *SessionMiddleware {
+	middleware := &SessionMiddleware{
+		sessions: sessions,
+		options:  withDefaultSessionOptions(options),
+	}
+
+	return middleware
+}
+
+// Rejects requests without valid session or with session pending second
+// factor and puts session data into request context for the next handler
+func (self *SessionMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sId := sessionIdFromRequest(r)
+
+		if len(sId) == 0 {
+			writeError(w, ErrUnauthorized)
+			return
+		}
+
+		sData, err := self.sessions.GetSessionData(r.Context(), sId)
+
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		if sData.PendingSecondFactor {
+			writeError(w, auth.ErrSecondFactorRequired)
+			return
+		}
+
+		now := self.options.Clock.Now()
+
+		if renewedExpiresAt, ok := self.renewal(sData, now); ok {
+			err := self.sessions.RenewalSession(r.Context(), sId)
+
+			// Renewal is opportunistic, the session is still valid when
+			// concurrent changes keep it from being renewed
+			switch {
+			case errors.Is(err, session.ErrSessionRenewalConflict):
+			case err != nil:
+				writeError(w, err)
+				return
+			default:
+				setSessionCookie(w, sId, renewedExpiresAt.Sub(now))
+			}
+		}
+
+		ctx := context.WithValue(
+			r.Context(),
+			sessionContextKey{},
+			sessionContextValue{sId, sData},
+		)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Returns expiry renewal would set when it is worth renewing the session
+func (self *SessionMiddleware) renewal(
+	sData *session.SessionData,
+	now time.Time,
+) (time.Time, bool) {
+	renewedExpiresAt := self.options.ExpiresAt(sData.CreatedAt, now)
+	gain := renewedExpiresAt.Sub(sData.ExpiresAt)
+
+	return renewedExpiresAt, gain >= self.options.RenewalThreshold
+}
+
+func SessionIdFromContext(ctx context.Context) string {
+	value, _ := ctx.Value(sessionContextKey{}).(sessionContextValue)
+
+	return value.sId
+}
+
+func SessionDataFromContext(ctx context.Context) *session.SessionData {
+	value, _ := ctx.Value(sessionContextKey{}).(sessionContextValue)
+
+	return value.sData
+}
+
+func sessionIdFromRequest(r *http.Request) string {
+	if cookie, err := r.Cookie(SESSION_COOKIE_NAME); err == nil {
+		return cookie.Value
+	}
+
+	authorization := r.Header.Get("Authorization")
+	token, found := strings.CutPrefix(authorization, "Bearer ")
+
+	if !found {
+		return ""
+	}
+
+	return strings.TrimSpace(token)
+}
+
+func withDefaultSessionOptions(
+	options *SessionMiddlewareOptions,
+) SessionMiddlewareOptions {
+	result := SessionMiddlewareOptions{
+		RenewalThreshold: DEFAULT_SESSION_RENEWAL_THRESHOLD,
+		Clock:            session.SystemClock,
+	}
+
+	if options != nil {
+		result.ExpirationOptions = options.ExpirationOptions
+
+		if options.Clock != nil {
+			result.Clock = options.Clock
+		}
+
+		if options.RenewalThreshold > 0 {
+			result.RenewalThreshold = options.RenewalThreshold
+		}
+	}
+
+	result.ExpirationOptions = result.ExpirationOptions.WithDefaults()
+
+	return result
+}
+
+func setSessionCookie(w http.ResponseWriter, sId string, ttl time.Duration) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SESSION_COOKIE_NAME,
+		Value:    sId,
+		Path:     "/",
+		MaxAge:   int(ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SESSION_COOKIE_NAME,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"finanstar/server/session"
+)
+
+func TestSessionMiddleware(t *testing.T) {
+	t.Parallel()
+
+	expirationOptions := session.ExpirationOptions{
+		Lifetime:    10 * time.Hour,
+		IdleTimeout: 8 * time.Hour,
+	}
+
+	subtests := []struct {
+		name      string
+		useCookie bool
+		noSession bool
+		// Session was renewed when it was this old, zero means never
+		renewedAt time.Duration
+		age       time.Duration
+		renewal   bool
+		status    int
+	}{
+		{`PassesFreshSessionFromHeader`, false, false, 0, 0, false, http.StatusOK},
+		{`PassesFreshSessionFromCookie`, true, false, 0, 0, false, http.StatusOK},
+		{`RenewsOldSession`, false, false, 0, 3 * time.Hour, true, http.StatusOK},
+		{`KeepsSessionAtItsLifetime`, false, false, 3 * time.Hour, 5 * time.Hour, false, http.StatusOK},
+		{`RejectsExpiredSession`, false, false, 0, 8 * time.Hour, false, http.StatusUnauthorized},
+		{`RejectsMissingSession`, false, true, 0, 0, false, http.StatusUnauthorized},
+	}
+
+	for _, test := range subtests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+			ctx := context.Background()
+			clock := session.NewManualClock(time.Now())
+			sessions := session.NewMemorySessionManager(
+				&session.MemorySessionManagerOptions{
+					ExpirationOptions: expirationOptions,
+					Clock:             clock,
+				},
+			)
+			middleware := NewSessionMiddleware(
+				sessions,
+				&SessionMiddlewareOptions{
+					ExpirationOptions: expirationOptions,
+					RenewalThreshold:  time.Hour,
+					Clock:             clock,
+				},
+			)
+
+			sId, err := sessions.CreateSession(
+				ctx,
+				&session.SessionData{UserId: 1337},
+			)
+
+			require.Nil(err)
+
+			if test.renewedAt != 0 {
+				clock.Advance(test.renewedAt)
+				require.Nil(sessions.RenewalSession(ctx, sId))
+			}
+
+			clock.Advance(test.age - test.renewedAt)
+
+			var handledSId string
+			var handledData *session.SessionData
+
+			handler := middleware.Wrap(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					handledSId = SessionIdFromContext(r.Context())
+					handledData = SessionDataFromContext(r.Context())
+				}),
+			)
+
+			request := httptest.NewRequest(http.MethodGet, `/`, nil)
+
+			if test.noSession {
+				sId = ``
+			} else if test.useCookie {
+				request.AddCookie(&http.Cookie{Name: SESSION_COOKIE_NAME, Value: sId})
+			} else {
+				request.Header.Set(`Authorization`, `Bearer `+sId)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			require.Equal(test.status, recorder.Code)
+
+			if test.status == http.StatusOK {
+				require.Equal(sId, handledSId)
+				require.Equal(uint32(1337), handledData.UserId)
+			} else {
+				require.Nil(handledData)
+			}
+
+			if test.renewal {
+				require.Contains(recorder.Header().Get(`Set-Cookie`), sId)
+
+				sData, err := sessions.GetSessionData(ctx, sId)
+
+				require.Nil(err)
+				require.Equal(clock.Now(), sData.LastSeenAt)
+			} else {
+				require.Empty(recorder.Header().Get(`Set-Cookie`))
+			}
+		})
+	}
+}
+
+// Fails every renewal as if the session kept changing concurrently
+type conflictingSessionManager struct {
+	*session.MemorySessionManager
+}
+
+func (self conflictingSessionManager) RenewalSession(
+	ctx context.Context,
+	sId string,
+) error {
+	return session.ErrSessionRenewalConflict
+}
+
+func TestSessionMiddlewareServesOnRenewalConflict(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ctx := context.Background()
+	clock := session.NewManualClock(time.Now())
+	sessions := session.NewMemorySessionManager(
+		&session.MemorySessionManagerOptions{Clock: clock},
+	)
+	middleware := NewSessionMiddleware(
+		conflictingSessionManager{sessions},
+		&SessionMiddlewareOptions{RenewalThreshold: time.Hour, Clock: clock},
+	)
+	sId, err := sessions.CreateSession(ctx, &session.SessionData{UserId: 1337})
+
+	require.Nil(err)
+
+	clock.Advance(2 * time.Hour)
+
+	var handledSId string
+
+	handler := middleware.Wrap(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handledSId = SessionIdFromContext(r.Context())
+		}),
+	)
+	request := httptest.NewRequest(http.MethodGet, `/`, nil)
+	request.Header.Set(`Authorization`, `Bearer `+sId)
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, request)
+
+	require.Equal(http.StatusOK, recorder.Code)
+	require.Equal(sId, handledSId)
+	require.Empty(recorder.Header().Get(`Set-Cookie`))
+}
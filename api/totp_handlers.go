@@ -0,0 +1,93 @@
+package api
+
+import (
+	"net/http"
+)
+
+type totpEnrollmentResponse struct {
+	// Base32 encoded, for manual entry
+	Secret string `json:"secret"`
+	// otpauth:// URI, client renders it as QR code
+	Uri string `json:"uri"`
+}
+
+type totpCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type recoveryCodesResponse struct {
+	// Shown once, only hashes are stored
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+func (self *Server) beginTotpEnrollment(w http.ResponseWriter, r *http.Request) {
+	sData := SessionDataFromContext(r.Context())
+	currentUser, err := self.users.GetById(r.Context(), sData.UserId)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	enrollment, err := self.totp.BeginEnrollment(
+		r.Context(),
+		currentUser.Id,
+		currentUser.Login,
+	)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJson(w, http.StatusCreated, totpEnrollmentResponse{
+		Secret: enrollment.Secret,
+		Uri:    enrollment.Uri,
+	})
+}
+
+func (self *Server) confirmTotpEnrollment(w http.ResponseWriter, r *http.Request) {
+	var body totpCodeRequest
+
+	if err := readJson(w, r, &body); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	codes, err := self.totp.ConfirmEnrollment(
+		r.Context(),
+		SessionDataFromContext(r.Context()).UserId,
+		body.Code,
+	)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJson(w, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// Requires TOTP or recovery code, so stolen session alone can't turn second
+// factor off
+func (self *Server) disableTotp(w http.ResponseWriter, r *http.Request) {
+	var body totpCodeRequest
+
+	if err := readJson(w, r, &body); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	err := self.totp.Disable(
+		r.Context(),
+		SessionDataFromContext(r.Context()).UserId,
+		body.Code,
+	)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
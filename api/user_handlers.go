@@ -0,0 +1,118 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"finanstar/server/user"
+)
+
+type signUpRequest struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+}
+
+type updateProfileRequest struct {
+	Login    *string `json:"login"`
+	Password *string `json:"password"`
+}
+
+type userResponse struct {
+	Id            uint32 `json:"id"`
+	Login         string `json:"login"`
+	EmailVerified bool   `json:"emailVerified"`
+}
+
+type whoAmIResponse struct {
+	UserId uint32 `json:"userId"`
+}
+
+func makeUserResponse(dto *user.UserDto) userResponse {
+	return userResponse{
+		Id:            dto.Id,
+		Login:         dto.Login,
+		EmailVerified: dto.EmailVerifiedAt != nil,
+	}
+}
+
+// Account change has already succeeded, so failed email is only logged and
+// the user can request it again
+func (self *Server) sendEmailVerification(r *http.Request, target *user.UserDto) {
+	err := self.verification.SendVerification(r.Context(), target)
+
+	if err != nil {
+		log.Printf("Failed to send email verification: %v", err)
+	}
+}
+
+func (self *Server) signUp(w http.ResponseWriter, r *http.Request) {
+	var body signUpRequest
+
+	if err := readJson(w, r, &body); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if len(body.Login) == 0 || len(body.Password) == 0 {
+		writeError(w, ErrInvalidRequestBody)
+		return
+	}
+
+	createdUser, err := self.users.Create(
+		r.Context(),
+		user.CreateUserDto{Login: body.Login, Password: body.Password},
+	)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	self.sendEmailVerification(r, createdUser)
+	writeJson(w, http.StatusCreated, makeUserResponse(createdUser))
+}
+
+func (self *Server) whoAmI(w http.ResponseWriter, r *http.Request) {
+	sData := SessionDataFromContext(r.Context())
+
+	writeJson(w, http.StatusOK, whoAmIResponse{UserId: sData.UserId})
+}
+
+func (self *Server) updateProfile(w http.ResponseWriter, r *http.Request) {
+	sData := SessionDataFromContext(r.Context())
+
+	var body updateProfileRequest
+
+	if err := readJson(w, r, &body); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Changing login sends new verification email, so it's allowed to
+	// unverified users and the rest needs verified email as other writes do
+	if body.Password != nil {
+		err := self.verification.CheckWrite(r.Context(), sData.UserId)
+
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	updatedUser, err := self.users.Update(
+		r.Context(),
+		sData.UserId,
+		user.UpdateUserDto{Login: body.Login, Password: body.Password},
+	)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if body.Login != nil {
+		self.sendEmailVerification(r, updatedUser)
+	}
+
+	writeJson(w, http.StatusOK, makeUserResponse(updatedUser))
+}
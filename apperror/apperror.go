@@ -0,0 +1,25 @@
+package apperror
+
+// Domain errors carrying the underlying cause. Both the domain error and the
+// cause are matched by errors.Is/errors.As, message is the domain one.
+
+type Error struct {
+	Kind  error
+	Cause error
+}
+
+func Wrap(kind error, cause error) error {
+	if cause == nil {
+		return kind
+	}
+
+	return &Error{Kind: kind, Cause: cause}
+}
+
+func (self *Error) Error() string {
+	return self.Kind.Error()
+}
+
+func (self *Error) Unwrap() []error {
+	return []error{self.Kind, self.Cause}
+}
@@ -0,0 +1,33 @@
+package apperror
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrap(t *testing.T) {
+	require := require.New(t)
+
+	kind := errors.New(`User not found`)
+	cause := errors.New(`no rows in result set`)
+	err := Wrap(kind, cause)
+
+	require.EqualError(err, kind.Error())
+	require.ErrorIs(err, kind)
+	require.ErrorIs(err, cause)
+
+	var appErr *Error
+
+	require.ErrorAs(err, &appErr)
+	require.Equal(cause, appErr.Cause)
+}
+
+func TestWrapWithoutCause(t *testing.T) {
+	require := require.New(t)
+
+	kind := errors.New(`User not found`)
+
+	require.Equal(kind, Wrap(kind, nil))
+}
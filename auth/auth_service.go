@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"finanstar/server/crypto"
+	"finanstar/server/session"
+	"finanstar/server/user"
+)
+
+const (
+	// How long session waits for second factor after password was verified
+	SECOND_FACTOR_TIMEOUT = session.PENDING_SESSION_TIMEOUT
+)
+
+const (
+	INVALID_CREDENTIALS_ERROR       = "Invalid login or password"
+	SECOND_FACTOR_REQUIRED_ERROR    = "Second factor confirmation is required"
+	SECOND_FACTOR_NOT_PENDING_ERROR = "Session doesn't wait for second factor"
+	SECOND_FACTOR_EXPIRED_ERROR     = "Second factor confirmation has expired"
+)
+
+var (
+	ErrInvalidCredentials     = errors.New(INVALID_CREDENTIALS_ERROR)
+	ErrSecondFactorRequired   = errors.New(SECOND_FACTOR_REQUIRED_ERROR)
+	ErrSecondFactorNotPending = errors.New(SECOND_FACTOR_NOT_PENDING_ERROR)
+	ErrSecondFactorExpired    = errors.New(SECOND_FACTOR_EXPIRED_ERROR)
+)
+
+type AuthServiceOptions struct {
+	// Defaults to session.SystemClock
+	Clock session.Clock
+}
+
+type AuthService struct {
+	users        *user.UserService
+	sessions     session.SessionManager
+	totp         *TotpService
+	verification *EmailVerificationService
+	limiter      LoginLimiter
+	options      AuthServiceOptions
+	// Compared against when login is unknown, so response time doesn't
+	// reveal whether the user exists
+	dummyPasswordHash string
+}
+
+type LoginResult struct {
+	SessionId string
+	// Session is pending until ConfirmSecondFactor replaces it
+	SecondFactorRequired bool
+}
+
+func NewAuthService(
+	users *user.UserService,
+	sessions session.SessionManager,
+	totp *TotpService,
+	verification *EmailVerificationService,
+	limiter LoginLimiter,
+	options *AuthServiceOptions,
+) AuthService {
+	// Made upfront with current params, so the first unknown login isn't
+	// slower than the rest
+	dummyPasswordHash, err := crypto.HashPassword(`dummy-password`)
+
+	if err != nil {
+		panic("Failed to hash dummy password: " + err.Error())
+	}
+
+	service := AuthService{
+		users:             users,
+		sessions:          sessions,
+		totp:              totp,
+		verification:      verification,
+		limiter:           limiter,
+		dummyPasswordHash: dummyPasswordHash,
+	}
+
+	if options != nil {
+		service.options = *options
+	}
+
+	if service.options.Clock == nil {
+		service.options.Clock = session.SystemClock
+	}
+
+	return service
+}
+
+// Verifies credentials and creates session for the client. When user has
+// second factor enabled the session is pending, see ConfirmSecondFactor, and
+// the attempt stays counted as failure until the code is confirmed. Email
+// verification policy is checked only once password matches, so it doesn't
+// reveal whether the login exists. Fails with *LoginThrottledError after too
+// many failures of the login or client IP
+func (self *AuthService) Login(
+	ctx context.Context,
+	login string,
+	password string,
+	client session.ClientInfo,
+) (*LoginResult, error) {
+	attempt, err := self.limiter.Attempt(ctx, login, client.Ip)
+
+	if err != nil {
+		return nil, err
+	}
+
+	foundUser, err := self.users.GetByLogin(ctx, login)
+
+	if err != nil && !errors.Is(err, user.ErrUserNotFound) {
+		return nil, err
+	}
+
+	if foundUser == nil {
+		crypto.ComparePasswords(password, self.dummyPasswordHash)
+		self.limiter.Failed(ctx, attempt)
+
+		return nil, ErrInvalidCredentials
+	}
+
+	match, err := crypto.ComparePasswords(password, foundUser.Password)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !match {
+		self.limiter.Failed(ctx, attempt)
+
+		return nil, ErrInvalidCredentials
+	}
+
+	// Failed upgrade is retried on the next sign in
+	if err = self.users.UpgradePasswordHash(ctx, foundUser, password); err != nil {
+		log.Printf("Failed to upgrade password hash: %v", err)
+	}
+
+	secondFactorRequired, err := self.totp.IsEnabled(ctx, foundUser.Id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if secondFactorRequired {
+		// Pending session doesn't keep the attempt, so lockout it has caused
+		// is reported right away
+		self.limiter.Failed(ctx, attempt)
+	} else if err = self.limiter.Succeeded(ctx, attempt); err != nil {
+		return nil, err
+	}
+
+	if err = self.verification.CheckSignIn(foundUser); err != nil {
+		return nil, err
+	}
+
+	sId, err := self.sessions.CreateSession(ctx, &session.SessionData{
+		UserId:              foundUser.Id,
+		ClientInfo:          client,
+		PendingSecondFactor: secondFactorRequired,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoginResult{
+		SessionId:            sId,
+		SecondFactorRequired: secondFactorRequired,
+	}, nil
+}
+
+// Replaces pending session with a full one once code is verified. Pending
+// session is deleted before the code is checked, so every password
+// verification allows single guess and wrong code requires signing in again.
+// Sign in attempt is counted as success only once the code matches, besides
+// wrong codes are limited per user, see TotpServiceOptions
+func (self *AuthService) ConfirmSecondFactor(
+	ctx context.Context,
+	pendingSId string,
+	code string,
+) (string, error) {
+	sData, err := self.sessions.GetSessionData(ctx, pendingSId)
+
+	if err != nil {
+		return "", err
+	}
+
+	if !sData.PendingSecondFactor {
+		return "", ErrSecondFactorNotPending
+	}
+
+	// Concurrent confirmation has claimed the session
+	if err = self.sessions.DeleteSession(ctx, pendingSId); err != nil {
+		return "", err
+	}
+
+	// Pending sessions expire with SECOND_FACTOR_TIMEOUT, unless they were
+	// created before it was enforced by session managers
+	pendingFor := self.options.Clock.Now().Sub(sData.CreatedAt)
+
+	if pendingFor > SECOND_FACTOR_TIMEOUT {
+		return "", ErrSecondFactorExpired
+	}
+
+	if err = self.totp.Verify(ctx, sData.UserId, code); err != nil {
+		return "", err
+	}
+
+	foundUser, err := self.users.GetById(ctx, sData.UserId)
+
+	if err != nil {
+		return "", err
+	}
+
+	err = self.limiter.Succeeded(ctx, &LoginAttempt{
+		Login: foundUser.Login,
+		Ip:    sData.Ip,
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return self.sessions.CreateSession(ctx, &session.SessionData{
+		UserId:     sData.UserId,
+		ClientInfo: sData.ClientInfo,
+	})
+}
@@ -0,0 +1,424 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/argon2id"
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/require"
+
+	"finanstar/server/crypto"
+	"finanstar/server/session"
+	"finanstar/server/user"
+)
+
+func TestLogin(t *testing.T) {
+	t.Parallel()
+
+	testLogin := `test@example.com`
+	hashedPassword, err := crypto.HashPassword(`secure-password`)
+
+	require.Nil(t, err)
+
+	outdatedParams := crypto.DefaultPasswordHashParams()
+	outdatedParams.Iterations--
+	outdatedHashedPassword, err := argon2id.CreateHash(`secure-password`, &outdatedParams)
+
+	require.Nil(t, err)
+
+	subtests := []struct {
+		name       string
+		password   string
+		userExists bool
+		// Stored hash uses outdated parameters
+		outdatedHash bool
+		// Email verification policy, user email isn't verified
+		policy  string
+		dbError error
+		error   error
+	}{
+		{
+			name:       `ReturnsSessionId`,
+			password:   `secure-password`,
+			userExists: true,
+		},
+		{
+			name:       `RejectsWrongPassword`,
+			password:   `wrong-password`,
+			userExists: true,
+			error:      errors.New(INVALID_CREDENTIALS_ERROR),
+		},
+		{
+			name:       `RejectsUnverifiedEmail`,
+			password:   `secure-password`,
+			userExists: true,
+			policy:     EMAIL_VERIFICATION_POLICY_NO_SIGN_IN,
+			error:      errors.New(EMAIL_NOT_VERIFIED_ERROR),
+		},
+		{
+			name:       `ChecksPasswordBeforeEmailVerification`,
+			password:   `wrong-password`,
+			userExists: true,
+			policy:     EMAIL_VERIFICATION_POLICY_NO_SIGN_IN,
+			error:      errors.New(INVALID_CREDENTIALS_ERROR),
+		},
+		{
+			name:       `AllowsUnverifiedEmailWhenReadOnly`,
+			password:   `secure-password`,
+			userExists: true,
+			policy:     EMAIL_VERIFICATION_POLICY_READ_ONLY,
+		},
+		{
+			name:         `UpgradesOutdatedHash`,
+			password:     `secure-password`,
+			userExists:   true,
+			outdatedHash: true,
+		},
+		{
+			name:         `KeepsOutdatedHashOnWrongPassword`,
+			password:     `wrong-password`,
+			userExists:   true,
+			outdatedHash: true,
+			error:        errors.New(INVALID_CREDENTIALS_ERROR),
+		},
+		{
+			name:     `RejectsUnknownLogin`,
+			password: `secure-password`,
+			error:    errors.New(INVALID_CREDENTIALS_ERROR),
+		},
+		{
+			name:     `ReturnsUnknownError`,
+			password: `secure-password`,
+			dbError:  errors.New(`UnknownError`),
+			error:    errors.New(`UnknownError`),
+		},
+	}
+
+	for _, test := range subtests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+			db, err := pgxmock.NewPool()
+
+			require.Nil(err)
+
+			sessions := session.NewMemorySessionManager(nil)
+			userRepository := user.NewPostgresqlUserRepository(db)
+			userService := user.NewUserService(&userRepository)
+			totp := newTestTotp()
+			verification := newTestEmailVerification(&userService, test.policy)
+			authService := NewAuthService(
+				&userService,
+				sessions,
+				totp.service,
+				verification.service,
+				NopLoginLimiter{},
+				nil,
+			)
+
+			rows := db.NewRows([]string{`id`, `login`, `password`, `email_verified_at`})
+
+			storedHash := hashedPassword
+
+			if test.outdatedHash {
+				storedHash = outdatedHashedPassword
+			}
+
+			if test.userExists {
+				rows.AddRow(uint32(1), testLogin, storedHash, nil)
+			}
+
+			query := db.
+				ExpectQuery(`SELECT id, login, password, email_verified_at FROM users`).
+				WithArgs(testLogin)
+
+			if test.dbError != nil {
+				query.WillReturnError(test.dbError)
+			} else {
+				query.WillReturnRows(rows)
+			}
+
+			if test.outdatedHash && test.error == nil {
+				db.
+					ExpectQuery(`UPDATE users SET password = \$2 WHERE id = \$1 AND password = \$3`).
+					WithArgs(uint32(1), pgxmock.AnyArg(), storedHash).
+					WillReturnRows(
+						db.NewRows([]string{`login`, `password`, `email_verified_at`}).
+							AddRow(testLogin, hashedPassword, nil),
+					)
+			}
+
+			result, err := authService.Login(
+				context.Background(),
+				testLogin,
+				test.password,
+				session.ClientInfo{
+					Ip:        `192.0.2.1`,
+					UserAgent: `curl/8.0`,
+					Device:    `Laptop`,
+				},
+			)
+
+			if test.error != nil {
+				require.EqualError(err, test.error.Error())
+				require.Nil(result)
+			} else {
+				require.Nil(err)
+				require.Len(result.SessionId, session.SESSION_ID_LENGTH*2)
+				require.False(result.SecondFactorRequired)
+
+				sData, err := sessions.GetSessionData(
+					context.Background(),
+					result.SessionId,
+				)
+
+				require.Nil(err)
+				require.Equal(uint32(1), sData.UserId)
+				require.False(sData.PendingSecondFactor)
+				require.Equal(session.ClientInfo{
+					Ip:        `192.0.2.1`,
+					UserAgent: `curl/8.0`,
+					Device:    `Laptop`,
+				}, sData.ClientInfo)
+			}
+
+			require.Nil(db.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestLoginIsLimited(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ctx := context.Background()
+	hashedPassword, err := crypto.HashPassword(`secure-password`)
+
+	require.Nil(err)
+
+	users, db := newTestUsers(t)
+	ll := newTestLoginLimiter(t)
+	verification := newTestEmailVerification(users, ``)
+	authService := NewAuthService(
+		users,
+		session.NewMemorySessionManager(nil),
+		newTestTotp().service,
+		verification.service,
+		ll.limiter,
+		nil,
+	)
+	client := session.ClientInfo{Ip: `192.0.2.1`}
+	login := func(password string) error {
+		_, err := authService.Login(ctx, `test@example.com`, password, client)
+
+		return err
+	}
+	expectUser := func() {
+		db.
+			ExpectQuery(`SELECT id, login, password, email_verified_at FROM users`).
+			WithArgs(`test@example.com`).
+			WillReturnRows(
+				db.NewRows([]string{`id`, `login`, `password`, `email_verified_at`}).
+					AddRow(uint32(1), `test@example.com`, hashedPassword, nil),
+			)
+	}
+
+	for range 3 {
+		expectUser()
+		require.ErrorIs(login(`wrong-password`), ErrInvalidCredentials)
+	}
+
+	// Throttled attempt doesn't reach the password check
+	require.ErrorIs(login(`secure-password`), ErrLoginThrottled)
+
+	ll.advance(time.Second)
+	expectUser()
+	require.Nil(login(`secure-password`))
+
+	// Success forgets failures of the login
+	for range 2 {
+		expectUser()
+		require.ErrorIs(login(`wrong-password`), ErrInvalidCredentials)
+	}
+
+	expectUser()
+	require.Nil(login(`secure-password`))
+	require.Nil(db.ExpectationsWereMet())
+}
+
+type testSecondFactor struct {
+	auth     AuthService
+	totp     testTotp
+	sessions *session.MemorySessionManager
+	db       pgxmock.PgxPoolIface
+	limiter  *testLoginLimiter
+	// Secret of user 1 with second factor enabled
+	secret string
+	// Pending session of user 1
+	pendingSId string
+}
+
+// Signs in user 1 with second factor enabled
+func newTestSecondFactor(t *testing.T) testSecondFactor {
+	require := require.New(t)
+	db, err := pgxmock.NewPool()
+
+	require.Nil(err)
+
+	hashedPassword, err := crypto.HashPassword(`secure-password`)
+
+	require.Nil(err)
+
+	totp := newTestTotp()
+	sessions := session.NewMemorySessionManager(
+		&session.MemorySessionManagerOptions{Clock: totp.clock},
+	)
+	userRepository := user.NewPostgresqlUserRepository(db)
+	userService := user.NewUserService(&userRepository)
+	verification := newTestEmailVerification(&userService, ``)
+	ll := newTestLoginLimiter(t)
+	authService := NewAuthService(
+		&userService,
+		sessions,
+		totp.service,
+		verification.service,
+		ll.limiter,
+		&AuthServiceOptions{Clock: totp.clock},
+	)
+	secret, _ := totp.enable(t, 1)
+
+	totp.clock.Advance(crypto.TOTP_PERIOD)
+	db.
+		ExpectQuery(`SELECT id, login, password, email_verified_at FROM users`).
+		WithArgs(`test@example.com`).
+		WillReturnRows(
+			db.NewRows([]string{`id`, `login`, `password`, `email_verified_at`}).
+				AddRow(uint32(1), `test@example.com`, hashedPassword, nil),
+		)
+
+	result, err := authService.Login(
+		context.Background(),
+		`test@example.com`,
+		`secure-password`,
+		session.ClientInfo{Ip: `192.0.2.1`, Device: `Laptop`},
+	)
+
+	require.Nil(err)
+	require.True(result.SecondFactorRequired)
+	require.Nil(db.ExpectationsWereMet())
+
+	return testSecondFactor{
+		auth:       authService,
+		totp:       totp,
+		sessions:   sessions,
+		db:         db,
+		limiter:    ll,
+		secret:     secret,
+		pendingSId: result.SessionId,
+	}
+}
+
+func TestConfirmSecondFactor(t *testing.T) {
+	t.Parallel()
+
+	t.Run(`ReplacesPendingSession`, func(t *testing.T) {
+		require := require.New(t)
+		ctx := context.Background()
+		sf := newTestSecondFactor(t)
+
+		sData, err := sf.sessions.GetSessionData(ctx, sf.pendingSId)
+
+		require.Nil(err)
+		require.True(sData.PendingSecondFactor)
+
+		// Sign in attempt stays counted until the code is confirmed
+		loginKey := sf.limiter.limiter.loginAttemptsKey(`test@example.com`)
+		ipKey := sf.limiter.limiter.ipAttemptsKey(`192.0.2.1`)
+
+		require.Equal(`1`, sf.limiter.server.HGet(loginKey, `failures`))
+		require.Equal(`1`, sf.limiter.server.HGet(ipKey, `failures`))
+
+		sf.db.
+			ExpectQuery(`SELECT id, login, password, email_verified_at FROM users WHERE id = \$1;`).
+			WithArgs(uint32(1)).
+			WillReturnRows(
+				sf.db.NewRows([]string{`id`, `login`, `password`, `email_verified_at`}).
+					AddRow(uint32(1), `test@example.com`, `hash`, nil),
+			)
+
+		sId, err := sf.auth.ConfirmSecondFactor(
+			ctx,
+			sf.pendingSId,
+			sf.totp.code(t, sf.secret, 0),
+		)
+
+		require.Nil(err)
+
+		sData, err = sf.sessions.GetSessionData(ctx, sId)
+
+		require.Nil(err)
+		require.Equal(uint32(1), sData.UserId)
+		require.Equal(`Laptop`, sData.Device)
+		require.False(sData.PendingSecondFactor)
+		require.False(sf.limiter.server.Exists(loginKey))
+		require.Equal(`0`, sf.limiter.server.HGet(ipKey, `failures`))
+		require.Nil(sf.db.ExpectationsWereMet())
+
+		_, err = sf.sessions.GetSessionData(ctx, sf.pendingSId)
+
+		require.ErrorIs(err, session.ErrSessionNotFound)
+
+		// Full session can't be confirmed again
+		_, err = sf.auth.ConfirmSecondFactor(ctx, sId, sf.totp.code(t, sf.secret, 1))
+
+		require.ErrorIs(err, ErrSecondFactorNotPending)
+	})
+
+	t.Run(`WrongCodeDeletesPendingSession`, func(t *testing.T) {
+		require := require.New(t)
+		ctx := context.Background()
+		sf := newTestSecondFactor(t)
+
+		_, err := sf.auth.ConfirmSecondFactor(
+			ctx,
+			sf.pendingSId,
+			sf.totp.code(t, sf.secret, 5),
+		)
+
+		require.ErrorIs(err, ErrInvalidSecondFactor)
+
+		_, err = sf.auth.ConfirmSecondFactor(
+			ctx,
+			sf.pendingSId,
+			sf.totp.code(t, sf.secret, 0),
+		)
+
+		require.ErrorIs(err, session.ErrSessionNotFound)
+
+		// Wrong code leaves sign in attempt counted as failure
+		require.Equal(
+			`1`,
+			sf.limiter.server.HGet(
+				sf.limiter.limiter.loginAttemptsKey(`test@example.com`),
+				`failures`,
+			),
+		)
+	})
+
+	t.Run(`RejectsExpiredPendingSession`, func(t *testing.T) {
+		require := require.New(t)
+		sf := newTestSecondFactor(t)
+
+		sf.totp.clock.Advance(SECOND_FACTOR_TIMEOUT)
+
+		// Pending session expires with SECOND_FACTOR_TIMEOUT
+		_, err := sf.auth.ConfirmSecondFactor(
+			context.Background(),
+			sf.pendingSId,
+			sf.totp.code(t, sf.secret, 0),
+		)
+
+		require.ErrorIs(err, session.ErrSessionNotFound)
+	})
+}
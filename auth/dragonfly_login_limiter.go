@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"finanstar/server/session"
+)
+
+// Implementation of login limiter using Dragonfly DB. Every login and IP has
+// attempt counter hash expiring after failure window or block, whichever is
+// longer. Attempts are counted by atomic Lua scripts, see lua directory.
+// Logins come from clients as is, so counter keys hold their hashes
+
+const (
+	LOGIN_ATTEMPTS_KEY_PREFIX = "login-attempts"
+	// Second factor codes guesses are counted per user id, see
+	// TotpServiceOptions
+	SECOND_FACTOR_ATTEMPTS_KEY_PREFIX = "second-factor-attempts"
+)
+
+var (
+	//go:embed lua/login_attempt.lua
+	loginAttemptSource string
+	//go:embed lua/login_succeeded.lua
+	loginSucceededSource string
+)
+
+var (
+	loginAttemptScript   = redis.NewScript(loginAttemptSource)
+	loginSucceededScript = redis.NewScript(loginSucceededSource)
+)
+
+type LoginLimiterOptions struct {
+	// Unset fields default to DefaultLoginLimits
+	Login LoginLimits
+	// Unset fields default to DefaultIpLimits
+	Ip LoginLimits
+	// Defaults to NopLoginLockoutObserver
+	Observer LoginLockoutObserver
+	// Defaults to session.SystemClock
+	Clock session.Clock
+	// Namespaces counters, so several limiters can share a database. Defaults
+	// to LOGIN_ATTEMPTS_KEY_PREFIX
+	KeyPrefix string
+}
+
+type DragonflyLoginLimiter struct {
+	client  *redis.Client
+	options LoginLimiterOptions
+}
+
+func NewDragonflyLoginLimiter(
+	client *redis.Client,
+	options *LoginLimiterOptions,
+) *DragonflyLoginLimiter {
+	limiter := &DragonflyLoginLimiter{client: client}
+
+	if options != nil {
+		limiter.options = *options
+	}
+
+	limiter.options.Login = limiter.options.Login.withDefaults(DefaultLoginLimits())
+	limiter.options.Ip = limiter.options.Ip.withDefaults(DefaultIpLimits())
+
+	if limiter.options.Observer == nil {
+		limiter.options.Observer = NopLoginLockoutObserver{}
+	}
+
+	if limiter.options.Clock == nil {
+		limiter.options.Clock = session.SystemClock
+	}
+
+	if limiter.options.KeyPrefix == "" {
+		limiter.options.KeyPrefix = LOGIN_ATTEMPTS_KEY_PREFIX
+	}
+
+	return limiter
+}
+
+func (self *DragonflyLoginLimiter) Attempt(
+	ctx context.Context,
+	login string,
+	ip string,
+) (*LoginAttempt, error) {
+	now := self.options.Clock.Now()
+	keys := []string{self.loginAttemptsKey(login)}
+	args := append([]any{now.UnixMilli()}, encodeLoginLimits(self.options.Login)...)
+
+	if ip != "" {
+		keys = append(keys, self.ipAttemptsKey(ip))
+		args = append(args, encodeLoginLimits(self.options.Ip)...)
+	}
+
+	result, err := loginAttemptScript.Run(ctx, self.client, keys, args...).Int64Slice()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if result[0] == 0 {
+		var blockedUntil int64
+
+		for _, until := range result[1:] {
+			blockedUntil = max(blockedUntil, until)
+		}
+
+		return nil, &LoginThrottledError{
+			RetryAfter: time.UnixMilli(blockedUntil).Sub(now),
+		}
+	}
+
+	attempt := &LoginAttempt{
+		Login:            login,
+		Ip:               ip,
+		LoginLockedUntil: decodeLockedUntil(result[1]),
+	}
+
+	if ip != "" {
+		attempt.IpLockedUntil = decodeLockedUntil(result[2])
+	}
+
+	return attempt, nil
+}
+
+func (self *DragonflyLoginLimiter) Failed(ctx context.Context, attempt *LoginAttempt) {
+	reportLockouts(ctx, self.options.Observer, attempt)
+}
+
+func (self *DragonflyLoginLimiter) Succeeded(
+	ctx context.Context,
+	attempt *LoginAttempt,
+) error {
+	keys := []string{self.loginAttemptsKey(attempt.Login)}
+
+	if attempt.Ip != "" {
+		keys = append(keys, self.ipAttemptsKey(attempt.Ip))
+	}
+
+	return loginSucceededScript.
+		Run(ctx, self.client, keys, self.options.Ip.FreeFailures).
+		Err()
+}
+
+func (self *DragonflyLoginLimiter) Unlock(ctx context.Context, login string) error {
+	return self.client.Del(ctx, self.loginAttemptsKey(login)).Err()
+}
+
+func (self *DragonflyLoginLimiter) UnlockIp(ctx context.Context, ip string) error {
+	return self.client.Del(ctx, self.ipAttemptsKey(ip)).Err()
+}
+
+// Login is trimmed and lowercased, so its variations share the counter, and
+// hashed, so key length doesn't depend on it
+func (self *DragonflyLoginLimiter) loginAttemptsKey(login string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(login))))
+
+	return self.options.KeyPrefix + ":login:" + hex.EncodeToString(sum[:])
+}
+
+func (self *DragonflyLoginLimiter) ipAttemptsKey(ip string) string {
+	return self.options.KeyPrefix + ":ip:" + ip
+}
+
+// Script arguments in order expected by login_attempt.lua
+func encodeLoginLimits(limits LoginLimits) []any {
+	return []any{
+		limits.FreeFailures,
+		limits.BackoffBase.Milliseconds(),
+		limits.BackoffMax.Milliseconds(),
+		limits.LockoutThreshold,
+		limits.LockoutTime.Milliseconds(),
+		limits.FailureWindow.Milliseconds(),
+	}
+}
+
+func decodeLockedUntil(value int64) time.Time {
+	if value == 0 {
+		return time.Time{}
+	}
+
+	return time.UnixMilli(value)
+}
@@ -0,0 +1,282 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+
+	"finanstar/server/session"
+)
+
+type lockout struct {
+	subject string
+	until   time.Time
+}
+
+// Keeps reported lockouts
+type recordingLockoutObserver struct {
+	mu     sync.Mutex
+	logins []lockout
+	ips    []lockout
+}
+
+func (self *recordingLockoutObserver) OnLoginLocked(
+	ctx context.Context,
+	login string,
+	until time.Time,
+) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	self.logins = append(self.logins, lockout{login, until})
+}
+
+func (self *recordingLockoutObserver) OnIpLocked(
+	ctx context.Context,
+	ip string,
+	until time.Time,
+) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	self.ips = append(self.ips, lockout{ip, until})
+}
+
+type testLoginLimiter struct {
+	limiter  *DragonflyLoginLimiter
+	server   *miniredis.Miniredis
+	clock    *session.ManualClock
+	observer *recordingLockoutObserver
+}
+
+// Limiter backed by in-process Redis stand-in. Login backs off after 2 free
+// failures and is locked out by the 5th one, IP is locked out by the 8th one
+func newTestLoginLimiter(t *testing.T) *testLoginLimiter {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	clock := session.NewManualClock(time.UnixMilli(time.Now().UnixMilli()))
+	observer := &recordingLockoutObserver{}
+
+	t.Cleanup(func() { client.Close() })
+
+	return &testLoginLimiter{
+		limiter: NewDragonflyLoginLimiter(client, &LoginLimiterOptions{
+			Login: LoginLimits{
+				FreeFailures:     2,
+				BackoffBase:      time.Second,
+				BackoffMax:       3 * time.Second,
+				LockoutThreshold: 5,
+				LockoutTime:      time.Hour,
+			},
+			Ip: LoginLimits{
+				FreeFailures:     100,
+				LockoutThreshold: 8,
+				LockoutTime:      time.Hour,
+			},
+			Observer: observer,
+			Clock:    clock,
+		}),
+		server:   server,
+		clock:    clock,
+		observer: observer,
+	}
+}
+
+func (self *testLoginLimiter) advance(duration time.Duration) {
+	self.clock.Advance(duration)
+	self.server.FastForward(duration)
+}
+
+// Makes attempt which fails to verify credentials
+func (self *testLoginLimiter) fail(t *testing.T, login string, ip string) {
+	ctx := context.Background()
+	attempt, err := self.limiter.Attempt(ctx, login, ip)
+
+	require.Nil(t, err)
+
+	self.limiter.Failed(ctx, attempt)
+}
+
+func (self *testLoginLimiter) requireThrottled(
+	t *testing.T,
+	login string,
+	ip string,
+	retryAfter time.Duration,
+) {
+	_, err := self.limiter.Attempt(context.Background(), login, ip)
+
+	var throttled *LoginThrottledError
+
+	require.ErrorIs(t, err, ErrLoginThrottled)
+	require.ErrorAs(t, err, &throttled)
+	require.Equal(t, retryAfter, throttled.RetryAfter)
+}
+
+func TestLoginLimiterBackoff(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ll := newTestLoginLimiter(t)
+
+	ll.fail(t, `test@example.com`, `192.0.2.1`)
+	ll.fail(t, `test@example.com`, `192.0.2.1`)
+	ll.fail(t, `test@example.com`, `192.0.2.1`)
+	ll.requireThrottled(t, `test@example.com`, `192.0.2.1`, time.Second)
+
+	// Other logins aren't affected
+	ll.fail(t, `other@example.com`, `192.0.2.1`)
+
+	ll.advance(time.Second)
+	ll.fail(t, `test@example.com`, `192.0.2.2`)
+	ll.requireThrottled(t, `test@example.com`, `192.0.2.2`, 2*time.Second)
+
+	ll.advance(2 * time.Second)
+	ll.fail(t, `test@example.com`, `192.0.2.2`)
+
+	// Lockout
+	ll.requireThrottled(t, `test@example.com`, `192.0.2.2`, time.Hour)
+	require.Equal(
+		[]lockout{{`test@example.com`, ll.clock.Now().Add(time.Hour)}},
+		ll.observer.logins,
+	)
+
+	// Counting starts over after lockout
+	ll.advance(time.Hour)
+	ll.fail(t, `test@example.com`, `192.0.2.3`)
+	ll.fail(t, `test@example.com`, `192.0.2.3`)
+	ll.fail(t, `test@example.com`, `192.0.2.3`)
+	ll.requireThrottled(t, `test@example.com`, `192.0.2.3`, time.Second)
+	require.Len(ll.observer.logins, 1)
+}
+
+func TestLoginLimiterBackoffIsCapped(t *testing.T) {
+	t.Parallel()
+	ll := newTestLoginLimiter(t)
+	ll.limiter.options.Login.LockoutThreshold = 100
+
+	for range 3 {
+		ll.fail(t, `test@example.com`, ``)
+	}
+
+	for _, backoff := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second} {
+		ll.requireThrottled(t, `test@example.com`, ``, backoff)
+		ll.advance(backoff)
+		ll.fail(t, `test@example.com`, ``)
+	}
+
+	ll.requireThrottled(t, `test@example.com`, ``, 3*time.Second)
+}
+
+func TestLoginLimiterIpLockout(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ll := newTestLoginLimiter(t)
+
+	// Different login every time, so only IP counter adds up
+	for _, login := range []string{`a`, `b`, `c`, `d`, `e`, `f`, `g`, `h`} {
+		ll.fail(t, login, `192.0.2.1`)
+	}
+
+	ll.requireThrottled(t, `i`, `192.0.2.1`, time.Hour)
+	require.Equal(
+		[]lockout{{`192.0.2.1`, ll.clock.Now().Add(time.Hour)}},
+		ll.observer.ips,
+	)
+
+	// Other IPs aren't affected
+	ll.fail(t, `i`, `192.0.2.2`)
+
+	require.Nil(ll.limiter.UnlockIp(context.Background(), `192.0.2.1`))
+	ll.fail(t, `i`, `192.0.2.1`)
+}
+
+func TestLoginLimiterSucceeded(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ctx := context.Background()
+	ll := newTestLoginLimiter(t)
+
+	ll.fail(t, `test@example.com`, `192.0.2.1`)
+	ll.fail(t, `test@example.com`, `192.0.2.1`)
+
+	attempt, err := ll.limiter.Attempt(ctx, `test@example.com`, `192.0.2.1`)
+
+	require.Nil(err)
+	require.Nil(ll.limiter.Succeeded(ctx, attempt))
+
+	// Login failures are forgotten, successful attempt isn't counted for IP
+	require.False(ll.server.Exists(ll.limiter.loginAttemptsKey(`test@example.com`)))
+	require.Equal(`2`, ll.server.HGet(ll.limiter.ipAttemptsKey(`192.0.2.1`), `failures`))
+
+	ll.fail(t, `test@example.com`, `192.0.2.1`)
+	ll.fail(t, `test@example.com`, `192.0.2.1`)
+	ll.fail(t, `test@example.com`, `192.0.2.1`)
+	ll.requireThrottled(t, `test@example.com`, `192.0.2.1`, time.Second)
+}
+
+func TestLoginLimiterSucceededLiftsIpBlock(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ctx := context.Background()
+	ll := newTestLoginLimiter(t)
+
+	for _, login := range []string{`a`, `b`, `c`, `d`, `e`, `f`, `g`} {
+		ll.fail(t, login, `192.0.2.1`)
+	}
+
+	// Attempt reaching IP lockout threshold succeeds
+	attempt, err := ll.limiter.Attempt(ctx, `test@example.com`, `192.0.2.1`)
+
+	require.Nil(err)
+	require.False(attempt.IpLockedUntil.IsZero())
+	require.Nil(ll.limiter.Succeeded(ctx, attempt))
+
+	ll.fail(t, `h`, `192.0.2.1`)
+}
+
+func TestLoginLimiterNormalizesLogin(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ll := newTestLoginLimiter(t)
+
+	ll.fail(t, `test@example.com`, ``)
+	ll.fail(t, ` Test@Example.com`, ``)
+	ll.fail(t, `TEST@EXAMPLE.COM `, ``)
+	ll.requireThrottled(t, `test@example.com`, ``, time.Second)
+
+	key := ll.limiter.loginAttemptsKey(strings.Repeat(`a`, 10000))
+
+	require.Len(key, len(ll.limiter.loginAttemptsKey(`test@example.com`)))
+}
+
+func TestLoginLimiterUnlock(t *testing.T) {
+	t.Parallel()
+	ll := newTestLoginLimiter(t)
+
+	for range 5 {
+		ll.fail(t, `test@example.com`, ``)
+		ll.advance(5 * time.Second)
+	}
+
+	ll.requireThrottled(t, `test@example.com`, ``, time.Hour-5*time.Second)
+
+	require.Nil(t, ll.limiter.Unlock(context.Background(), `test@example.com`))
+	ll.fail(t, `test@example.com`, ``)
+}
+
+func TestLoginLimiterForgetsFailures(t *testing.T) {
+	t.Parallel()
+	ll := newTestLoginLimiter(t)
+
+	ll.fail(t, `test@example.com`, `192.0.2.1`)
+	ll.fail(t, `test@example.com`, `192.0.2.1`)
+	ll.advance(LOGIN_FAILURE_WINDOW)
+
+	require.False(t, ll.server.Exists(ll.limiter.loginAttemptsKey(`test@example.com`)))
+	require.False(t, ll.server.Exists(ll.limiter.ipAttemptsKey(`192.0.2.1`)))
+}
@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"context"
+	"time"
+)
+
+type EmailVerificationRepository interface {
+	// Creates token unless the user has one created after
+	// dto.LastCreatedBefore, reports whether it was created
+	Create(ctx context.Context, dto createEmailVerificationRepositoryDto) (bool, error)
+	// Deletes token and returns it, so token can be used only once. Fails with
+	// ErrEmailVerificationTokenInvalid when token is unknown or expired
+	Use(ctx context.Context, tokenHash string, now time.Time) (*emailVerificationTokenEntity, error)
+	// Deletes every token of the user
+	DeleteByUser(ctx context.Context, userId uint32) error
+}
+
+type emailVerificationTokenEntity struct {
+	UserId uint32
+	// Login the token was emailed to
+	Login string
+}
+
+type createEmailVerificationRepositoryDto struct {
+	TokenHash string
+	UserId    uint32
+	Login     string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	// Throttles resending, see Create
+	LastCreatedBefore time.Time
+}
@@ -0,0 +1,234 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"finanstar/server/crypto"
+	"finanstar/server/mail"
+	"finanstar/server/session"
+	"finanstar/server/user"
+)
+
+// Confirms users own the email they sign in with. Verification token is
+// emailed on sign up, login change and on request, it is stored hashed,
+// expires and can be used once. Policy decides what unverified users may do
+
+const (
+	EMAIL_VERIFICATION_TOKEN_TTL = 24 * time.Hour
+	// Length of verification token in bytes, it is hex encoded
+	EMAIL_VERIFICATION_TOKEN_LENGTH = 32
+	// Verification isn't emailed to the same user more often than this
+	EMAIL_VERIFICATION_RESEND_INTERVAL = time.Minute
+	EMAIL_VERIFICATION_SUBJECT         = "Confirm your email"
+)
+
+// Restrictions of unverified users selectable with
+// EmailVerificationServiceOptions.Policy
+const (
+	// Unverified users aren't restricted
+	EMAIL_VERIFICATION_POLICY_NONE = "none"
+	// Unverified users can sign in but can only change their login, e.g. to
+	// fix a typo
+	EMAIL_VERIFICATION_POLICY_READ_ONLY = "read-only"
+	// Unverified users can't sign in. Sessions they already have, e.g. after
+	// changing login, are read-only
+	EMAIL_VERIFICATION_POLICY_NO_SIGN_IN = "no-sign-in"
+)
+
+const (
+	EMAIL_NOT_VERIFIED_ERROR               = "Email is not verified"
+	EMAIL_VERIFICATION_TOKEN_INVALID_ERROR = "Email verification token is invalid or expired"
+)
+
+var (
+	ErrEmailNotVerified              = errors.New(EMAIL_NOT_VERIFIED_ERROR)
+	ErrEmailVerificationTokenInvalid = errors.New(EMAIL_VERIFICATION_TOKEN_INVALID_ERROR)
+)
+
+type EmailVerificationServiceOptions struct {
+	// One of EMAIL_VERIFICATION_POLICY_* constants, defaults to
+	// EMAIL_VERIFICATION_POLICY_NONE
+	Policy string
+	// Defaults to EMAIL_VERIFICATION_TOKEN_TTL
+	TokenTtl time.Duration
+	// Defaults to EMAIL_VERIFICATION_RESEND_INTERVAL
+	ResendInterval time.Duration
+	// Link emailed to the user with token appended, e.g.
+	// "https://finanstar.example/verify-email?token=". Token alone is emailed
+	// when empty
+	VerifyUrl string
+	// Defaults to session.SystemClock
+	Clock session.Clock
+}
+
+type EmailVerificationService struct {
+	users      *user.UserService
+	repository EmailVerificationRepository
+	sender     mail.Sender
+	options    EmailVerificationServiceOptions
+}
+
+func NewEmailVerificationService(
+	users *user.UserService,
+	repository EmailVerificationRepository,
+	sender mail.Sender,
+	options *EmailVerificationServiceOptions,
+) EmailVerificationService {
+	service := EmailVerificationService{
+		users:      users,
+		repository: repository,
+		sender:     sender,
+	}
+
+	if options != nil {
+		service.options = *options
+	}
+
+	if len(service.options.Policy) == 0 {
+		service.options.Policy = EMAIL_VERIFICATION_POLICY_NONE
+	}
+
+	if service.options.TokenTtl <= 0 {
+		service.options.TokenTtl = EMAIL_VERIFICATION_TOKEN_TTL
+	}
+
+	if service.options.ResendInterval <= 0 {
+		service.options.ResendInterval = EMAIL_VERIFICATION_RESEND_INTERVAL
+	}
+
+	if service.options.Clock == nil {
+		service.options.Clock = session.SystemClock
+	}
+
+	return service
+}
+
+// Emails verification token to the user login. Nothing is sent when email is
+// already verified or the previous email was sent less than ResendInterval
+// ago
+func (self *EmailVerificationService) SendVerification(
+	ctx context.Context,
+	target *user.UserDto,
+) error {
+	if target.EmailVerifiedAt != nil {
+		return nil
+	}
+
+	token, err := crypto.GenerateSecureId(EMAIL_VERIFICATION_TOKEN_LENGTH)
+
+	if err != nil {
+		return err
+	}
+
+	now := self.options.Clock.Now()
+	created, err := self.repository.Create(ctx, createEmailVerificationRepositoryDto{
+		TokenHash:         crypto.HashToken(token),
+		UserId:            target.Id,
+		Login:             target.Login,
+		CreatedAt:         now,
+		ExpiresAt:         now.Add(self.options.TokenTtl),
+		LastCreatedBefore: now.Add(-self.options.ResendInterval),
+	})
+
+	if err != nil || !created {
+		return err
+	}
+
+	return self.sender.Send(ctx, mail.Message{
+		To:      target.Login,
+		Subject: EMAIL_VERIFICATION_SUBJECT,
+		Body: fmt.Sprintf(
+			"Follow the link to confirm your email: %s%s\n\n"+
+				"It expires in %s. Ignore this email if you didn't sign up.",
+			self.options.VerifyUrl,
+			token,
+			self.options.TokenTtl,
+		),
+	})
+}
+
+// Emails verification token again. Unknown login isn't reported, so the
+// caller can't find out whether the user exists
+func (self *EmailVerificationService) ResendVerification(
+	ctx context.Context,
+	login string,
+) error {
+	foundUser, err := self.users.GetByLogin(ctx, login)
+
+	if errors.Is(err, user.ErrUserNotFound) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return self.SendVerification(ctx, foundUser)
+}
+
+// Marks email of the token user verified and invalidates the rest of the
+// user tokens. Token emailed to the login user has changed since is rejected
+func (self *EmailVerificationService) ConfirmEmail(
+	ctx context.Context,
+	token string,
+) error {
+	now := self.options.Clock.Now()
+	verification, err := self.repository.Use(ctx, crypto.HashToken(token), now)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = self.users.VerifyEmail(
+		ctx,
+		verification.UserId,
+		verification.Login,
+		now,
+	)
+
+	if errors.Is(err, user.ErrUserNotFound) {
+		return ErrEmailVerificationTokenInvalid
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return self.repository.DeleteByUser(ctx, verification.UserId)
+}
+
+// Fails with ErrEmailNotVerified when policy doesn't let the user sign in
+func (self *EmailVerificationService) CheckSignIn(target *user.UserDto) error {
+	if target.EmailVerifiedAt == nil &&
+		self.options.Policy == EMAIL_VERIFICATION_POLICY_NO_SIGN_IN {
+		return ErrEmailNotVerified
+	}
+
+	return nil
+}
+
+// Fails with ErrEmailNotVerified when policy doesn't let the user change
+// anything
+func (self *EmailVerificationService) CheckWrite(
+	ctx context.Context,
+	userId uint32,
+) error {
+	if self.options.Policy == EMAIL_VERIFICATION_POLICY_NONE {
+		return nil
+	}
+
+	foundUser, err := self.users.GetById(ctx, userId)
+
+	if err != nil {
+		return err
+	}
+
+	if foundUser.EmailVerifiedAt == nil {
+		return ErrEmailNotVerified
+	}
+
+	return nil
+}
@@ -0,0 +1,341 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/require"
+
+	"finanstar/server/session"
+	"finanstar/server/user"
+)
+
+type memoryEmailVerificationToken struct {
+	userId    uint32
+	login     string
+	createdAt time.Time
+	expiresAt time.Time
+}
+
+type memoryEmailVerificationRepository struct {
+	mu     sync.Mutex
+	tokens map[string]memoryEmailVerificationToken
+}
+
+func (self *memoryEmailVerificationRepository) Create(
+	ctx context.Context,
+	dto createEmailVerificationRepositoryDto,
+) (bool, error) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	for _, token := range self.tokens {
+		if token.userId == dto.UserId && token.createdAt.After(dto.LastCreatedBefore) {
+			return false, nil
+		}
+	}
+
+	self.tokens[dto.TokenHash] = memoryEmailVerificationToken{
+		userId:    dto.UserId,
+		login:     dto.Login,
+		createdAt: dto.CreatedAt,
+		expiresAt: dto.ExpiresAt,
+	}
+
+	return true, nil
+}
+
+func (self *memoryEmailVerificationRepository) Use(
+	ctx context.Context,
+	tokenHash string,
+	now time.Time,
+) (*emailVerificationTokenEntity, error) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	token, exists := self.tokens[tokenHash]
+
+	if !exists || !token.expiresAt.After(now) {
+		return nil, ErrEmailVerificationTokenInvalid
+	}
+
+	delete(self.tokens, tokenHash)
+
+	return &emailVerificationTokenEntity{UserId: token.userId, Login: token.login}, nil
+}
+
+func (self *memoryEmailVerificationRepository) DeleteByUser(
+	ctx context.Context,
+	userId uint32,
+) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	for hash, token := range self.tokens {
+		if token.userId == userId {
+			delete(self.tokens, hash)
+		}
+	}
+
+	return nil
+}
+
+type testEmailVerification struct {
+	service *EmailVerificationService
+	sender  *recordingSender
+	clock   *session.ManualClock
+}
+
+func newTestEmailVerification(
+	users *user.UserService,
+	policy string,
+) testEmailVerification {
+	clock := session.NewManualClock(time.Now())
+	sender := &recordingSender{}
+	service := NewEmailVerificationService(
+		users,
+		&memoryEmailVerificationRepository{
+			tokens: map[string]memoryEmailVerificationToken{},
+		},
+		sender,
+		&EmailVerificationServiceOptions{
+			Policy:    policy,
+			VerifyUrl: `https://finanstar.example/verify-email?token=`,
+			Clock:     clock,
+		},
+	)
+
+	return testEmailVerification{&service, sender, clock}
+}
+
+func newTestUsers(t *testing.T) (*user.UserService, pgxmock.PgxPoolIface) {
+	db, err := pgxmock.NewPool()
+
+	require.Nil(t, err)
+
+	userRepository := user.NewPostgresqlUserRepository(db)
+	userService := user.NewUserService(&userRepository)
+
+	return &userService, db
+}
+
+// Sends verification to unverified user 1 and returns emailed token
+func (self *testEmailVerification) sendToken(t *testing.T) string {
+	require := require.New(t)
+	sent := len(self.sender.messages)
+
+	require.Nil(self.service.SendVerification(
+		context.Background(),
+		&user.UserDto{Id: 1, Login: `test@example.com`},
+	))
+	require.Len(self.sender.messages, sent+1)
+
+	message := self.sender.messages[sent]
+	_, link, found := strings.Cut(message.Body, `?token=`)
+
+	require.True(found)
+	require.Equal(`test@example.com`, message.To)
+	require.Equal(EMAIL_VERIFICATION_SUBJECT, message.Subject)
+
+	return link[:EMAIL_VERIFICATION_TOKEN_LENGTH*2]
+}
+
+func expectVerifyEmail(db pgxmock.PgxPoolIface, verifiedAt time.Time, verified bool) {
+	rows := db.NewRows([]string{`login`, `password`, `email_verified_at`})
+
+	if verified {
+		rows.AddRow(`test@example.com`, `hash`, &verifiedAt)
+	}
+
+	db.
+		ExpectQuery(`UPDATE users SET email_verified_at = COALESCE\(email_verified_at, \$3\)`).
+		WithArgs(uint32(1), `test@example.com`, verifiedAt).
+		WillReturnRows(rows)
+}
+
+func TestSendEmailVerification(t *testing.T) {
+	t.Parallel()
+
+	t.Run(`EmailsToken`, func(t *testing.T) {
+		users, _ := newTestUsers(t)
+		verification := newTestEmailVerification(users, ``)
+
+		require.Len(t, verification.sendToken(t), EMAIL_VERIFICATION_TOKEN_LENGTH*2)
+	})
+
+	t.Run(`SkipsVerifiedUser`, func(t *testing.T) {
+		require := require.New(t)
+		users, _ := newTestUsers(t)
+		verification := newTestEmailVerification(users, ``)
+		verifiedAt := time.Now()
+
+		require.Nil(verification.service.SendVerification(
+			context.Background(),
+			&user.UserDto{Id: 1, Login: `test@example.com`, EmailVerifiedAt: &verifiedAt},
+		))
+		require.Empty(verification.sender.messages)
+	})
+
+	t.Run(`ThrottlesResending`, func(t *testing.T) {
+		require := require.New(t)
+		ctx := context.Background()
+		users, db := newTestUsers(t)
+		verification := newTestEmailVerification(users, ``)
+
+		verification.sendToken(t)
+		verification.clock.Advance(EMAIL_VERIFICATION_RESEND_INTERVAL - time.Second)
+
+		db.
+			ExpectQuery(`SELECT id, login, password, email_verified_at FROM users`).
+			WithArgs(`test@example.com`).
+			WillReturnRows(
+				db.NewRows([]string{`id`, `login`, `password`, `email_verified_at`}).
+					AddRow(uint32(1), `test@example.com`, `hash`, nil),
+			)
+
+		require.Nil(verification.service.ResendVerification(ctx, `test@example.com`))
+		require.Len(verification.sender.messages, 1)
+
+		verification.clock.Advance(time.Second)
+		verification.sendToken(t)
+		require.Nil(db.ExpectationsWereMet())
+	})
+
+	t.Run(`IgnoresUnknownLogin`, func(t *testing.T) {
+		require := require.New(t)
+		users, db := newTestUsers(t)
+		verification := newTestEmailVerification(users, ``)
+
+		db.
+			ExpectQuery(`SELECT id, login, password, email_verified_at FROM users`).
+			WithArgs(`unknown@example.com`).
+			WillReturnRows(
+				db.NewRows([]string{`id`, `login`, `password`, `email_verified_at`}),
+			)
+
+		require.Nil(verification.service.ResendVerification(
+			context.Background(),
+			`unknown@example.com`,
+		))
+		require.Empty(verification.sender.messages)
+		require.Nil(db.ExpectationsWereMet())
+	})
+}
+
+func TestConfirmEmail(t *testing.T) {
+	t.Parallel()
+
+	t.Run(`VerifiesEmail`, func(t *testing.T) {
+		require := require.New(t)
+		ctx := context.Background()
+		users, db := newTestUsers(t)
+		verification := newTestEmailVerification(users, ``)
+		token := verification.sendToken(t)
+
+		verification.clock.Advance(EMAIL_VERIFICATION_RESEND_INTERVAL)
+
+		otherToken := verification.sendToken(t)
+
+		expectVerifyEmail(db, verification.clock.Now(), true)
+		require.Nil(verification.service.ConfirmEmail(ctx, token))
+
+		// Token is single use and the rest of user tokens are invalidated
+		require.ErrorIs(
+			verification.service.ConfirmEmail(ctx, token),
+			ErrEmailVerificationTokenInvalid,
+		)
+		require.ErrorIs(
+			verification.service.ConfirmEmail(ctx, otherToken),
+			ErrEmailVerificationTokenInvalid,
+		)
+		require.Nil(db.ExpectationsWereMet())
+	})
+
+	t.Run(`RejectsTokenOfChangedLogin`, func(t *testing.T) {
+		require := require.New(t)
+		users, db := newTestUsers(t)
+		verification := newTestEmailVerification(users, ``)
+		token := verification.sendToken(t)
+
+		expectVerifyEmail(db, verification.clock.Now(), false)
+		require.ErrorIs(
+			verification.service.ConfirmEmail(context.Background(), token),
+			ErrEmailVerificationTokenInvalid,
+		)
+		require.Nil(db.ExpectationsWereMet())
+	})
+
+	t.Run(`RejectsExpiredToken`, func(t *testing.T) {
+		require := require.New(t)
+		users, db := newTestUsers(t)
+		verification := newTestEmailVerification(users, ``)
+		token := verification.sendToken(t)
+
+		verification.clock.Advance(EMAIL_VERIFICATION_TOKEN_TTL)
+
+		require.ErrorIs(
+			verification.service.ConfirmEmail(context.Background(), token),
+			ErrEmailVerificationTokenInvalid,
+		)
+		require.Nil(db.ExpectationsWereMet())
+	})
+}
+
+func TestEmailVerificationPolicy(t *testing.T) {
+	t.Parallel()
+
+	subtests := []struct {
+		name        string
+		policy      string
+		signInError error
+		writeError  error
+	}{
+		{`NoneAllowsEverything`, EMAIL_VERIFICATION_POLICY_NONE, nil, nil},
+		{`ReadOnlyRejectsWrites`, EMAIL_VERIFICATION_POLICY_READ_ONLY, nil, ErrEmailNotVerified},
+		{
+			`NoSignInRejectsSignInAndWrites`,
+			EMAIL_VERIFICATION_POLICY_NO_SIGN_IN,
+			ErrEmailNotVerified,
+			ErrEmailNotVerified,
+		},
+	}
+
+	for _, test := range subtests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+			ctx := context.Background()
+			users, db := newTestUsers(t)
+			verification := newTestEmailVerification(users, test.policy)
+			verifiedAt := time.Now()
+			unverifiedUser := &user.UserDto{Id: 1, Login: `test@example.com`}
+			verifiedUser := &user.UserDto{
+				Id:              2,
+				Login:           `verified@example.com`,
+				EmailVerifiedAt: &verifiedAt,
+			}
+
+			require.Equal(test.signInError, verification.service.CheckSignIn(unverifiedUser))
+			require.Nil(verification.service.CheckSignIn(verifiedUser))
+
+			if test.policy != EMAIL_VERIFICATION_POLICY_NONE {
+				for _, target := range []*user.UserDto{unverifiedUser, verifiedUser} {
+					db.
+						ExpectQuery(`SELECT id, login, password, email_verified_at FROM users WHERE id = \$1;`).
+						WithArgs(target.Id).
+						WillReturnRows(
+							db.NewRows([]string{`id`, `login`, `password`, `email_verified_at`}).
+								AddRow(target.Id, target.Login, `hash`, target.EmailVerifiedAt),
+						)
+				}
+			}
+
+			require.Equal(test.writeError, verification.service.CheckWrite(ctx, 1))
+			require.Nil(verification.service.CheckWrite(ctx, 2))
+			require.Nil(db.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Slows down password guessing. Failed sign in attempts are counted per login
+// and per client IP, every failure past the free ones delays the next attempt
+// exponentially and reaching the lockout threshold blocks the login or IP for
+// the lockout duration
+
+const (
+	LOGIN_FREE_FAILURES     = 3
+	LOGIN_LOCKOUT_THRESHOLD = 10
+	// Single IP may serve many users, e.g. behind NAT
+	IP_FREE_FAILURES     = 20
+	IP_LOCKOUT_THRESHOLD = 100
+	LOGIN_BACKOFF_BASE   = time.Second
+	LOGIN_BACKOFF_MAX    = time.Minute
+	LOGIN_LOCKOUT_TIME   = 15 * time.Minute
+	LOGIN_FAILURE_WINDOW = time.Hour
+)
+
+const (
+	LOGIN_THROTTLED_ERROR = "Too many failed sign in attempts, try again later"
+)
+
+var (
+	ErrLoginThrottled = errors.New(LOGIN_THROTTLED_ERROR)
+)
+
+// Matches ErrLoginThrottled and tells when the next attempt is accepted
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (self *LoginThrottledError) Error() string {
+	return LOGIN_THROTTLED_ERROR
+}
+
+func (self *LoginThrottledError) Is(target error) bool {
+	return target == ErrLoginThrottled
+}
+
+type LoginLimiter interface {
+	// Counts sign in attempt or fails with *LoginThrottledError when login or
+	// IP is blocked. Attempt counts as failure until Succeeded is called, so
+	// concurrent guesses can't slip past the limits. Empty ip isn't limited
+	Attempt(ctx context.Context, login string, ip string) (*LoginAttempt, error)
+	// Reports lockouts caused by the failed attempt
+	Failed(ctx context.Context, attempt *LoginAttempt)
+	// Forgets failures of the login and uncounts the attempt of the IP
+	Succeeded(ctx context.Context, attempt *LoginAttempt) error
+	// Lifts lockout and backoff of the login
+	Unlock(ctx context.Context, login string) error
+	// Lifts lockout and backoff of the IP
+	UnlockIp(ctx context.Context, ip string) error
+}
+
+type LoginAttempt struct {
+	Login string
+	Ip    string
+	// Set when the attempt has locked login or IP out, unless it succeeds
+	LoginLockedUntil time.Time
+	IpLockedUntil    time.Time
+}
+
+type LoginLimits struct {
+	// Failures not delaying the next attempt
+	FreeFailures int
+	// Delay after the first failure past FreeFailures, every next failure
+	// doubles it up to BackoffMax
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+	// Failures causing lockout, counting starts over after it
+	LockoutThreshold int
+	LockoutTime      time.Duration
+	// Failures are forgotten when there are no new ones for this duration
+	FailureWindow time.Duration
+}
+
+func DefaultLoginLimits() LoginLimits {
+	return LoginLimits{
+		FreeFailures:     LOGIN_FREE_FAILURES,
+		BackoffBase:      LOGIN_BACKOFF_BASE,
+		BackoffMax:       LOGIN_BACKOFF_MAX,
+		LockoutThreshold: LOGIN_LOCKOUT_THRESHOLD,
+		LockoutTime:      LOGIN_LOCKOUT_TIME,
+		FailureWindow:    LOGIN_FAILURE_WINDOW,
+	}
+}
+
+func DefaultIpLimits() LoginLimits {
+	limits := DefaultLoginLimits()
+	limits.FreeFailures = IP_FREE_FAILURES
+	limits.LockoutThreshold = IP_LOCKOUT_THRESHOLD
+
+	return limits
+}
+
+// Fills unset limits from defaults
+func (self LoginLimits) withDefaults(defaults LoginLimits) LoginLimits {
+	if self.FreeFailures <= 0 {
+		self.FreeFailures = defaults.FreeFailures
+	}
+
+	if self.BackoffBase <= 0 {
+		self.BackoffBase = defaults.BackoffBase
+	}
+
+	if self.BackoffMax <= 0 {
+		self.BackoffMax = defaults.BackoffMax
+	}
+
+	if self.LockoutThreshold <= 0 {
+		self.LockoutThreshold = defaults.LockoutThreshold
+	}
+
+	if self.LockoutTime <= 0 {
+		self.LockoutTime = defaults.LockoutTime
+	}
+
+	if self.FailureWindow <= 0 {
+		self.FailureWindow = defaults.FailureWindow
+	}
+
+	return self
+}
+
+// Receives lockouts once they are stored. Methods are called synchronously
+// by sign in, so slow work must be offloaded
+type LoginLockoutObserver interface {
+	OnLoginLocked(ctx context.Context, login string, until time.Time)
+	OnIpLocked(ctx context.Context, ip string, until time.Time)
+}
+
+// Ignores every lockout, embed it to implement only some of the methods
+type NopLoginLockoutObserver struct{}
+
+func (NopLoginLockoutObserver) OnLoginLocked(
+	ctx context.Context,
+	login string,
+	until time.Time,
+) {
+}
+
+func (NopLoginLockoutObserver) OnIpLocked(ctx context.Context, ip string, until time.Time) {}
+
+// Reports lockouts of the attempt to observer
+func reportLockouts(
+	ctx context.Context,
+	observer LoginLockoutObserver,
+	attempt *LoginAttempt,
+) {
+	if !attempt.LoginLockedUntil.IsZero() {
+		observer.OnLoginLocked(ctx, attempt.Login, attempt.LoginLockedUntil)
+	}
+
+	if !attempt.IpLockedUntil.IsZero() {
+		observer.OnIpLocked(ctx, attempt.Ip, attempt.IpLockedUntil)
+	}
+}
+
+// Doesn't limit anything, for single-node development without Dragonfly
+type NopLoginLimiter struct{}
+
+func (NopLoginLimiter) Attempt(
+	ctx context.Context,
+	login string,
+	ip string,
+) (*LoginAttempt, error) {
+	return &LoginAttempt{Login: login, Ip: ip}, nil
+}
+
+func (NopLoginLimiter) Failed(ctx context.Context, attempt *LoginAttempt) {}
+
+func (NopLoginLimiter) Succeeded(ctx context.Context, attempt *LoginAttempt) error {
+	return nil
+}
+
+func (NopLoginLimiter) Unlock(ctx context.Context, login string) error {
+	return nil
+}
+
+func (NopLoginLimiter) UnlockIp(ctx context.Context, ip string) error {
+	return nil
+}
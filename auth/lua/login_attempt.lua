@@ -0,0 +1,65 @@
+-- Counts sign in attempt as failure against every counter unless one of them
+-- blocks it. Counter reaching lockout threshold blocks for lockout time and
+-- starts over, failures past free ones block for exponential backoff.
+--
+-- KEYS[1...] attempt counter hashes
+-- ARGV[1] current time, unix milliseconds
+-- ARGV[2...] limits of every counter, 6 values each: free failures, backoff
+-- base, maximum backoff, lockout threshold, lockout time and failure window,
+-- durations in milliseconds
+--
+-- Returns {0, blocked until of every counter...} if attempt is blocked,
+-- {1, locked until of every counter...} otherwise, where locked until is 0
+-- unless the attempt has locked the counter out
+
+local now = tonumber(ARGV[1])
+local result = { 0 }
+local blocked = false
+
+for _, key in ipairs(KEYS) do
+	local blockedUntil = tonumber(redis.call('HGET', key, 'blocked_until')) or 0
+
+	if blockedUntil > now then
+		blocked = true
+	end
+
+	table.insert(result, blockedUntil)
+end
+
+if blocked then
+	return result
+end
+
+result = { 1 }
+
+for index, key in ipairs(KEYS) do
+	local offset = 1 + (index - 1) * 6
+	local freeFailures = tonumber(ARGV[offset + 1])
+	local backoffBase = tonumber(ARGV[offset + 2])
+	local backoffMax = tonumber(ARGV[offset + 3])
+	local lockoutThreshold = tonumber(ARGV[offset + 4])
+	local lockoutTime = tonumber(ARGV[offset + 5])
+	local failureWindow = tonumber(ARGV[offset + 6])
+	local failures = redis.call('HINCRBY', key, 'failures', 1)
+	local blockedUntil = 0
+	local lockedUntil = 0
+
+	if failures >= lockoutThreshold then
+		lockedUntil = now + lockoutTime
+		blockedUntil = lockedUntil
+		redis.call('HSET', key, 'failures', 0)
+	elseif failures > freeFailures then
+		local backoff = backoffBase * 2 ^ (failures - freeFailures - 1)
+
+		blockedUntil = now + math.min(backoff, backoffMax)
+	end
+
+	if blockedUntil > 0 then
+		redis.call('HSET', key, 'blocked_until', blockedUntil)
+	end
+
+	redis.call('PEXPIRE', key, math.max(failureWindow, blockedUntil - now))
+	table.insert(result, lockedUntil)
+end
+
+return result
@@ -0,0 +1,25 @@
+-- Takes back failure counted by login_attempt.lua once the attempt has
+-- succeeded. Login counter is dropped, so its failures are forgotten, while
+-- IP counter only loses the attempt, as other users may share the IP. Block
+-- of the IP is lifted as well once its failures are back within free ones, so
+-- backoff or lockout caused by the successful attempt doesn't outlive it.
+--
+-- KEYS[1] login attempt counter hash
+-- KEYS[2] optional IP attempt counter hash
+-- ARGV[1] free failures of IP counter
+
+redis.call('DEL', KEYS[1])
+
+if KEYS[2] then
+	local failures = tonumber(redis.call('HGET', KEYS[2], 'failures')) or 0
+
+	if failures > 0 then
+		failures = redis.call('HINCRBY', KEYS[2], 'failures', -1)
+	end
+
+	if failures <= tonumber(ARGV[1]) then
+		redis.call('HDEL', KEYS[2], 'blocked_until')
+	end
+end
+
+return 1
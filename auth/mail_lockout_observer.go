@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"finanstar/server/mail"
+	"finanstar/server/user"
+)
+
+const (
+	LOGIN_LOCKED_SUBJECT = "Sign in is locked"
+	// Bounds looking the user up and sending, which outlive the sign in request
+	LOCKOUT_NOTIFICATION_TIMEOUT = 30 * time.Second
+)
+
+// Emails the user whose login gets locked out, so they learn somebody is
+// guessing their password. Logins of nonexistent users are skipped, so
+// lockouts can't be used to spam arbitrary addresses. Notifications are sent
+// in the background, so sign in neither waits for them nor reveals by its
+// timing whether the login exists
+type MailLockoutObserver struct {
+	NopLoginLockoutObserver
+	users    *user.UserService
+	sender   mail.Sender
+	inFlight sync.WaitGroup
+}
+
+func NewMailLockoutObserver(
+	users *user.UserService,
+	sender mail.Sender,
+) *MailLockoutObserver {
+	return &MailLockoutObserver{users: users, sender: sender}
+}
+
+func (self *MailLockoutObserver) OnLoginLocked(
+	ctx context.Context,
+	login string,
+	until time.Time,
+) {
+	ctx, cancel := context.WithTimeout(
+		context.WithoutCancel(ctx),
+		LOCKOUT_NOTIFICATION_TIMEOUT,
+	)
+
+	self.inFlight.Add(1)
+
+	go func() {
+		defer self.inFlight.Done()
+		defer cancel()
+
+		self.notify(ctx, login, until)
+	}()
+}
+
+// Blocks until notifications already dispatched are done
+func (self *MailLockoutObserver) Wait() {
+	self.inFlight.Wait()
+}
+
+func (self *MailLockoutObserver) notify(
+	ctx context.Context,
+	login string,
+	until time.Time,
+) {
+	foundUser, err := self.users.GetByLogin(ctx, login)
+
+	if err != nil {
+		return
+	}
+
+	err = self.sender.Send(ctx, mail.Message{
+		To:      foundUser.Login,
+		Subject: LOGIN_LOCKED_SUBJECT,
+		Body: fmt.Sprintf(
+			"There were too many failed attempts to sign in to your account, "+
+				"so signing in is locked until %s.\n\n"+
+				"If it wasn't you, consider resetting your password.",
+			until.UTC().Format(time.RFC1123),
+		),
+	})
+
+	if err != nil {
+		log.Printf("Failed to send lockout notification: %v", err)
+	}
+}
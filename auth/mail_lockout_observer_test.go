@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMailLockoutObserver(t *testing.T) {
+	t.Parallel()
+
+	t.Run(`EmailsUser`, func(t *testing.T) {
+		require := require.New(t)
+		users, db := newTestUsers(t)
+		sender := &recordingSender{}
+		observer := NewMailLockoutObserver(users, sender)
+		until := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+		db.
+			ExpectQuery(`SELECT id, login, password, email_verified_at FROM users`).
+			WithArgs(`test@example.com`).
+			WillReturnRows(
+				db.NewRows([]string{`id`, `login`, `password`, `email_verified_at`}).
+					AddRow(uint32(1), `test@example.com`, `hash`, nil),
+			)
+
+		observer.OnLoginLocked(context.Background(), `test@example.com`, until)
+		observer.Wait()
+
+		require.Len(sender.messages, 1)
+		require.Equal(`test@example.com`, sender.messages[0].To)
+		require.Equal(LOGIN_LOCKED_SUBJECT, sender.messages[0].Subject)
+		require.Contains(sender.messages[0].Body, `Mon, 01 Jan 2024 12:00:00 UTC`)
+		require.Nil(db.ExpectationsWereMet())
+	})
+
+	t.Run(`OutlivesRequest`, func(t *testing.T) {
+		require := require.New(t)
+		users, db := newTestUsers(t)
+		sender := &recordingSender{}
+		observer := NewMailLockoutObserver(users, sender)
+		ctx, cancel := context.WithCancel(context.Background())
+
+		db.
+			ExpectQuery(`SELECT id, login, password, email_verified_at FROM users`).
+			WithArgs(`test@example.com`).
+			WillReturnRows(
+				db.NewRows([]string{`id`, `login`, `password`, `email_verified_at`}).
+					AddRow(uint32(1), `test@example.com`, `hash`, nil),
+			)
+
+		observer.OnLoginLocked(ctx, `test@example.com`, time.Now())
+		cancel()
+		observer.Wait()
+
+		require.Len(sender.messages, 1)
+		require.Nil(db.ExpectationsWereMet())
+	})
+
+	t.Run(`SkipsUnknownLogin`, func(t *testing.T) {
+		require := require.New(t)
+		users, db := newTestUsers(t)
+		sender := &recordingSender{}
+		observer := NewMailLockoutObserver(users, sender)
+
+		db.
+			ExpectQuery(`SELECT id, login, password, email_verified_at FROM users`).
+			WithArgs(`unknown@example.com`).
+			WillReturnRows(
+				db.NewRows([]string{`id`, `login`, `password`, `email_verified_at`}),
+			)
+
+		observer.OnLoginLocked(context.Background(), `unknown@example.com`, time.Now())
+		observer.OnIpLocked(context.Background(), `192.0.2.1`, time.Now())
+		observer.Wait()
+
+		require.Empty(sender.messages)
+		require.Nil(db.ExpectationsWereMet())
+	})
+}
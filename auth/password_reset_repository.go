@@ -0,0 +1,27 @@
+package auth
+
+import (
+	"context"
+	"time"
+)
+
+type PasswordResetRepository interface {
+	// Creates token for the user with dto.Login unless the user is unknown or
+	// has one created after dto.LastCreatedBefore, reports whether it was
+	// created
+	Create(ctx context.Context, dto createPasswordResetRepositoryDto) (bool, error)
+	// Deletes token and returns its user, so token can be used only once.
+	// Fails with ErrPasswordResetTokenInvalid when token is unknown or expired
+	Use(ctx context.Context, tokenHash string, now time.Time) (uint32, error)
+	// Deletes every token of the user
+	DeleteByUser(ctx context.Context, userId uint32) error
+}
+
+type createPasswordResetRepositoryDto struct {
+	TokenHash string
+	Login     string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	// Throttles resending, see Create
+	LastCreatedBefore time.Time
+}
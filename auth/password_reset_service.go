@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"finanstar/server/crypto"
+	"finanstar/server/mail"
+	"finanstar/server/session"
+	"finanstar/server/user"
+	utils_pgx "finanstar/server/utils"
+)
+
+// Password recovery for users who can't sign in. Reset token is emailed to
+// the user, it is stored hashed, expires and can be used once. Successful
+// reset signs the user out everywhere
+
+const (
+	PASSWORD_RESET_TOKEN_TTL = time.Hour
+	// Length of reset token in bytes, it is hex encoded
+	PASSWORD_RESET_TOKEN_LENGTH = 32
+	// Reset isn't emailed to the same user more often than this
+	PASSWORD_RESET_RESEND_INTERVAL = time.Minute
+	PASSWORD_RESET_SUBJECT         = "Password reset"
+)
+
+const (
+	PASSWORD_RESET_TOKEN_INVALID_ERROR = "Password reset token is invalid or expired"
+)
+
+var (
+	ErrPasswordResetTokenInvalid = errors.New(PASSWORD_RESET_TOKEN_INVALID_ERROR)
+)
+
+type PasswordResetServiceOptions struct {
+	// Defaults to PASSWORD_RESET_TOKEN_TTL
+	TokenTtl time.Duration
+	// Defaults to PASSWORD_RESET_RESEND_INTERVAL
+	ResendInterval time.Duration
+	// Link emailed to the user with token appended, e.g.
+	// "https://finanstar.example/password-reset?token=". Token alone is
+	// emailed when empty
+	ResetUrl string
+	// Defaults to session.SystemClock
+	Clock session.Clock
+}
+
+type PasswordResetService struct {
+	users      *user.UserService
+	sessions   session.SessionManager
+	repository PasswordResetRepository
+	txManager  *utils_pgx.TxManager
+	sender     mail.Sender
+	options    PasswordResetServiceOptions
+}
+
+func NewPasswordResetService(
+	users *user.UserService,
+	sessions session.SessionManager,
+	repository PasswordResetRepository,
+	txManager *utils_pgx.TxManager,
+	sender mail.Sender,
+	options *PasswordResetServiceOptions,
+) PasswordResetService {
+	service := PasswordResetService{
+		users:      users,
+		sessions:   sessions,
+		repository: repository,
+		txManager:  txManager,
+		sender:     sender,
+	}
+
+	if options != nil {
+		service.options = *options
+	}
+
+	if service.options.TokenTtl <= 0 {
+		service.options.TokenTtl = PASSWORD_RESET_TOKEN_TTL
+	}
+
+	if service.options.ResendInterval <= 0 {
+		service.options.ResendInterval = PASSWORD_RESET_RESEND_INTERVAL
+	}
+
+	if service.options.Clock == nil {
+		service.options.Clock = session.SystemClock
+	}
+
+	return service
+}
+
+// Emails reset token to the user. Nothing is sent when the previous token was
+// created less than ResendInterval ago. Unknown login isn't reported and goes
+// through the same token generation and query, so neither the result nor its
+// timing tells the caller whether the user exists. Only sending differs,
+// Sender should hand the message off rather than deliver it in place
+func (self *PasswordResetService) RequestReset(
+	ctx context.Context,
+	login string,
+) error {
+	token, err := crypto.GenerateSecureId(PASSWORD_RESET_TOKEN_LENGTH)
+
+	if err != nil {
+		return err
+	}
+
+	now := self.options.Clock.Now()
+	created, err := self.repository.Create(ctx, createPasswordResetRepositoryDto{
+		TokenHash:         crypto.HashToken(token),
+		Login:             login,
+		CreatedAt:         now,
+		ExpiresAt:         now.Add(self.options.TokenTtl),
+		LastCreatedBefore: now.Add(-self.options.ResendInterval),
+	})
+
+	if err != nil || !created {
+		return err
+	}
+
+	return self.sender.Send(ctx, mail.Message{
+		To:      login,
+		Subject: PASSWORD_RESET_SUBJECT,
+		Body: fmt.Sprintf(
+			"Follow the link to set a new password: %s%s\n\n"+
+				"It expires in %s. Ignore this email if you didn't request it.",
+			self.options.ResetUrl,
+			token,
+			self.options.TokenTtl,
+		),
+	})
+}
+
+// Sets new password of the token user, invalidates the rest of the user
+// tokens and deletes every session of the user. Either all of it is done or
+// the token stays usable. Sessions are reset last inside the transaction, so
+// when it fails the password isn't changed and old sessions don't outlive a
+// reset reported as successful
+func (self *PasswordResetService) ConfirmReset(
+	ctx context.Context,
+	token string,
+	password string,
+) error {
+	now := self.options.Clock.Now()
+
+	return self.txManager.RunInTx(ctx, func(ctx context.Context) error {
+		userId, err := self.repository.Use(ctx, crypto.HashToken(token), now)
+
+		if err != nil {
+			return err
+		}
+
+		_, err = self.users.Update(
+			ctx,
+			userId,
+			user.UpdateUserDto{Password: &password},
+		)
+
+		if err != nil {
+			return err
+		}
+
+		if err = self.repository.DeleteByUser(ctx, userId); err != nil {
+			return err
+		}
+
+		return self.sessions.ResetSessions(ctx, userId)
+	})
+}
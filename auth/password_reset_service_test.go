@@ -0,0 +1,329 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/require"
+
+	"finanstar/server/crypto"
+	"finanstar/server/mail"
+	"finanstar/server/session"
+	"finanstar/server/user"
+	utils_pgx "finanstar/server/utils"
+)
+
+type memoryPasswordResetToken struct {
+	userId    uint32
+	createdAt time.Time
+	expiresAt time.Time
+}
+
+type memoryPasswordResetRepository struct {
+	mu sync.Mutex
+	// User ids by login
+	users  map[string]uint32
+	tokens map[string]memoryPasswordResetToken
+}
+
+func (self *memoryPasswordResetRepository) Create(
+	ctx context.Context,
+	dto createPasswordResetRepositoryDto,
+) (bool, error) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	userId, exists := self.users[dto.Login]
+
+	if !exists {
+		return false, nil
+	}
+
+	for _, token := range self.tokens {
+		if token.userId == userId && token.createdAt.After(dto.LastCreatedBefore) {
+			return false, nil
+		}
+	}
+
+	self.tokens[dto.TokenHash] = memoryPasswordResetToken{
+		userId,
+		dto.CreatedAt,
+		dto.ExpiresAt,
+	}
+
+	return true, nil
+}
+
+func (self *memoryPasswordResetRepository) Use(
+	ctx context.Context,
+	tokenHash string,
+	now time.Time,
+) (uint32, error) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	token, exists := self.tokens[tokenHash]
+
+	if !exists || !token.expiresAt.After(now) {
+		return 0, ErrPasswordResetTokenInvalid
+	}
+
+	delete(self.tokens, tokenHash)
+
+	return token.userId, nil
+}
+
+func (self *memoryPasswordResetRepository) DeleteByUser(
+	ctx context.Context,
+	userId uint32,
+) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	for hash, token := range self.tokens {
+		if token.userId == userId {
+			delete(self.tokens, hash)
+		}
+	}
+
+	return nil
+}
+
+// Fails to reset sessions, the rest is delegated to embedded manager
+type failingResetSessionManager struct {
+	session.SessionManager
+}
+
+var errResetSessions = errors.New(`reset sessions failed`)
+
+func (self failingResetSessionManager) ResetSessions(
+	ctx context.Context,
+	userId uint32,
+) error {
+	return errResetSessions
+}
+
+// Keeps sent messages instead of sending them
+type recordingSender struct {
+	mu       sync.Mutex
+	messages []mail.Message
+}
+
+func (self *recordingSender) Send(ctx context.Context, message mail.Message) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	self.messages = append(self.messages, message)
+
+	return nil
+}
+
+type testPasswordReset struct {
+	service  PasswordResetService
+	db       pgxmock.PgxPoolIface
+	sessions *session.MemorySessionManager
+	sender   *recordingSender
+	clock    *session.ManualClock
+}
+
+func newTestPasswordReset(t *testing.T) testPasswordReset {
+	db, err := pgxmock.NewPool()
+
+	require.Nil(t, err)
+
+	clock := session.NewManualClock(time.Now())
+	sessions := session.NewMemorySessionManager(
+		&session.MemorySessionManagerOptions{Clock: clock},
+	)
+	userRepository := user.NewPostgresqlUserRepository(db)
+	userService := user.NewUserService(&userRepository)
+	sender := &recordingSender{}
+	service := NewPasswordResetService(
+		&userService,
+		sessions,
+		&memoryPasswordResetRepository{
+			users:  map[string]uint32{`test@example.com`: 1},
+			tokens: map[string]memoryPasswordResetToken{},
+		},
+		utils_pgx.NewTxManager(db, nil),
+		sender,
+		&PasswordResetServiceOptions{
+			ResetUrl: `https://finanstar.example/password-reset?token=`,
+			Clock:    clock,
+		},
+	)
+
+	return testPasswordReset{service, db, sessions, sender, clock}
+}
+
+// Requests reset for user 1 and returns emailed token. Clock is advanced
+// past resend interval, so the next request isn't throttled
+func (self *testPasswordReset) requestToken(t *testing.T) string {
+	require := require.New(t)
+	sent := len(self.sender.messages)
+
+	require.Nil(self.service.RequestReset(context.Background(), `test@example.com`))
+	require.Len(self.sender.messages, sent+1)
+	self.clock.Advance(PASSWORD_RESET_RESEND_INTERVAL)
+
+	message := self.sender.messages[len(self.sender.messages)-1]
+	_, link, found := strings.Cut(message.Body, `?token=`)
+
+	require.True(found)
+	require.Equal(`test@example.com`, message.To)
+
+	return link[:PASSWORD_RESET_TOKEN_LENGTH*2]
+}
+
+// Expects reset transaction that fails before the password is updated
+func (self *testPasswordReset) expectRejectedConfirm() {
+	self.db.ExpectBegin()
+	self.db.ExpectRollback()
+}
+
+func (self *testPasswordReset) expectPasswordUpdate() {
+	self.db.ExpectBegin()
+	self.db.
+		ExpectQuery(`UPDATE users SET password = \$2 WHERE id = \$1`).
+		WithArgs(uint32(1), pgxmock.AnyArg()).
+		WillReturnRows(
+			self.db.NewRows([]string{`login`, `password`, `email_verified_at`}).
+				AddRow(`test@example.com`, `new-hash`, nil),
+		)
+	self.db.ExpectCommit()
+}
+
+func TestRequestPasswordReset(t *testing.T) {
+	t.Parallel()
+
+	t.Run(`EmailsToken`, func(t *testing.T) {
+		require := require.New(t)
+		reset := newTestPasswordReset(t)
+		token := reset.requestToken(t)
+
+		require.Len(token, PASSWORD_RESET_TOKEN_LENGTH*2)
+		require.Equal(PASSWORD_RESET_SUBJECT, reset.sender.messages[0].Subject)
+		require.Nil(reset.db.ExpectationsWereMet())
+	})
+
+	t.Run(`IgnoresUnknownLogin`, func(t *testing.T) {
+		require := require.New(t)
+		reset := newTestPasswordReset(t)
+
+		require.Nil(reset.service.RequestReset(context.Background(), `unknown@example.com`))
+		require.Empty(reset.sender.messages)
+	})
+
+	t.Run(`ThrottlesResending`, func(t *testing.T) {
+		require := require.New(t)
+		ctx := context.Background()
+		reset := newTestPasswordReset(t)
+
+		require.Nil(reset.service.RequestReset(ctx, `test@example.com`))
+
+		reset.clock.Advance(PASSWORD_RESET_RESEND_INTERVAL - time.Second)
+
+		require.Nil(reset.service.RequestReset(ctx, `test@example.com`))
+		require.Len(reset.sender.messages, 1)
+
+		reset.clock.Advance(time.Second)
+
+		require.Nil(reset.service.RequestReset(ctx, `test@example.com`))
+		require.Len(reset.sender.messages, 2)
+	})
+}
+
+func TestConfirmPasswordReset(t *testing.T) {
+	t.Parallel()
+
+	t.Run(`SetsPasswordAndResetsSessions`, func(t *testing.T) {
+		require := require.New(t)
+		ctx := context.Background()
+		reset := newTestPasswordReset(t)
+		token := reset.requestToken(t)
+		otherToken := reset.requestToken(t)
+		sId, err := reset.sessions.CreateSession(ctx, &session.SessionData{UserId: 1})
+
+		require.Nil(err)
+
+		reset.expectPasswordUpdate()
+		require.Nil(reset.service.ConfirmReset(ctx, token, `new-password`))
+
+		_, err = reset.sessions.GetSessionData(ctx, sId)
+
+		require.ErrorIs(err, session.ErrSessionNotFound)
+
+		// Token is single use and the rest of user tokens are invalidated
+		reset.expectRejectedConfirm()
+		require.ErrorIs(
+			reset.service.ConfirmReset(ctx, token, `new-password`),
+			ErrPasswordResetTokenInvalid,
+		)
+		reset.expectRejectedConfirm()
+		require.ErrorIs(
+			reset.service.ConfirmReset(ctx, otherToken, `new-password`),
+			ErrPasswordResetTokenInvalid,
+		)
+		require.Nil(reset.db.ExpectationsWereMet())
+	})
+
+	t.Run(`ReportsSessionResetFailure`, func(t *testing.T) {
+		require := require.New(t)
+		ctx := context.Background()
+		reset := newTestPasswordReset(t)
+		token := reset.requestToken(t)
+
+		reset.service.sessions = failingResetSessionManager{reset.sessions}
+
+		reset.db.ExpectBegin()
+		reset.db.
+			ExpectQuery(`UPDATE users SET password = \$2 WHERE id = \$1`).
+			WithArgs(uint32(1), pgxmock.AnyArg()).
+			WillReturnRows(
+				reset.db.NewRows([]string{`login`, `password`, `email_verified_at`}).
+					AddRow(`test@example.com`, `new-hash`, nil),
+			)
+		reset.db.ExpectRollback()
+
+		require.ErrorIs(
+			reset.service.ConfirmReset(ctx, token, `new-password`),
+			errResetSessions,
+		)
+		require.Nil(reset.db.ExpectationsWereMet())
+	})
+
+	t.Run(`RejectsExpiredToken`, func(t *testing.T) {
+		require := require.New(t)
+		reset := newTestPasswordReset(t)
+		token := reset.requestToken(t)
+
+		reset.clock.Advance(PASSWORD_RESET_TOKEN_TTL)
+		reset.expectRejectedConfirm()
+
+		require.ErrorIs(
+			reset.service.ConfirmReset(context.Background(), token, `new-password`),
+			ErrPasswordResetTokenInvalid,
+		)
+		require.Nil(reset.db.ExpectationsWereMet())
+	})
+
+	t.Run(`RejectsUnknownToken`, func(t *testing.T) {
+		reset := newTestPasswordReset(t)
+		token, err := crypto.GenerateSecureId(PASSWORD_RESET_TOKEN_LENGTH)
+
+		require.Nil(t, err)
+
+		reset.expectRejectedConfirm()
+		require.ErrorIs(
+			t,
+			reset.service.ConfirmReset(context.Background(), token, `new-password`),
+			ErrPasswordResetTokenInvalid,
+		)
+		require.Nil(t, reset.db.ExpectationsWereMet())
+	})
+}
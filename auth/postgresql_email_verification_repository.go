@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"finanstar/server/apperror"
+	utils_pgx "finanstar/server/utils"
+)
+
+func NewPostgresqlEmailVerificationRepository(
+	db utils_pgx.PgxPoolIface,
+) postgresqlEmailVerificationRepository {
+	return postgresqlEmailVerificationRepository{db}
+}
+
+type postgresqlEmailVerificationRepository struct {
+	db utils_pgx.PgxPoolIface
+}
+
+// Drops expired tokens of the user as well. Concurrent requests may both
+// pass the throttling check, which only costs an extra email
+func (self *postgresqlEmailVerificationRepository) Create(
+	ctx context.Context,
+	dto createEmailVerificationRepositoryDto,
+) (bool, error) {
+	tag, err := utils_pgx.QuerierFromContext(ctx, self.db).Exec(
+		ctx,
+		`WITH expired AS (
+			DELETE FROM email_verification_tokens WHERE user_id = $2 AND expires_at <= $4
+		)
+		INSERT INTO email_verification_tokens
+			(token_hash, user_id, login, created_at, expires_at)
+		SELECT $1, $2, $3, $4, $5
+		WHERE NOT EXISTS (
+			SELECT 1 FROM email_verification_tokens WHERE user_id = $2 AND created_at > $6
+		);`,
+		dto.TokenHash,
+		dto.UserId,
+		dto.Login,
+		dto.CreatedAt,
+		dto.ExpiresAt,
+		dto.LastCreatedBefore,
+	)
+
+	if err != nil {
+		return false, err
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+func (self *postgresqlEmailVerificationRepository) Use(
+	ctx context.Context,
+	tokenHash string,
+	now time.Time,
+) (*emailVerificationTokenEntity, error) {
+	token := emailVerificationTokenEntity{}
+
+	err := utils_pgx.QuerierFromContext(ctx, self.db).
+		QueryRow(
+			ctx,
+			`DELETE FROM email_verification_tokens
+			WHERE token_hash = $1 AND expires_at > $2
+			RETURNING user_id, login;`,
+			tokenHash,
+			now,
+		).
+		Scan(&token.UserId, &token.Login)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, apperror.Wrap(ErrEmailVerificationTokenInvalid, err)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func (self *postgresqlEmailVerificationRepository) DeleteByUser(
+	ctx context.Context,
+	userId uint32,
+) error {
+	_, err := utils_pgx.QuerierFromContext(ctx, self.db).Exec(
+		ctx,
+		`DELETE FROM email_verification_tokens WHERE user_id = $1;`,
+		userId,
+	)
+
+	return err
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmailVerificationRepositoryCreate(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	subtests := []struct {
+		name         string
+		rowsAffected int64
+		created      bool
+	}{
+		{`CreatesToken`, 1, true},
+		{`ThrottlesRecentlyCreated`, 0, false},
+	}
+
+	for _, test := range subtests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+			db, err := pgxmock.NewPool()
+
+			require.Nil(err)
+
+			repository := NewPostgresqlEmailVerificationRepository(db)
+
+			db.
+				ExpectExec(`WITH expired AS .* INSERT INTO email_verification_tokens .* WHERE NOT EXISTS`).
+				WithArgs(
+					`hash`,
+					uint32(1337),
+					`test@example.com`,
+					now,
+					now.Add(time.Hour),
+					now.Add(-time.Minute),
+				).
+				WillReturnResult(pgxmock.NewResult(`INSERT`, test.rowsAffected))
+
+			created, err := repository.Create(
+				context.Background(),
+				createEmailVerificationRepositoryDto{
+					TokenHash:         `hash`,
+					UserId:            1337,
+					Login:             `test@example.com`,
+					CreatedAt:         now,
+					ExpiresAt:         now.Add(time.Hour),
+					LastCreatedBefore: now.Add(-time.Minute),
+				},
+			)
+
+			require.Nil(err)
+			require.Equal(test.created, created)
+			require.Nil(db.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestEmailVerificationRepositoryUse(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	subtests := []struct {
+		name  string
+		token *emailVerificationTokenEntity
+		error error
+	}{
+		{`ReturnsToken`, &emailVerificationTokenEntity{1337, `test@example.com`}, nil},
+		{`ReturnsTokenInvalid`, nil, ErrEmailVerificationTokenInvalid},
+	}
+
+	for _, test := range subtests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+			db, err := pgxmock.NewPool()
+
+			require.Nil(err)
+
+			repository := NewPostgresqlEmailVerificationRepository(db)
+			rows := db.NewRows([]string{`user_id`, `login`})
+
+			if test.token != nil {
+				rows.AddRow(test.token.UserId, test.token.Login)
+			}
+
+			db.
+				ExpectQuery(`DELETE FROM email_verification_tokens WHERE token_hash = \$1 AND expires_at > \$2`).
+				WithArgs(`hash`, now).
+				WillReturnRows(rows)
+
+			token, err := repository.Use(context.Background(), `hash`, now)
+
+			if test.error != nil {
+				require.ErrorIs(err, test.error)
+			} else {
+				require.Nil(err)
+				require.Equal(test.token, token)
+			}
+
+			require.Nil(db.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"finanstar/server/apperror"
+	utils_pgx "finanstar/server/utils"
+)
+
+func NewPostgresqlPasswordResetRepository(
+	db utils_pgx.PgxPoolIface,
+) postgresqlPasswordResetRepository {
+	return postgresqlPasswordResetRepository{db}
+}
+
+type postgresqlPasswordResetRepository struct {
+	db utils_pgx.PgxPoolIface
+}
+
+// Looks the user up in the same statement, so unknown login costs the same
+// round trip. Drops expired tokens of the user as well. Concurrent requests
+// may both pass the throttling check, which only costs an extra email
+func (self *postgresqlPasswordResetRepository) Create(
+	ctx context.Context,
+	dto createPasswordResetRepositoryDto,
+) (bool, error) {
+	tag, err := utils_pgx.QuerierFromContext(ctx, self.db).Exec(
+		ctx,
+		`WITH target AS (
+			SELECT id FROM users WHERE login = $2
+		), expired AS (
+			DELETE FROM password_reset_tokens
+			WHERE user_id IN (SELECT id FROM target) AND expires_at <= $3
+		)
+		INSERT INTO password_reset_tokens (token_hash, user_id, created_at, expires_at)
+		SELECT $1, target.id, $3, $4 FROM target
+		WHERE NOT EXISTS (
+			SELECT 1 FROM password_reset_tokens
+			WHERE user_id = target.id AND created_at > $5
+		);`,
+		dto.TokenHash,
+		dto.Login,
+		dto.CreatedAt,
+		dto.ExpiresAt,
+		dto.LastCreatedBefore,
+	)
+
+	if err != nil {
+		return false, err
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+func (self *postgresqlPasswordResetRepository) Use(
+	ctx context.Context,
+	tokenHash string,
+	now time.Time,
+) (uint32, error) {
+	var userId uint32
+
+	err := utils_pgx.QuerierFromContext(ctx, self.db).
+		QueryRow(
+			ctx,
+			`DELETE FROM password_reset_tokens
+			WHERE token_hash = $1 AND expires_at > $2
+			RETURNING user_id;`,
+			tokenHash,
+			now,
+		).
+		Scan(&userId)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, apperror.Wrap(ErrPasswordResetTokenInvalid, err)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return userId, nil
+}
+
+func (self *postgresqlPasswordResetRepository) DeleteByUser(
+	ctx context.Context,
+	userId uint32,
+) error {
+	_, err := utils_pgx.QuerierFromContext(ctx, self.db).Exec(
+		ctx,
+		`DELETE FROM password_reset_tokens WHERE user_id = $1;`,
+		userId,
+	)
+
+	return err
+}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordResetRepositoryCreate(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	subtests := []struct {
+		name         string
+		rowsAffected int64
+		created      bool
+	}{
+		{`CreatesToken`, 1, true},
+		{`SkipsUnknownOrThrottled`, 0, false},
+	}
+
+	for _, test := range subtests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+			db, err := pgxmock.NewPool()
+
+			require.Nil(err)
+
+			repository := NewPostgresqlPasswordResetRepository(db)
+
+			db.
+				ExpectExec(`WITH target AS .* INSERT INTO password_reset_tokens .* WHERE NOT EXISTS`).
+				WithArgs(
+					`hash`,
+					`test@example.com`,
+					now,
+					now.Add(time.Hour),
+					now.Add(-time.Minute),
+				).
+				WillReturnResult(pgxmock.NewResult(`INSERT`, test.rowsAffected))
+
+			created, err := repository.Create(
+				context.Background(),
+				createPasswordResetRepositoryDto{
+					TokenHash:         `hash`,
+					Login:             `test@example.com`,
+					CreatedAt:         now,
+					ExpiresAt:         now.Add(time.Hour),
+					LastCreatedBefore: now.Add(-time.Minute),
+				},
+			)
+
+			require.Nil(err)
+			require.Equal(test.created, created)
+			require.Nil(db.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestPasswordResetRepositoryUse(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	subtests := []struct {
+		name   string
+		exists bool
+		error  error
+	}{
+		{`ReturnsTokenUser`, true, nil},
+		{`ReturnsTokenInvalid`, false, ErrPasswordResetTokenInvalid},
+	}
+
+	for _, test := range subtests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+			db, err := pgxmock.NewPool()
+
+			require.Nil(err)
+
+			repository := NewPostgresqlPasswordResetRepository(db)
+			rows := db.NewRows([]string{`user_id`})
+
+			if test.exists {
+				rows.AddRow(uint32(1337))
+			}
+
+			db.
+				ExpectQuery(`DELETE FROM password_reset_tokens WHERE token_hash = \$1 AND expires_at > \$2`).
+				WithArgs(`hash`, now).
+				WillReturnRows(rows)
+
+			userId, err := repository.Use(context.Background(), `hash`, now)
+
+			if test.error != nil {
+				require.ErrorIs(err, test.error)
+			} else {
+				require.Nil(err)
+				require.Equal(uint32(1337), userId)
+			}
+
+			require.Nil(db.ExpectationsWereMet())
+		})
+	}
+}
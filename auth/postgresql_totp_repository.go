@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"finanstar/server/apperror"
+	utils_pgx "finanstar/server/utils"
+)
+
+func NewPostgresqlTotpRepository(
+	db utils_pgx.PgxPoolIface,
+) postgresqlTotpRepository {
+	return postgresqlTotpRepository{db, utils_pgx.NewTxManager(db, nil)}
+}
+
+type postgresqlTotpRepository struct {
+	db        utils_pgx.PgxPoolIface
+	txManager *utils_pgx.TxManager
+}
+
+func (self *postgresqlTotpRepository) Get(
+	ctx context.Context,
+	userId uint32,
+) (*totpEntity, error) {
+	totp := totpEntity{UserId: userId}
+
+	err := utils_pgx.QuerierFromContext(ctx, self.db).
+		QueryRow(
+			ctx,
+			`SELECT secret, confirmed_at, last_used_step FROM user_totp
+			WHERE user_id = $1;`,
+			userId,
+		).
+		Scan(&totp.Secret, &totp.ConfirmedAt, &totp.LastUsedStep)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, apperror.Wrap(ErrTotpNotEnrolled, err)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &totp, nil
+}
+
+func (self *postgresqlTotpRepository) SaveSecret(
+	ctx context.Context,
+	userId uint32,
+	secret string,
+) error {
+	tag, err := utils_pgx.QuerierFromContext(ctx, self.db).Exec(
+		ctx,
+		`INSERT INTO user_totp (user_id, secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_used_step = 0
+		WHERE user_totp.confirmed_at IS NULL;`,
+		userId,
+		secret,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrTotpAlreadyEnabled
+	}
+
+	return nil
+}
+
+func (self *postgresqlTotpRepository) Confirm(
+	ctx context.Context,
+	dto confirmTotpRepositoryDto,
+) error {
+	return self.txManager.RunInTx(ctx, func(ctx context.Context) error {
+		querier := utils_pgx.QuerierFromContext(ctx, self.db)
+		tag, err := querier.Exec(
+			ctx,
+			`UPDATE user_totp SET confirmed_at = $2, last_used_step = $3
+			WHERE user_id = $1 AND confirmed_at IS NULL;`,
+			dto.UserId,
+			dto.ConfirmedAt,
+			int64(dto.Step),
+		)
+
+		if err != nil {
+			return err
+		}
+
+		if tag.RowsAffected() == 0 {
+			return ErrTotpAlreadyEnabled
+		}
+
+		_, err = querier.Exec(
+			ctx,
+			`DELETE FROM user_recovery_codes WHERE user_id = $1;`,
+			dto.UserId,
+		)
+
+		if err != nil {
+			return err
+		}
+
+		_, err = querier.Exec(
+			ctx,
+			`INSERT INTO user_recovery_codes (user_id, code_hash)
+			SELECT $1, unnest($2::text[]);`,
+			dto.UserId,
+			dto.RecoveryCodeHashes,
+		)
+
+		return err
+	})
+}
+
+func (self *postgresqlTotpRepository) UseStep(
+	ctx context.Context,
+	userId uint32,
+	step uint64,
+) (bool, error) {
+	tag, err := utils_pgx.QuerierFromContext(ctx, self.db).Exec(
+		ctx,
+		`UPDATE user_totp SET last_used_step = $2
+		WHERE user_id = $1 AND confirmed_at IS NOT NULL AND last_used_step < $2;`,
+		userId,
+		int64(step),
+	)
+
+	if err != nil {
+		return false, err
+	}
+
+	return tag.RowsAffected() != 0, nil
+}
+
+func (self *postgresqlTotpRepository) UseRecoveryCode(
+	ctx context.Context,
+	userId uint32,
+	codeHash string,
+	now time.Time,
+) (bool, error) {
+	tag, err := utils_pgx.QuerierFromContext(ctx, self.db).Exec(
+		ctx,
+		`UPDATE user_recovery_codes SET used_at = $3
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL;`,
+		userId,
+		codeHash,
+		now,
+	)
+
+	if err != nil {
+		return false, err
+	}
+
+	return tag.RowsAffected() != 0, nil
+}
+
+func (self *postgresqlTotpRepository) Delete(
+	ctx context.Context,
+	userId uint32,
+) error {
+	return self.txManager.RunInTx(ctx, func(ctx context.Context) error {
+		querier := utils_pgx.QuerierFromContext(ctx, self.db)
+		_, err := querier.Exec(
+			ctx,
+			`DELETE FROM user_recovery_codes WHERE user_id = $1;`,
+			userId,
+		)
+
+		if err != nil {
+			return err
+		}
+
+		_, err = querier.Exec(
+			ctx,
+			`DELETE FROM user_totp WHERE user_id = $1;`,
+			userId,
+		)
+
+		return err
+	})
+}
@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestTotpRepository(
+	t *testing.T,
+) (*postgresqlTotpRepository, pgxmock.PgxPoolIface) {
+	db, err := pgxmock.NewPool()
+
+	require.Nil(t, err)
+
+	repository := NewPostgresqlTotpRepository(db)
+
+	return &repository, db
+}
+
+func TestTotpRepositoryGet(t *testing.T) {
+	t.Parallel()
+
+	confirmedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	subtests := []struct {
+		name  string
+		totp  *totpEntity
+		error error
+	}{
+		{`ReturnsTotp`, &totpEntity{1337, `SECRET`, &confirmedAt, 42}, nil},
+		{`ReturnsTotpNotEnrolled`, nil, ErrTotpNotEnrolled},
+	}
+
+	for _, test := range subtests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+			repository, db := newTestTotpRepository(t)
+			rows := db.NewRows([]string{`secret`, `confirmed_at`, `last_used_step`})
+
+			if test.totp != nil {
+				rows.AddRow(test.totp.Secret, test.totp.ConfirmedAt, test.totp.LastUsedStep)
+			}
+
+			db.
+				ExpectQuery(`SELECT secret, confirmed_at, last_used_step FROM user_totp`).
+				WithArgs(uint32(1337)).
+				WillReturnRows(rows)
+
+			totp, err := repository.Get(context.Background(), 1337)
+
+			if test.error != nil {
+				require.ErrorIs(err, test.error)
+			} else {
+				require.Nil(err)
+				require.Equal(test.totp, totp)
+			}
+
+			require.Nil(db.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestTotpRepositorySaveSecret(t *testing.T) {
+	t.Parallel()
+
+	subtests := []struct {
+		name         string
+		rowsAffected int64
+		error        error
+	}{
+		{`SavesSecret`, 1, nil},
+		{`ReturnsTotpAlreadyEnabled`, 0, ErrTotpAlreadyEnabled},
+	}
+
+	for _, test := range subtests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+			repository, db := newTestTotpRepository(t)
+
+			db.
+				ExpectExec(`INSERT INTO user_totp .* WHERE user_totp.confirmed_at IS NULL;`).
+				WithArgs(uint32(1337), `SECRET`).
+				WillReturnResult(pgxmock.NewResult(`INSERT`, test.rowsAffected))
+
+			err := repository.SaveSecret(context.Background(), 1337, `SECRET`)
+
+			if test.error != nil {
+				require.ErrorIs(err, test.error)
+			} else {
+				require.Nil(err)
+			}
+
+			require.Nil(db.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestTotpRepositoryConfirm(t *testing.T) {
+	t.Parallel()
+
+	dto := confirmTotpRepositoryDto{
+		UserId:             1337,
+		Step:               42,
+		ConfirmedAt:        time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		RecoveryCodeHashes: []string{`hash1`, `hash2`},
+	}
+
+	t.Run(`ReplacesRecoveryCodes`, func(t *testing.T) {
+		require := require.New(t)
+		repository, db := newTestTotpRepository(t)
+
+		db.ExpectBegin()
+		db.
+			ExpectExec(`UPDATE user_totp SET confirmed_at = \$2, last_used_step = \$3`).
+			WithArgs(uint32(1337), dto.ConfirmedAt, int64(42)).
+			WillReturnResult(pgxmock.NewResult(`UPDATE`, 1))
+		db.
+			ExpectExec(`DELETE FROM user_recovery_codes WHERE user_id = \$1;`).
+			WithArgs(uint32(1337)).
+			WillReturnResult(pgxmock.NewResult(`DELETE`, 0))
+		db.
+			ExpectExec(`INSERT INTO user_recovery_codes`).
+			WithArgs(uint32(1337), dto.RecoveryCodeHashes).
+			WillReturnResult(pgxmock.NewResult(`INSERT`, 2))
+		db.ExpectCommit()
+
+		require.Nil(repository.Confirm(context.Background(), dto))
+		require.Nil(db.ExpectationsWereMet())
+	})
+
+	t.Run(`ReturnsTotpAlreadyEnabled`, func(t *testing.T) {
+		require := require.New(t)
+		repository, db := newTestTotpRepository(t)
+
+		db.ExpectBegin()
+		db.
+			ExpectExec(`UPDATE user_totp SET confirmed_at = \$2, last_used_step = \$3`).
+			WithArgs(uint32(1337), dto.ConfirmedAt, int64(42)).
+			WillReturnResult(pgxmock.NewResult(`UPDATE`, 0))
+		db.ExpectRollback()
+
+		require.ErrorIs(
+			repository.Confirm(context.Background(), dto),
+			ErrTotpAlreadyEnabled,
+		)
+		require.Nil(db.ExpectationsWereMet())
+	})
+}
+
+func TestTotpRepositoryUseStep(t *testing.T) {
+	t.Parallel()
+
+	subtests := []struct {
+		name         string
+		rowsAffected int64
+		used         bool
+	}{
+		{`MarksStepUsed`, 1, true},
+		{`RejectsUsedStep`, 0, false},
+	}
+
+	for _, test := range subtests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+			repository, db := newTestTotpRepository(t)
+
+			db.
+				ExpectExec(`UPDATE user_totp SET last_used_step = \$2`).
+				WithArgs(uint32(1337), int64(42)).
+				WillReturnResult(pgxmock.NewResult(`UPDATE`, test.rowsAffected))
+
+			used, err := repository.UseStep(context.Background(), 1337, 42)
+
+			require.Nil(err)
+			require.Equal(test.used, used)
+			require.Nil(db.ExpectationsWereMet())
+		})
+	}
+}
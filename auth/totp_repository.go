@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"context"
+	"time"
+)
+
+type TotpRepository interface {
+	// Fails with ErrTotpNotEnrolled when user has no secret
+	Get(ctx context.Context, userId uint32) (*totpEntity, error)
+	// Replaces unconfirmed secret of the user, fails with
+	// ErrTotpAlreadyEnabled when confirmed one exists
+	SaveSecret(ctx context.Context, userId uint32, secret string) error
+	// Confirms secret with code of step and replaces recovery codes of the
+	// user. Fails with ErrTotpAlreadyEnabled when secret is already confirmed
+	Confirm(ctx context.Context, dto confirmTotpRepositoryDto) error
+	// Marks step used unless it or later one is used already, returns whether
+	// it was marked
+	UseStep(ctx context.Context, userId uint32, step uint64) (bool, error)
+	// Marks recovery code used unless it is used already, returns whether it
+	// was marked
+	UseRecoveryCode(
+		ctx context.Context,
+		userId uint32,
+		codeHash string,
+		now time.Time,
+	) (bool, error)
+	// Deletes secret and recovery codes of the user
+	Delete(ctx context.Context, userId uint32) error
+}
+
+type totpEntity struct {
+	UserId uint32
+	// Base32 encoded
+	Secret       string
+	ConfirmedAt  *time.Time
+	LastUsedStep uint64
+}
+
+type confirmTotpRepositoryDto struct {
+	UserId             uint32
+	Step               uint64
+	ConfirmedAt        time.Time
+	RecoveryCodeHashes []string
+}
@@ -0,0 +1,350 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"strconv"
+	"strings"
+
+	"finanstar/server/crypto"
+	"finanstar/server/session"
+)
+
+// Second factor with TOTP codes of authenticator app. Secret is enrolled
+// unconfirmed and starts protecting sign in once the user confirms it with a
+// code, at that moment one-time recovery codes are issued for a lost device
+
+const (
+	TOTP_DEFAULT_ISSUER = "Finanstar"
+	// Codes of this many steps before and after the current one are accepted,
+	// tolerating clock drift of authenticator
+	TOTP_SKEW_STEPS = 1
+	// How many recovery codes are issued on confirmation
+	TOTP_RECOVERY_CODES_COUNT = 10
+	// Length of recovery code in bytes, it is hex encoded and stored hashed
+	// with crypto.HashToken
+	TOTP_RECOVERY_CODE_LENGTH = crypto.MIN_TOKEN_ENTROPY_BYTES
+	// Recovery code is shown split into groups of this many characters
+	TOTP_RECOVERY_CODE_GROUP = 5
+)
+
+const (
+	TOTP_NOT_ENROLLED_ERROR     = "Two-factor authentication is not enrolled"
+	TOTP_ALREADY_ENABLED_ERROR  = "Two-factor authentication is already enabled"
+	INVALID_SECOND_FACTOR_ERROR = "Invalid second factor code"
+)
+
+var (
+	ErrTotpNotEnrolled     = errors.New(TOTP_NOT_ENROLLED_ERROR)
+	ErrTotpAlreadyEnabled  = errors.New(TOTP_ALREADY_ENABLED_ERROR)
+	ErrInvalidSecondFactor = errors.New(INVALID_SECOND_FACTOR_ERROR)
+)
+
+type TotpServiceOptions struct {
+	// Shown by authenticator apps next to account name, defaults to
+	// TOTP_DEFAULT_ISSUER
+	Issuer string
+	// Defaults to session.SystemClock
+	Clock session.Clock
+	// Counts wrong codes per user, which is passed to it as login with empty
+	// IP. Defaults to NopLoginLimiter
+	Limiter LoginLimiter
+}
+
+type TotpService struct {
+	repository TotpRepository
+	options    TotpServiceOptions
+}
+
+type TotpEnrollment struct {
+	// Base32 encoded, for manual entry
+	Secret string
+	// otpauth:// URI, the payload of QR code
+	Uri string
+}
+
+func NewTotpService(
+	repository TotpRepository,
+	options *TotpServiceOptions,
+) TotpService {
+	service := TotpService{repository: repository}
+
+	if options != nil {
+		service.options = *options
+	}
+
+	if service.options.Issuer == "" {
+		service.options.Issuer = TOTP_DEFAULT_ISSUER
+	}
+
+	if service.options.Clock == nil {
+		service.options.Clock = session.SystemClock
+	}
+
+	if service.options.Limiter == nil {
+		service.options.Limiter = NopLoginLimiter{}
+	}
+
+	return service
+}
+
+// Generates new unconfirmed secret of the user, replacing unconfirmed one.
+// accountName is shown by authenticator apps, usually it is user login
+func (self *TotpService) BeginEnrollment(
+	ctx context.Context,
+	userId uint32,
+	accountName string,
+) (*TotpEnrollment, error) {
+	secret, err := crypto.GenerateTotpSecret()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = self.repository.SaveSecret(ctx, userId, secret); err != nil {
+		return nil, err
+	}
+
+	return &TotpEnrollment{
+		Secret: secret,
+		Uri:    crypto.TotpUri(self.options.Issuer, accountName, secret),
+	}, nil
+}
+
+// Enables second factor once code matches enrolled secret. Returns recovery
+// codes, they are stored hashed and can't be shown again. Fails with
+// *LoginThrottledError after too many wrong codes
+func (self *TotpService) ConfirmEnrollment(
+	ctx context.Context,
+	userId uint32,
+	code string,
+) ([]string, error) {
+	totp, err := self.repository.Get(ctx, userId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if totp.ConfirmedAt != nil {
+		return nil, ErrTotpAlreadyEnabled
+	}
+
+	var step uint64
+
+	err = self.limitGuesses(ctx, userId, func() error {
+		step, err = self.matchStep(totp, code)
+
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, TOTP_RECOVERY_CODES_COUNT)
+	hashes := make([]string, TOTP_RECOVERY_CODES_COUNT)
+
+	for index := range codes {
+		code, err := crypto.GenerateSecureId(TOTP_RECOVERY_CODE_LENGTH)
+
+		if err != nil {
+			return nil, err
+		}
+
+		codes[index] = groupRecoveryCode(code)
+		hashes[index] = crypto.HashToken(code)
+	}
+
+	err = self.repository.Confirm(ctx, confirmTotpRepositoryDto{
+		UserId:             userId,
+		Step:               step,
+		ConfirmedAt:        self.options.Clock.Now(),
+		RecoveryCodeHashes: hashes,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// Whether sign in of the user requires second factor
+func (self *TotpService) IsEnabled(
+	ctx context.Context,
+	userId uint32,
+) (bool, error) {
+	totp, err := self.repository.Get(ctx, userId)
+
+	if errors.Is(err, ErrTotpNotEnrolled) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return totp.ConfirmedAt != nil, nil
+}
+
+// Accepts TOTP code or recovery code. Either of them is accepted only once,
+// fails with ErrInvalidSecondFactor otherwise and with *LoginThrottledError
+// after too many wrong codes
+func (self *TotpService) Verify(
+	ctx context.Context,
+	userId uint32,
+	code string,
+) error {
+	return self.limitGuesses(ctx, userId, func() error {
+		return self.verify(ctx, userId, code)
+	})
+}
+
+func (self *TotpService) verify(
+	ctx context.Context,
+	userId uint32,
+	code string,
+) error {
+	totp, err := self.repository.Get(ctx, userId)
+
+	if err != nil {
+		return err
+	}
+
+	if totp.ConfirmedAt == nil {
+		return ErrTotpNotEnrolled
+	}
+
+	var used bool
+
+	if isTotpCode(code) {
+		step, err := self.matchStep(totp, code)
+
+		if err != nil {
+			return err
+		}
+
+		// Concurrent request may have used the same code meanwhile
+		used, err = self.repository.UseStep(ctx, userId, step)
+
+		if err != nil {
+			return err
+		}
+	} else {
+		used, err = self.repository.UseRecoveryCode(
+			ctx,
+			userId,
+			crypto.HashToken(normalizeRecoveryCode(code)),
+			self.options.Clock.Now(),
+		)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if !used {
+		return ErrInvalidSecondFactor
+	}
+
+	return nil
+}
+
+// Turns second factor off after verifying code, so stolen session alone
+// can't do it
+func (self *TotpService) Disable(
+	ctx context.Context,
+	userId uint32,
+	code string,
+) error {
+	if err := self.Verify(ctx, userId, code); err != nil {
+		return err
+	}
+
+	return self.repository.Delete(ctx, userId)
+}
+
+// Counts code guess of the user, guess stays counted unless check succeeds
+func (self *TotpService) limitGuesses(
+	ctx context.Context,
+	userId uint32,
+	check func() error,
+) error {
+	limiter := self.options.Limiter
+	attempt, err := limiter.Attempt(ctx, strconv.FormatUint(uint64(userId), 10), "")
+
+	if err != nil {
+		return err
+	}
+
+	if err = check(); err != nil {
+		if errors.Is(err, ErrInvalidSecondFactor) {
+			limiter.Failed(ctx, attempt)
+		}
+
+		return err
+	}
+
+	return limiter.Succeeded(ctx, attempt)
+}
+
+// Returns step within skew window which code belongs to. Steps up to the
+// last used one are skipped, so a code can't be replayed
+func (self *TotpService) matchStep(totp *totpEntity, code string) (uint64, error) {
+	current := crypto.TotpStep(self.options.Clock.Now())
+
+	for offset := -TOTP_SKEW_STEPS; offset <= TOTP_SKEW_STEPS; offset++ {
+		step := uint64(int64(current) + int64(offset))
+
+		if step <= totp.LastUsedStep {
+			continue
+		}
+
+		expected, err := crypto.TotpCode(totp.Secret, step)
+
+		if err != nil {
+			return 0, err
+		}
+
+		if subtle.ConstantTimeCompare([]byte(code), []byte(expected)) == 1 {
+			return step, nil
+		}
+	}
+
+	return 0, ErrInvalidSecondFactor
+}
+
+func isTotpCode(code string) bool {
+	if len(code) != crypto.TOTP_DIGITS {
+		return false
+	}
+
+	for _, char := range code {
+		if char < '0' || char > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Splits code into dash separated groups, so it is easier to copy by hand
+func groupRecoveryCode(code string) string {
+	groups := make([]string, 0, len(code)/TOTP_RECOVERY_CODE_GROUP+1)
+
+	for len(code) > TOTP_RECOVERY_CODE_GROUP {
+		groups = append(groups, code[:TOTP_RECOVERY_CODE_GROUP])
+		code = code[TOTP_RECOVERY_CODE_GROUP:]
+	}
+
+	return strings.Join(append(groups, code), "-")
+}
+
+// Recovery codes are shown grouped with dash, users may type them in any case
+// and with or without it
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
@@ -0,0 +1,402 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"finanstar/server/crypto"
+	"finanstar/server/session"
+)
+
+// Keeps TOTP secrets in memory, mirrors constraints of PostgreSQL repository
+type memoryTotpRepository struct {
+	mu            sync.Mutex
+	totps         map[uint32]totpEntity
+	recoveryCodes map[uint32]map[string]bool
+}
+
+func newMemoryTotpRepository() *memoryTotpRepository {
+	return &memoryTotpRepository{
+		totps:         map[uint32]totpEntity{},
+		recoveryCodes: map[uint32]map[string]bool{},
+	}
+}
+
+func (self *memoryTotpRepository) Get(
+	ctx context.Context,
+	userId uint32,
+) (*totpEntity, error) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	totp, exists := self.totps[userId]
+
+	if !exists {
+		return nil, ErrTotpNotEnrolled
+	}
+
+	return &totp, nil
+}
+
+func (self *memoryTotpRepository) SaveSecret(
+	ctx context.Context,
+	userId uint32,
+	secret string,
+) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if self.totps[userId].ConfirmedAt != nil {
+		return ErrTotpAlreadyEnabled
+	}
+
+	self.totps[userId] = totpEntity{UserId: userId, Secret: secret}
+
+	return nil
+}
+
+func (self *memoryTotpRepository) Confirm(
+	ctx context.Context,
+	dto confirmTotpRepositoryDto,
+) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	totp, exists := self.totps[dto.UserId]
+
+	if !exists || totp.ConfirmedAt != nil {
+		return ErrTotpAlreadyEnabled
+	}
+
+	totp.ConfirmedAt = &dto.ConfirmedAt
+	totp.LastUsedStep = dto.Step
+	self.totps[dto.UserId] = totp
+	self.recoveryCodes[dto.UserId] = map[string]bool{}
+
+	for _, hash := range dto.RecoveryCodeHashes {
+		self.recoveryCodes[dto.UserId][hash] = false
+	}
+
+	return nil
+}
+
+func (self *memoryTotpRepository) UseStep(
+	ctx context.Context,
+	userId uint32,
+	step uint64,
+) (bool, error) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	totp, exists := self.totps[userId]
+
+	if !exists || totp.ConfirmedAt == nil || totp.LastUsedStep >= step {
+		return false, nil
+	}
+
+	totp.LastUsedStep = step
+	self.totps[userId] = totp
+
+	return true, nil
+}
+
+func (self *memoryTotpRepository) UseRecoveryCode(
+	ctx context.Context,
+	userId uint32,
+	codeHash string,
+	now time.Time,
+) (bool, error) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	used, exists := self.recoveryCodes[userId][codeHash]
+
+	if !exists || used {
+		return false, nil
+	}
+
+	self.recoveryCodes[userId][codeHash] = true
+
+	return true, nil
+}
+
+func (self *memoryTotpRepository) Delete(ctx context.Context, userId uint32) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	delete(self.totps, userId)
+	delete(self.recoveryCodes, userId)
+
+	return nil
+}
+
+type testTotp struct {
+	service *TotpService
+	clock   *session.ManualClock
+}
+
+func newTestTotp() testTotp {
+	clock := session.NewManualClock(time.Unix(1_700_000_000, 0))
+	service := NewTotpService(
+		newMemoryTotpRepository(),
+		&TotpServiceOptions{Clock: clock},
+	)
+
+	return testTotp{&service, clock}
+}
+
+// Code of the step at offset from the current one
+func (self *testTotp) code(t *testing.T, secret string, offset int) string {
+	step := int64(crypto.TotpStep(self.clock.Now())) + int64(offset)
+	code, err := crypto.TotpCode(secret, uint64(step))
+
+	require.Nil(t, err)
+
+	return code
+}
+
+// Enrolls and confirms second factor, returns secret and recovery codes
+func (self *testTotp) enable(t *testing.T, userId uint32) (string, []string) {
+	require := require.New(t)
+	ctx := context.Background()
+	enrollment, err := self.service.BeginEnrollment(ctx, userId, `test@example.com`)
+
+	require.Nil(err)
+
+	codes, err := self.service.ConfirmEnrollment(
+		ctx,
+		userId,
+		self.code(t, enrollment.Secret, 0),
+	)
+
+	require.Nil(err)
+
+	return enrollment.Secret, codes
+}
+
+func TestTotpEnrollment(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ctx := context.Background()
+	totp := newTestTotp()
+
+	enrollment, err := totp.service.BeginEnrollment(ctx, 1337, `test@example.com`)
+
+	require.Nil(err)
+	require.Contains(enrollment.Uri, `otpauth://totp/Finanstar:test@example.com?`)
+	require.Contains(enrollment.Uri, `secret=`+enrollment.Secret)
+
+	// Unconfirmed secret doesn't protect sign in
+	enabled, err := totp.service.IsEnabled(ctx, 1337)
+
+	require.Nil(err)
+	require.False(enabled)
+
+	_, err = totp.service.ConfirmEnrollment(ctx, 1337, totp.code(t, enrollment.Secret, 5))
+
+	require.ErrorIs(err, ErrInvalidSecondFactor)
+
+	codes, err := totp.service.ConfirmEnrollment(
+		ctx,
+		1337,
+		totp.code(t, enrollment.Secret, 0),
+	)
+
+	require.Nil(err)
+	require.Len(codes, TOTP_RECOVERY_CODES_COUNT)
+	require.Regexp(`^[0-9a-f]{5}(-[0-9a-f]{5}){3}$`, codes[0])
+
+	enabled, err = totp.service.IsEnabled(ctx, 1337)
+
+	require.Nil(err)
+	require.True(enabled)
+
+	_, err = totp.service.BeginEnrollment(ctx, 1337, `test@example.com`)
+
+	require.ErrorIs(err, ErrTotpAlreadyEnabled)
+
+	_, err = totp.service.ConfirmEnrollment(ctx, 1337, totp.code(t, enrollment.Secret, 1))
+
+	require.ErrorIs(err, ErrTotpAlreadyEnabled)
+
+	enabled, err = totp.service.IsEnabled(ctx, 1336)
+
+	require.Nil(err)
+	require.False(enabled)
+}
+
+func TestTotpVerify(t *testing.T) {
+	t.Parallel()
+
+	testVariants := []struct {
+		title string
+		// Step of code relative to current one
+		offset int
+		error  error
+	}{
+		{"Current step", 0, nil},
+		{"Previous step within skew", -1, nil},
+		{"Next step within skew", 1, nil},
+		{"Step outside skew", -2, ErrInvalidSecondFactor},
+		{"Future step outside skew", 2, ErrInvalidSecondFactor},
+	}
+
+	for _, tt := range testVariants {
+		t.Run(tt.title, func(t *testing.T) {
+			require := require.New(t)
+			totp := newTestTotp()
+			secret, _ := totp.enable(t, 1337)
+
+			// Confirmation has used the current step
+			totp.clock.Advance(5 * crypto.TOTP_PERIOD)
+
+			err := totp.service.Verify(
+				context.Background(),
+				1337,
+				totp.code(t, secret, tt.offset),
+			)
+
+			if tt.error != nil {
+				require.ErrorIs(err, tt.error)
+			} else {
+				require.Nil(err)
+			}
+		})
+	}
+
+	t.Run("Replayed code", func(t *testing.T) {
+		require := require.New(t)
+		ctx := context.Background()
+		totp := newTestTotp()
+		secret, _ := totp.enable(t, 1337)
+
+		// Code used for confirmation is rejected as well
+		require.ErrorIs(
+			totp.service.Verify(ctx, 1337, totp.code(t, secret, 0)),
+			ErrInvalidSecondFactor,
+		)
+
+		totp.clock.Advance(crypto.TOTP_PERIOD)
+
+		code := totp.code(t, secret, 0)
+
+		require.Nil(totp.service.Verify(ctx, 1337, code))
+		require.ErrorIs(totp.service.Verify(ctx, 1337, code), ErrInvalidSecondFactor)
+
+		// Earlier step within skew is rejected after later one was used
+		require.ErrorIs(
+			totp.service.Verify(ctx, 1337, totp.code(t, secret, -1)),
+			ErrInvalidSecondFactor,
+		)
+	})
+
+	t.Run("Recovery codes", func(t *testing.T) {
+		require := require.New(t)
+		ctx := context.Background()
+		totp := newTestTotp()
+		_, codes := totp.enable(t, 1337)
+
+		require.Nil(totp.service.Verify(ctx, 1337, codes[0]))
+		require.ErrorIs(totp.service.Verify(ctx, 1337, codes[0]), ErrInvalidSecondFactor)
+		require.Nil(totp.service.Verify(
+			ctx,
+			1337,
+			strings.ToUpper(strings.ReplaceAll(codes[1], `-`, ``)),
+		))
+		require.ErrorIs(totp.service.Verify(ctx, 1337, `abcde-12345`), ErrInvalidSecondFactor)
+		require.ErrorIs(totp.service.Verify(ctx, 1336, codes[2]), ErrTotpNotEnrolled)
+	})
+
+	t.Run("Unconfirmed secret", func(t *testing.T) {
+		require := require.New(t)
+		ctx := context.Background()
+		totp := newTestTotp()
+		enrollment, err := totp.service.BeginEnrollment(ctx, 1337, `test@example.com`)
+
+		require.Nil(err)
+		require.ErrorIs(
+			totp.service.Verify(ctx, 1337, totp.code(t, enrollment.Secret, 0)),
+			ErrTotpNotEnrolled,
+		)
+	})
+}
+
+func TestTotpDisable(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ctx := context.Background()
+	totp := newTestTotp()
+	secret, codes := totp.enable(t, 1337)
+
+	require.ErrorIs(
+		totp.service.Disable(ctx, 1337, totp.code(t, secret, 5)),
+		ErrInvalidSecondFactor,
+	)
+	require.Nil(totp.service.Disable(ctx, 1337, codes[0]))
+
+	enabled, err := totp.service.IsEnabled(ctx, 1337)
+
+	require.Nil(err)
+	require.False(enabled)
+
+	// Can be enrolled again
+	totp.enable(t, 1337)
+}
+
+func TestTotpLimitsGuesses(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ctx := context.Background()
+	ll := newTestLoginLimiter(t)
+	service := NewTotpService(
+		newMemoryTotpRepository(),
+		&TotpServiceOptions{Clock: ll.clock, Limiter: ll.limiter},
+	)
+	totp := testTotp{&service, ll.clock}
+	enrollment, err := service.BeginEnrollment(ctx, 1337, `test@example.com`)
+
+	require.Nil(err)
+
+	for range 3 {
+		_, err = service.ConfirmEnrollment(ctx, 1337, totp.code(t, enrollment.Secret, 5))
+
+		require.ErrorIs(err, ErrInvalidSecondFactor)
+	}
+
+	// Throttled guess isn't checked even when it is right
+	_, err = service.ConfirmEnrollment(ctx, 1337, totp.code(t, enrollment.Secret, 0))
+
+	require.ErrorIs(err, ErrLoginThrottled)
+
+	ll.advance(time.Second)
+
+	_, err = service.ConfirmEnrollment(ctx, 1337, totp.code(t, enrollment.Secret, 0))
+
+	require.Nil(err)
+
+	// Verification and disabling share the counter of the user
+	ll.advance(crypto.TOTP_PERIOD)
+
+	for range 3 {
+		require.ErrorIs(service.Verify(ctx, 1337, `abcde-12345`), ErrInvalidSecondFactor)
+	}
+
+	require.ErrorIs(
+		service.Disable(ctx, 1337, totp.code(t, enrollment.Secret, 0)),
+		ErrLoginThrottled,
+	)
+
+	// Other users aren't affected
+	_, err = service.BeginEnrollment(ctx, 1336, `other@example.com`)
+
+	require.Nil(err)
+
+	_, err = service.ConfirmEnrollment(ctx, 1336, `000000`)
+
+	require.ErrorIs(err, ErrInvalidSecondFactor)
+}
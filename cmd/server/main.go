@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+
+	"finanstar/server/api"
+	"finanstar/server/auth"
+	"finanstar/server/config"
+	"finanstar/server/crypto"
+	"finanstar/server/mail"
+	"finanstar/server/session"
+	"finanstar/server/user"
+	utils_pgx "finanstar/server/utils"
+)
+
+const (
+	SHUTDOWN_TIMEOUT = 10 * time.Second
+)
+
+func main() {
+	envFile := flag.String("env-file", config.DEFAULT_ENV_FILE, "Path to .env file")
+	flag.Parse()
+
+	cfg, err := config.Load(*envFile)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	crypto.SetPasswordHashParams(cfg.Argon2id)
+
+	ctx, stop := signal.NotifyContext(
+		context.Background(),
+		os.Interrupt,
+		syscall.SIGTERM,
+	)
+	defer stop()
+
+	pool, err := pgxpool.New(ctx, cfg.Postgresql.DSN())
+
+	if err != nil {
+		log.Fatalf("Failed to connect to PostgreSQL: %v", err)
+	}
+
+	defer pool.Close()
+
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrate(ctx, pool, flag.Args()[1:]); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+
+		return
+	}
+
+	// Keeps sessions and sign in attempts when they are stored in Dragonfly
+	var dragonflyClient *redis.Client
+
+	if cfg.Session.Store == config.SESSION_STORE_DRAGONFLY ||
+		cfg.LoginLimiterStore == config.LOGIN_LIMITER_STORE_DRAGONFLY {
+		dragonflyClient = session.CreateDragonflyClient(&cfg.Dragonfly)
+		defer dragonflyClient.Close()
+	}
+
+	if flag.Arg(0) == "unlock" {
+		if cfg.LoginLimiterStore != config.LOGIN_LIMITER_STORE_DRAGONFLY {
+			log.Fatal("Sign in attempts aren't limited, see LOGIN_LIMITER_STORE")
+		}
+
+		limiterOptions := loginLimiterOptions(cfg.LoginLimiter)
+		limiter := auth.NewDragonflyLoginLimiter(dragonflyClient, &limiterOptions)
+
+		if err := runUnlock(ctx, limiter, flag.Args()[1:]); err != nil {
+			log.Fatalf("Unlock failed: %v", err)
+		}
+
+		return
+	}
+
+	var sessionManager session.SessionManager
+
+	switch cfg.Session.Store {
+	case config.SESSION_STORE_POSTGRESQL:
+		postgresqlSessionManager := session.NewPostgresqlSessionManager(
+			pool,
+			&session.PostgresqlSessionManagerOptions{
+				ExpirationOptions: cfg.Session.ExpirationOptions,
+				LimitOptions:      cfg.Session.LimitOptions,
+				SweepInterval:     cfg.Session.SweepInterval,
+			},
+		)
+
+		go postgresqlSessionManager.RunSweeper(ctx)
+
+		sessionManager = postgresqlSessionManager
+	case config.SESSION_STORE_MEMORY:
+		sessionManager = session.NewMemorySessionManager(
+			&session.MemorySessionManagerOptions{
+				ExpirationOptions: cfg.Session.ExpirationOptions,
+				LimitOptions:      cfg.Session.LimitOptions,
+			},
+		)
+	default:
+		dragonflySessionManager := session.NewDragonflySessionManager(
+			dragonflyClient,
+			&session.DragonflySessionManagerOptions{
+				ExpirationOptions: cfg.Session.ExpirationOptions,
+				LimitOptions:      cfg.Session.LimitOptions,
+				JanitorInterval:   cfg.Session.SweepInterval,
+			},
+		)
+
+		go dragonflySessionManager.RunJanitor(ctx)
+
+		sessionManager = dragonflySessionManager
+	}
+
+	if len(cfg.Session.SigningKeys) != 0 {
+		sessionManager, err = session.NewSignedSessionManager(
+			sessionManager,
+			cfg.Session.SigningKeys,
+		)
+
+		if err != nil {
+			log.Fatalf("Failed to sign sessions: %v", err)
+		}
+	}
+
+	userRepository := user.NewPostgresqlUserRepository(pool)
+	userService := user.NewUserService(&userRepository)
+
+	mailOutput := os.Stderr
+
+	if cfg.Mail.File != "" {
+		mailOutput, err = os.OpenFile(
+			cfg.Mail.File,
+			os.O_APPEND|os.O_CREATE|os.O_WRONLY,
+			0o600,
+		)
+
+		if err != nil {
+			log.Fatalf("Failed to open mail file: %v", err)
+		}
+
+		defer mailOutput.Close()
+	}
+
+	mailSender := mail.NewLogSender(mailOutput)
+	emailVerificationRepository := auth.NewPostgresqlEmailVerificationRepository(pool)
+	emailVerificationService := auth.NewEmailVerificationService(
+		&userService,
+		&emailVerificationRepository,
+		mailSender,
+		&auth.EmailVerificationServiceOptions{
+			Policy:         cfg.EmailVerification.Policy,
+			TokenTtl:       cfg.EmailVerification.TokenTtl,
+			ResendInterval: cfg.EmailVerification.ResendInterval,
+			VerifyUrl:      cfg.EmailVerification.VerifyUrl,
+		},
+	)
+
+	var loginLimiter auth.LoginLimiter = auth.NopLoginLimiter{}
+	var secondFactorLimiter auth.LoginLimiter = auth.NopLoginLimiter{}
+
+	if cfg.LoginLimiterStore == config.LOGIN_LIMITER_STORE_DRAGONFLY {
+		limiterOptions := loginLimiterOptions(cfg.LoginLimiter)
+		limiterOptions.Observer = auth.NewMailLockoutObserver(
+			&userService,
+			mailSender,
+		)
+		loginLimiter = auth.NewDragonflyLoginLimiter(
+			dragonflyClient,
+			&limiterOptions,
+		)
+
+		secondFactorLimiterOptions := loginLimiterOptions(cfg.LoginLimiter)
+		secondFactorLimiterOptions.KeyPrefix = auth.SECOND_FACTOR_ATTEMPTS_KEY_PREFIX
+		secondFactorLimiter = auth.NewDragonflyLoginLimiter(
+			dragonflyClient,
+			&secondFactorLimiterOptions,
+		)
+	} else {
+		log.Printf("Sign in attempts aren't limited, see LOGIN_LIMITER_STORE")
+	}
+
+	totpRepository := auth.NewPostgresqlTotpRepository(pool)
+	totpService := auth.NewTotpService(
+		&totpRepository,
+		&auth.TotpServiceOptions{
+			Issuer:  cfg.Totp.Issuer,
+			Limiter: secondFactorLimiter,
+		},
+	)
+
+	authService := auth.NewAuthService(
+		&userService,
+		sessionManager,
+		&totpService,
+		&emailVerificationService,
+		loginLimiter,
+		nil,
+	)
+	passwordResetRepository := auth.NewPostgresqlPasswordResetRepository(pool)
+	passwordResetService := auth.NewPasswordResetService(
+		&userService,
+		sessionManager,
+		&passwordResetRepository,
+		utils_pgx.NewTxManager(pool, nil),
+		mailSender,
+		&auth.PasswordResetServiceOptions{
+			TokenTtl:       cfg.PasswordReset.TokenTtl,
+			ResendInterval: cfg.PasswordReset.ResendInterval,
+			ResetUrl:       cfg.PasswordReset.ResetUrl,
+		},
+	)
+
+	httpServer := &http.Server{
+		Addr: cfg.Http.Address,
+		Handler: api.NewServer(
+			&userService,
+			&authService,
+			&totpService,
+			&passwordResetService,
+			&emailVerificationService,
+			sessionManager,
+			&api.ServerOptions{
+				Session: api.SessionMiddlewareOptions{
+					ExpirationOptions: cfg.Session.ExpirationOptions,
+					RenewalThreshold:  cfg.Session.RenewalThreshold,
+				},
+				TrustedProxies: cfg.Http.TrustedProxies,
+			},
+		),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	servers := []*http.Server{httpServer}
+
+	if cfg.Http.MetricsAddress != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("GET /debug/vars", expvar.Handler())
+
+		metricsServer := &http.Server{
+			Addr:              cfg.Http.MetricsAddress,
+			Handler:           metricsMux,
+			ReadHeaderTimeout: 5 * time.Second,
+		}
+		servers = append(servers, metricsServer)
+
+		go func() {
+			log.Printf("Serving metrics on %s", cfg.Http.MetricsAddress)
+
+			err := metricsServer.ListenAndServe()
+
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("Metrics server failed: %v", err)
+			}
+		}()
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(
+			context.Background(),
+			SHUTDOWN_TIMEOUT,
+		)
+		defer cancel()
+
+		for _, server := range servers {
+			server.Shutdown(shutdownCtx)
+		}
+	}()
+
+	log.Printf("Listening on %s", cfg.Http.Address)
+
+	err = httpServer.ListenAndServe()
+
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("HTTP server failed: %v", err)
+	}
+}
+
+// Configured limits, the rest of auth.LoginLimits keep their defaults
+func loginLimiterOptions(limits config.LoginLimiterConfig) auth.LoginLimiterOptions {
+	return auth.LoginLimiterOptions{
+		Login: auth.LoginLimits{
+			FreeFailures:     limits.Login.FreeFailures,
+			LockoutThreshold: limits.Login.LockoutThreshold,
+			LockoutTime:      limits.Login.LockoutTime,
+		},
+		Ip: auth.LoginLimits{
+			FreeFailures:     limits.Ip.FreeFailures,
+			LockoutThreshold: limits.Ip.LockoutThreshold,
+			LockoutTime:      limits.Ip.LockoutTime,
+		},
+	}
+}
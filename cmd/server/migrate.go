@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+
+	"finanstar/server/migrations"
+	utils_pgx "finanstar/server/utils"
+)
+
+const (
+	MIGRATE_USAGE = "Usage: server [flags] migrate up|down [steps]"
+)
+
+// Runs "migrate up" or "migrate down [steps]" subcommand
+func runMigrate(
+	ctx context.Context,
+	db utils_pgx.PgxPoolIface,
+	args []string,
+) error {
+	if len(args) == 0 {
+		return errors.New(MIGRATE_USAGE)
+	}
+
+	embeddedMigrations, err := migrations.EmbeddedMigrations()
+
+	if err != nil {
+		return err
+	}
+
+	migrator := migrations.NewMigrator(db, embeddedMigrations)
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Applied migrations: %v", applied)
+	case "down":
+		steps := 1
+
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+
+			if err != nil || steps < 1 {
+				return errors.New(MIGRATE_USAGE)
+			}
+		}
+
+		reverted, err := migrator.Down(ctx, steps)
+
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Reverted migrations: %v", reverted)
+	default:
+		return errors.New(MIGRATE_USAGE)
+	}
+
+	return nil
+}
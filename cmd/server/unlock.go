@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"finanstar/server/auth"
+)
+
+const (
+	UNLOCK_USAGE = "Usage: server [flags] unlock login|ip <value>"
+)
+
+// Runs "unlock login <login>" or "unlock ip <ip>" subcommand lifting sign in
+// lockout and backoff
+func runUnlock(ctx context.Context, limiter auth.LoginLimiter, args []string) error {
+	if len(args) != 2 || len(args[1]) == 0 {
+		return errors.New(UNLOCK_USAGE)
+	}
+
+	var err error
+
+	switch args[0] {
+	case "login":
+		err = limiter.Unlock(ctx, args[1])
+	case "ip":
+		err = limiter.UnlockIp(ctx, args[1])
+	default:
+		return errors.New(UNLOCK_USAGE)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Unlocked %s %s", args[0], args[1])
+
+	return nil
+}
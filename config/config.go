@@ -0,0 +1,566 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+
+	"finanstar/server/api"
+	"finanstar/server/apperror"
+	"finanstar/server/auth"
+	"finanstar/server/crypto"
+	"finanstar/server/session"
+)
+
+// Typed application settings loaded from environment variables and .env
+// files. Variables already present in environment take precedence over files.
+
+const (
+	DEFAULT_ENV_FILE     = ".env"
+	DEFAULT_HTTP_ADDRESS = ":8080"
+)
+
+// Session storage backends selectable with SESSION_STORE
+const (
+	SESSION_STORE_DRAGONFLY  = "dragonfly"
+	SESSION_STORE_POSTGRESQL = "postgresql"
+	// Single-node development only, sessions are lost on restart
+	SESSION_STORE_MEMORY = "memory"
+)
+
+// Sign in attempts limiting backends selectable with LOGIN_LIMITER_STORE
+const (
+	LOGIN_LIMITER_STORE_DRAGONFLY = "dragonfly"
+	// Attempts aren't limited
+	LOGIN_LIMITER_STORE_NONE = "none"
+)
+
+const (
+	MISSING_KEY_ERROR = "is required"
+)
+
+type Config struct {
+	Http       HttpConfig
+	Postgresql PostgresqlConfig
+	Dragonfly  session.CreateDragonflyClientOptions
+	Session    SessionConfig
+	Totp       TotpConfig
+	// See auth.PasswordResetServiceOptions
+	PasswordReset PasswordResetConfig
+	// See auth.EmailVerificationServiceOptions
+	EmailVerification EmailVerificationConfig
+	Mail              MailConfig
+	// See auth.LoginLimits, the rest of the limits keep auth defaults
+	LoginLimiter LoginLimiterConfig
+	// One of LOGIN_LIMITER_STORE_* constants, defaults to dragonfly with
+	// dragonfly session store and to none otherwise
+	LoginLimiterStore string
+	Argon2id          crypto.PasswordHashParams
+}
+
+type TotpConfig struct {
+	// Shown by authenticator apps next to account name
+	Issuer string
+}
+
+type PasswordResetConfig struct {
+	TokenTtl       time.Duration
+	ResendInterval time.Duration
+	ResetUrl       string
+}
+
+type EmailVerificationConfig struct {
+	// One of auth.EMAIL_VERIFICATION_POLICY_* constants
+	Policy         string
+	TokenTtl       time.Duration
+	ResendInterval time.Duration
+	VerifyUrl      string
+}
+
+type LoginLimiterConfig struct {
+	Login LoginLimitsConfig
+	Ip    LoginLimitsConfig
+}
+
+type LoginLimitsConfig struct {
+	FreeFailures     int
+	LockoutThreshold int
+	LockoutTime      time.Duration
+}
+
+type MailConfig struct {
+	// Emails are appended to this file, or written to stderr when it is
+	// empty. There is no real delivery yet
+	File string
+}
+
+type HttpConfig struct {
+	Address string
+	// Serves expvar metrics at /debug/vars, disabled if empty
+	MetricsAddress string
+	// Client IP is taken from X-Forwarded-For set by these proxies
+	TrustedProxies []netip.Prefix
+}
+
+type PostgresqlConfig struct {
+	Host     string
+	Port     uint16
+	Username string
+	Password string
+	Database string
+	SslMode  string
+}
+
+type SessionConfig struct {
+	session.ExpirationOptions
+	session.LimitOptions
+	RenewalThreshold time.Duration
+	// One of SESSION_STORE_* constants
+	Store string
+	// How often expired sessions are cleaned up by postgresql and dragonfly
+	// stores
+	SweepInterval time.Duration
+	// Session tokens are signed when set, see session.SignedSessionManager
+	SigningKeys session.KeyRing
+}
+
+// Lists every missing or invalid key at once
+type ValidationError struct {
+	Problems []string
+}
+
+func (self *ValidationError) Error() string {
+	return fmt.Sprintf(
+		"Invalid configuration:\n  %s",
+		strings.Join(self.Problems, "\n  "),
+	)
+}
+
+func (self *PostgresqlConfig) DSN() string {
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(self.Username, self.Password),
+		Host:     fmt.Sprintf("%s:%d", self.Host, self.Port),
+		Path:     self.Database,
+		RawQuery: url.Values{"sslmode": {self.SslMode}}.Encode(),
+	}
+
+	return dsn.String()
+}
+
+// Loads .env files into environment, skipping absent ones, and reads config
+// from environment
+func Load(envFiles ...string) (*Config, error) {
+	if len(envFiles) == 0 {
+		envFiles = []string{DEFAULT_ENV_FILE}
+	}
+
+	for _, envFile := range envFiles {
+		err := godotenv.Load(envFile)
+
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("Failed to load %s: %w", envFile, err)
+		}
+	}
+
+	return FromLookup(os.LookupEnv)
+}
+
+func FromLookup(lookup func(key string) (string, bool)) (*Config, error) {
+	env := envReader{lookup: lookup}
+	defaultArgon2id := crypto.DefaultPasswordHashParams()
+	lockoutTime := env.duration("LOGIN_LOCKOUT_TIME", auth.LOGIN_LOCKOUT_TIME)
+
+	config := &Config{
+		Http: HttpConfig{
+			Address:        env.string("HTTP_ADDRESS", DEFAULT_HTTP_ADDRESS),
+			MetricsAddress: env.string("HTTP_METRICS_ADDRESS", ""),
+		},
+		Postgresql: PostgresqlConfig{
+			Host:     env.string("POSTGRESQL_HOST", "localhost"),
+			Port:     uint16(env.uint("POSTGRESQL_PORT", 5432, 16)),
+			Username: env.required("POSTGRESQL_USERNAME"),
+			Password: env.required("POSTGRESQL_PASSWORD"),
+			Database: env.required("POSTGRESQL_DATABASE"),
+			SslMode:  env.string("POSTGRESQL_SSL_MODE", "disable"),
+		},
+		Dragonfly: session.CreateDragonflyClientOptions{
+			Host:       env.string("DRAGONFLY_HOST", "localhost"),
+			Port:       uint32(env.uint("DRAGONFLY_PORT", 6379, 16)),
+			DatabaseId: int(env.uint("DRAGONFLY_DATABASE_ID", 0, 8)),
+			Username:   env.string("DRAGONFLY_USERNAME", ""),
+			Password:   env.string("DRAGONFLY_PASSWORD", ""),
+		},
+		Session: SessionConfig{
+			ExpirationOptions: session.ExpirationOptions{
+				Lifetime: env.duration("SESSION_LIFETIME", session.SESSION_LIFETIME),
+				IdleTimeout: env.duration(
+					"SESSION_IDLE_TIMEOUT",
+					session.SESSION_IDLE_TIMEOUT,
+				),
+			},
+			LimitOptions: session.LimitOptions{
+				MaxSessions: int(
+					env.uint("SESSION_MAX_SESSIONS", session.SESSION_MAX_SESSIONS, 16),
+				),
+				LimitPolicy: env.string(
+					"SESSION_LIMIT_POLICY",
+					session.SESSION_LIMIT_POLICY_EVICT,
+				),
+			},
+			RenewalThreshold: env.duration(
+				"SESSION_RENEWAL_THRESHOLD",
+				api.DEFAULT_SESSION_RENEWAL_THRESHOLD,
+			),
+			Store: env.string("SESSION_STORE", SESSION_STORE_DRAGONFLY),
+			SweepInterval: env.duration(
+				"SESSION_SWEEP_INTERVAL",
+				session.SESSION_SWEEP_INTERVAL,
+			),
+		},
+		Totp: TotpConfig{
+			Issuer: env.string("TOTP_ISSUER", auth.TOTP_DEFAULT_ISSUER),
+		},
+		PasswordReset: PasswordResetConfig{
+			TokenTtl: env.duration(
+				"PASSWORD_RESET_TOKEN_TTL",
+				auth.PASSWORD_RESET_TOKEN_TTL,
+			),
+			ResendInterval: env.duration(
+				"PASSWORD_RESET_RESEND_INTERVAL",
+				auth.PASSWORD_RESET_RESEND_INTERVAL,
+			),
+			ResetUrl: env.string("PASSWORD_RESET_URL", ""),
+		},
+		EmailVerification: EmailVerificationConfig{
+			Policy: env.string(
+				"EMAIL_VERIFICATION_POLICY",
+				auth.EMAIL_VERIFICATION_POLICY_NONE,
+			),
+			TokenTtl: env.duration(
+				"EMAIL_VERIFICATION_TOKEN_TTL",
+				auth.EMAIL_VERIFICATION_TOKEN_TTL,
+			),
+			ResendInterval: env.duration(
+				"EMAIL_VERIFICATION_RESEND_INTERVAL",
+				auth.EMAIL_VERIFICATION_RESEND_INTERVAL,
+			),
+			VerifyUrl: env.string("EMAIL_VERIFICATION_URL", ""),
+		},
+		Mail: MailConfig{
+			File: env.string("MAIL_FILE", ""),
+		},
+		LoginLimiter: LoginLimiterConfig{
+			Login: LoginLimitsConfig{
+				FreeFailures: int(env.uint(
+					"LOGIN_FREE_FAILURES",
+					auth.LOGIN_FREE_FAILURES,
+					16,
+				)),
+				LockoutThreshold: int(env.uint(
+					"LOGIN_LOCKOUT_THRESHOLD",
+					auth.LOGIN_LOCKOUT_THRESHOLD,
+					16,
+				)),
+				LockoutTime: lockoutTime,
+			},
+			Ip: LoginLimitsConfig{
+				FreeFailures: int(env.uint(
+					"LOGIN_IP_FREE_FAILURES",
+					auth.IP_FREE_FAILURES,
+					16,
+				)),
+				LockoutThreshold: int(env.uint(
+					"LOGIN_IP_LOCKOUT_THRESHOLD",
+					auth.IP_LOCKOUT_THRESHOLD,
+					16,
+				)),
+				LockoutTime: lockoutTime,
+			},
+		},
+		LoginLimiterStore: env.string("LOGIN_LIMITER_STORE", ""),
+		Argon2id: crypto.PasswordHashParams{
+			Memory: uint32(
+				env.uint("ARGON2ID_MEMORY", uint64(defaultArgon2id.Memory), 32),
+			),
+			Iterations: uint32(
+				env.uint("ARGON2ID_COST", uint64(defaultArgon2id.Iterations), 32),
+			),
+			Parallelism: uint8(
+				env.uint("ARGON2ID_PARALLELISM", uint64(defaultArgon2id.Parallelism), 8),
+			),
+			SaltLength: uint32(
+				env.uint("ARGON2ID_SALT_LENGTH", uint64(defaultArgon2id.SaltLength), 32),
+			),
+			KeyLength: uint32(
+				env.uint("ARGON2ID_KEY_LENGTH", uint64(defaultArgon2id.KeyLength), 32),
+			),
+		},
+	}
+
+	if config.Session.IdleTimeout > config.Session.Lifetime {
+		env.invalid(
+			"SESSION_IDLE_TIMEOUT",
+			"must not be greater than SESSION_LIFETIME",
+		)
+	}
+
+	if config.Session.RenewalThreshold >= config.Session.IdleTimeout {
+		env.invalid(
+			"SESSION_RENEWAL_THRESHOLD",
+			"must be less than SESSION_IDLE_TIMEOUT",
+		)
+	}
+
+	switch config.Session.Store {
+	case SESSION_STORE_DRAGONFLY, SESSION_STORE_POSTGRESQL, SESSION_STORE_MEMORY:
+	default:
+		env.invalid(
+			"SESSION_STORE",
+			fmt.Sprintf(
+				"must be one of %s, %s, %s, got %q",
+				SESSION_STORE_DRAGONFLY,
+				SESSION_STORE_POSTGRESQL,
+				SESSION_STORE_MEMORY,
+				config.Session.Store,
+			),
+		)
+	}
+
+	if config.LoginLimiterStore == "" {
+		config.LoginLimiterStore = LOGIN_LIMITER_STORE_NONE
+
+		if config.Session.Store == SESSION_STORE_DRAGONFLY {
+			config.LoginLimiterStore = LOGIN_LIMITER_STORE_DRAGONFLY
+		}
+	}
+
+	switch config.LoginLimiterStore {
+	case LOGIN_LIMITER_STORE_DRAGONFLY, LOGIN_LIMITER_STORE_NONE:
+	default:
+		env.invalid(
+			"LOGIN_LIMITER_STORE",
+			fmt.Sprintf(
+				"must be one of %s, %s, got %q",
+				LOGIN_LIMITER_STORE_DRAGONFLY,
+				LOGIN_LIMITER_STORE_NONE,
+				config.LoginLimiterStore,
+			),
+		)
+	}
+
+	if rawProxies := env.string("HTTP_TRUSTED_PROXIES", ""); rawProxies != "" {
+		for _, rawProxy := range strings.Split(rawProxies, ",") {
+			proxy, err := parsePrefix(strings.TrimSpace(rawProxy))
+
+			if err != nil {
+				env.invalid(
+					"HTTP_TRUSTED_PROXIES",
+					fmt.Sprintf("must list IPs or CIDRs, got %q", rawProxy),
+				)
+
+				break
+			}
+
+			config.Http.TrustedProxies = append(config.Http.TrustedProxies, proxy)
+		}
+	}
+
+	if rawSigningKeys := env.string("SESSION_SIGNING_KEYS", ""); rawSigningKeys != "" {
+		keyRing, err := session.ParseKeyRing(rawSigningKeys)
+
+		if err != nil {
+			var appErr *apperror.Error
+
+			if errors.As(err, &appErr) {
+				err = appErr.Cause
+			}
+
+			env.invalid("SESSION_SIGNING_KEYS", fmt.Sprintf("is invalid: %v", err))
+		}
+
+		config.Session.SigningKeys = keyRing
+	}
+
+	switch config.Session.LimitPolicy {
+	case session.SESSION_LIMIT_POLICY_REJECT, session.SESSION_LIMIT_POLICY_EVICT:
+	default:
+		env.invalid(
+			"SESSION_LIMIT_POLICY",
+			fmt.Sprintf(
+				"must be one of %s, %s, got %q",
+				session.SESSION_LIMIT_POLICY_REJECT,
+				session.SESSION_LIMIT_POLICY_EVICT,
+				config.Session.LimitPolicy,
+			),
+		)
+	}
+
+	switch config.EmailVerification.Policy {
+	case auth.EMAIL_VERIFICATION_POLICY_NONE,
+		auth.EMAIL_VERIFICATION_POLICY_READ_ONLY,
+		auth.EMAIL_VERIFICATION_POLICY_NO_SIGN_IN:
+	default:
+		env.invalid(
+			"EMAIL_VERIFICATION_POLICY",
+			fmt.Sprintf(
+				"must be one of %s, %s, %s, got %q",
+				auth.EMAIL_VERIFICATION_POLICY_NONE,
+				auth.EMAIL_VERIFICATION_POLICY_READ_ONLY,
+				auth.EMAIL_VERIFICATION_POLICY_NO_SIGN_IN,
+				config.EmailVerification.Policy,
+			),
+		)
+	}
+
+	loginLimits := config.LoginLimiter.Login
+
+	// auth.LoginLimits takes 0 for unset and falls back to defaults
+	if loginLimits.FreeFailures == 0 {
+		env.invalid("LOGIN_FREE_FAILURES", "must be greater than 0")
+	} else if loginLimits.FreeFailures >= loginLimits.LockoutThreshold {
+		env.invalid(
+			"LOGIN_FREE_FAILURES",
+			"must be less than LOGIN_LOCKOUT_THRESHOLD",
+		)
+	}
+
+	ipLimits := config.LoginLimiter.Ip
+
+	if ipLimits.FreeFailures == 0 {
+		env.invalid("LOGIN_IP_FREE_FAILURES", "must be greater than 0")
+	} else if ipLimits.FreeFailures >= ipLimits.LockoutThreshold {
+		env.invalid(
+			"LOGIN_IP_FREE_FAILURES",
+			"must be less than LOGIN_IP_LOCKOUT_THRESHOLD",
+		)
+	}
+
+	argon2id := config.Argon2id
+
+	if argon2id.Iterations == 0 || argon2id.Parallelism == 0 {
+		env.invalid(
+			"ARGON2ID_COST, ARGON2ID_PARALLELISM",
+			"must be greater than 0",
+		)
+	}
+
+	if argon2id.Memory < crypto.ARGON2ID_MIN_MEMORY_PER_LANE*uint32(argon2id.Parallelism) {
+		env.invalid(
+			"ARGON2ID_MEMORY",
+			fmt.Sprintf(
+				"must be at least %d KiB per ARGON2ID_PARALLELISM lane",
+				crypto.ARGON2ID_MIN_MEMORY_PER_LANE,
+			),
+		)
+	}
+
+	if argon2id.SaltLength < crypto.ARGON2ID_MIN_SALT_LENGTH {
+		env.invalid(
+			"ARGON2ID_SALT_LENGTH",
+			fmt.Sprintf("must be at least %d", crypto.ARGON2ID_MIN_SALT_LENGTH),
+		)
+	}
+
+	if argon2id.KeyLength < crypto.ARGON2ID_MIN_KEY_LENGTH {
+		env.invalid(
+			"ARGON2ID_KEY_LENGTH",
+			fmt.Sprintf("must be at least %d", crypto.ARGON2ID_MIN_KEY_LENGTH),
+		)
+	}
+
+	if len(env.problems) != 0 {
+		return nil, &ValidationError{Problems: env.problems}
+	}
+
+	return config, nil
+}
+
+// Parses CIDR or single IP, which is a prefix of its full length
+func parsePrefix(value string) (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(value); err == nil {
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+
+	prefix, err := netip.ParsePrefix(value)
+
+	return prefix.Masked(), err
+}
+
+type envReader struct {
+	lookup   func(key string) (string, bool)
+	problems []string
+}
+
+func (self *envReader) invalid(key string, problem string) {
+	self.problems = append(self.problems, fmt.Sprintf("%s %s", key, problem))
+}
+
+func (self *envReader) string(key string, defaultValue string) string {
+	value, ok := self.lookup(key)
+
+	if !ok || len(value) == 0 {
+		return defaultValue
+	}
+
+	return value
+}
+
+func (self *envReader) required(key string) string {
+	value, ok := self.lookup(key)
+
+	if !ok || len(value) == 0 {
+		self.invalid(key, MISSING_KEY_ERROR)
+	}
+
+	return value
+}
+
+func (self *envReader) uint(key string, defaultValue uint64, bitSize int) uint64 {
+	value, ok := self.lookup(key)
+
+	if !ok || len(value) == 0 {
+		return defaultValue
+	}
+
+	parsed, err := strconv.ParseUint(value, 10, bitSize)
+
+	if err != nil {
+		self.invalid(
+			key,
+			fmt.Sprintf("must be unsigned %d-bit integer, got %q", bitSize, value),
+		)
+
+		return defaultValue
+	}
+
+	return parsed
+}
+
+func (self *envReader) duration(key string, defaultValue time.Duration) time.Duration {
+	value, ok := self.lookup(key)
+
+	if !ok || len(value) == 0 {
+		return defaultValue
+	}
+
+	parsed, err := time.ParseDuration(value)
+
+	if err != nil || parsed <= 0 {
+		self.invalid(
+			key,
+			fmt.Sprintf("must be positive duration like 336h, got %q", value),
+		)
+
+		return defaultValue
+	}
+
+	return parsed
+}
@@ -2,6 +2,7 @@ package crypto
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 
@@ -14,8 +15,35 @@ const (
 	ARGON2ID_PARALLELISM = 1
 	ARGON2ID_KEY_LENGTH  = 32
 	ARGON2ID_SALT_LENGTH = 16
+	// Lower bounds accepted from configuration. Argon2 itself needs 8 KiB of
+	// memory per lane, shorter salt and key weaken the hash
+	ARGON2ID_MIN_MEMORY_PER_LANE = 8
+	ARGON2ID_MIN_SALT_LENGTH     = 16
+	ARGON2ID_MIN_KEY_LENGTH      = 16
+	// Random bytes of token required by HashToken, i.e. 80 bits
+	MIN_TOKEN_ENTROPY_BYTES = 10
 )
 
+type PasswordHashParams = argon2id.Params
+
+var passwordHashParams = DefaultPasswordHashParams()
+
+func DefaultPasswordHashParams() PasswordHashParams {
+	return PasswordHashParams{
+		Memory:      ARGON2ID_MEMORY,
+		Iterations:  ARGON2ID_COST,
+		Parallelism: ARGON2ID_PARALLELISM,
+		SaltLength:  ARGON2ID_SALT_LENGTH,
+		KeyLength:   ARGON2ID_KEY_LENGTH,
+	}
+}
+
+// Sets params used by HashPassword, must be called once on startup before
+// any password is hashed
+func SetPasswordHashParams(params PasswordHashParams) {
+	passwordHashParams = params
+}
+
 func GenerateSecureId(length int) (string, error) {
 	randomBytes := make([]byte, length)
 	_, err := rand.Read(randomBytes)
@@ -31,16 +59,8 @@ func GenerateSecureId(length int) (string, error) {
 }
 
 func HashPassword(password string) (string, error) {
-	hashedPassword, err := argon2id.CreateHash(
-		password,
-		&argon2id.Params{
-			Memory:      ARGON2ID_MEMORY,
-			Iterations:  ARGON2ID_COST,
-			Parallelism: ARGON2ID_PARALLELISM,
-			SaltLength:  ARGON2ID_SALT_LENGTH,
-			KeyLength:   ARGON2ID_KEY_LENGTH,
-		},
-	)
+	params := passwordHashParams
+	hashedPassword, err := argon2id.CreateHash(password, &params)
 
 	if err != nil {
 		return ``, err
@@ -52,3 +72,25 @@ func HashPassword(password string) (string, error) {
 func ComparePasswords(password, hash string) (bool, error) {
 	return argon2id.ComparePasswordAndHash(password, hash)
 }
+
+// Reports whether hash was made with params other than the ones HashPassword
+// uses now, so password should be hashed again once it is known
+func PasswordHashNeedsRehash(hash string) (bool, error) {
+	params, _, _, err := argon2id.DecodeHash(hash)
+
+	if err != nil {
+		return false, err
+	}
+
+	return *params != passwordHashParams, nil
+}
+
+// Hashes random token before it is stored, so it can be looked up by hash.
+// Hash is fast and unsalted, so token must carry at least
+// MIN_TOKEN_ENTROPY_BYTES random bytes to withstand offline guessing from a
+// leaked database. Use HashPassword for anything shorter
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
@@ -37,3 +37,70 @@ func TestComparePasswords(t *testing.T) {
 	require.Nil(err)
 	require.Equal(match, true)
 }
+
+func TestSetPasswordHashParams(t *testing.T) {
+	require := require.New(t)
+
+	params := DefaultPasswordHashParams()
+	params.Memory = 32 * 1024
+	params.Iterations = 3
+
+	SetPasswordHashParams(params)
+	defer SetPasswordHashParams(DefaultPasswordHashParams())
+
+	hashedPassword, err := HashPassword(`secure-password`)
+
+	require.Nil(err)
+	require.Contains(hashedPassword, `$argon2id$v=19$m=32768,t=3,p=1$`)
+}
+
+func TestPasswordHashNeedsRehash(t *testing.T) {
+	hashedPassword, err := HashPassword(`secure-password`)
+
+	require.Nil(t, err)
+
+	needsRehash, err := PasswordHashNeedsRehash(hashedPassword)
+
+	require.Nil(t, err)
+	require.False(t, needsRehash)
+
+	_, err = PasswordHashNeedsRehash(`not-a-hash`)
+
+	require.NotNil(t, err)
+
+	testVariants := []struct {
+		title  string
+		params func(params *PasswordHashParams)
+	}{
+		{"Memory changed", func(params *PasswordHashParams) { params.Memory = 32 * 1024 }},
+		{"Cost changed", func(params *PasswordHashParams) { params.Iterations = 3 }},
+		{"Parallelism changed", func(params *PasswordHashParams) { params.Parallelism = 2 }},
+		{"Salt length changed", func(params *PasswordHashParams) { params.SaltLength = 32 }},
+		{"Key length changed", func(params *PasswordHashParams) { params.KeyLength = 64 }},
+	}
+
+	defer SetPasswordHashParams(DefaultPasswordHashParams())
+
+	for _, tt := range testVariants {
+		t.Run(tt.title, func(t *testing.T) {
+			require := require.New(t)
+			params := DefaultPasswordHashParams()
+			tt.params(&params)
+
+			SetPasswordHashParams(params)
+
+			needsRehash, err := PasswordHashNeedsRehash(hashedPassword)
+
+			require.Nil(err)
+			require.True(needsRehash)
+		})
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	require := require.New(t)
+
+	require.Len(HashToken(`token`), 64)
+	require.Equal(HashToken(`token`), HashToken(`token`))
+	require.NotEqual(HashToken(`token`), HashToken(`other-token`))
+}
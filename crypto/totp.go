@@ -0,0 +1,93 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Time-based one-time passwords (RFC 6238) with parameters every common
+// authenticator app supports: HMAC-SHA1, 6 digits, 30 seconds period
+
+const (
+	// Length of TOTP secret in bytes, RFC 4226 recommends 160 bits
+	TOTP_SECRET_LENGTH = 20
+	TOTP_DIGITS        = 6
+	TOTP_PERIOD        = 30 * time.Second
+)
+
+var totpSecretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Returns base32 encoded secret, the form authenticator apps accept
+func GenerateTotpSecret() (string, error) {
+	secret := make([]byte, TOTP_SECRET_LENGTH)
+
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf(
+			"Generate TOTP secret failed with error: %v",
+			err.Error(),
+		)
+	}
+
+	return totpSecretEncoding.EncodeToString(secret), nil
+}
+
+// Number of TOTP period the moment belongs to
+func TotpStep(moment time.Time) uint64 {
+	return uint64(moment.Unix() / int64(TOTP_PERIOD/time.Second))
+}
+
+// Returns code of base32 encoded secret for the step
+func TotpCode(secret string, step uint64) (string, error) {
+	key, err := totpSecretEncoding.DecodeString(
+		strings.ToUpper(strings.TrimRight(secret, "=")),
+	)
+
+	if err != nil {
+		return "", err
+	}
+
+	var message [8]byte
+
+	binary.BigEndian.PutUint64(message[:], step)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	modulus := uint32(1)
+
+	for range TOTP_DIGITS {
+		modulus *= 10
+	}
+
+	return fmt.Sprintf("%0*d", TOTP_DIGITS, value%modulus), nil
+}
+
+// Builds otpauth:// URI authenticator apps import, it is the payload of the
+// QR code shown on enrollment
+func TotpUri(issuer, accountName, secret string) string {
+	uri := url.URL{
+		Scheme: "otpauth",
+		Host:   "totp",
+		Path:   "/" + issuer + ":" + accountName,
+		RawQuery: url.Values{
+			"secret":    {secret},
+			"issuer":    {issuer},
+			"algorithm": {"SHA1"},
+			"digits":    {fmt.Sprint(TOTP_DIGITS)},
+			"period":    {fmt.Sprint(int(TOTP_PERIOD / time.Second))},
+		}.Encode(),
+	}
+
+	return uri.String()
+}
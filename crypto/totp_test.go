@@ -0,0 +1,69 @@
+package crypto
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateTotpSecret(t *testing.T) {
+	require := require.New(t)
+
+	secret, err := GenerateTotpSecret()
+
+	require.Nil(err)
+	require.Len(secret, 32)
+
+	otherSecret, err := GenerateTotpSecret()
+
+	require.Nil(err)
+	require.NotEqual(secret, otherSecret)
+}
+
+func TestTotpCode(t *testing.T) {
+	// RFC 6238 appendix B vectors for SHA1, truncated to 6 digits
+	secret := `GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ`
+	testVariants := []struct {
+		title  string
+		moment int64
+		code   string
+	}{
+		{"Epoch start", 59, `287082`},
+		{"Leading zero", 1111111109, `081804`},
+		{"Several leading zeros", 1234567890, `005924`},
+		{"Far future", 2000000000, `279037`},
+	}
+
+	for _, tt := range testVariants {
+		t.Run(tt.title, func(t *testing.T) {
+			require := require.New(t)
+			code, err := TotpCode(secret, TotpStep(time.Unix(tt.moment, 0)))
+
+			require.Nil(err)
+			require.Equal(tt.code, code)
+		})
+	}
+
+	t.Run("Invalid secret", func(t *testing.T) {
+		_, err := TotpCode(`not base32!`, 1)
+
+		require.NotNil(t, err)
+	})
+}
+
+func TestTotpUri(t *testing.T) {
+	require := require.New(t)
+
+	uri, err := url.Parse(TotpUri(`Finanstar`, `test@example.com`, `SECRET`))
+
+	require.Nil(err)
+	require.Equal(`otpauth`, uri.Scheme)
+	require.Equal(`totp`, uri.Host)
+	require.Equal(`/Finanstar:test@example.com`, uri.Path)
+	require.Equal(`SECRET`, uri.Query().Get(`secret`))
+	require.Equal(`Finanstar`, uri.Query().Get(`issuer`))
+	require.Equal(`6`, uri.Query().Get(`digits`))
+	require.Equal(`30`, uri.Query().Get(`period`))
+}
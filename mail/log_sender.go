@@ -0,0 +1,34 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Writes emails to w instead of sending them, e.g. to stderr or a file, so
+// links they contain can be followed during development
+type LogSender struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewLogSender(w io.Writer) *LogSender {
+	return &LogSender{w: w}
+}
+
+func (self *LogSender) Send(ctx context.Context, message Message) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	_, err := fmt.Fprintf(
+		self.w,
+		"To: %s\nSubject: %s\n\n%s\n\n",
+		message.To,
+		message.Subject,
+		message.Body,
+	)
+
+	return err
+}
@@ -0,0 +1,26 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogSender(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	var output bytes.Buffer
+
+	sender := NewLogSender(&output)
+	err := sender.Send(context.Background(), Message{
+		To:      `test@example.com`,
+		Subject: `Hello`,
+		Body:    `Body`,
+	})
+
+	require.Nil(err)
+	require.Equal("To: test@example.com\nSubject: Hello\n\nBody\n\n", output.String())
+}
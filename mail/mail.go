@@ -0,0 +1,16 @@
+package mail
+
+import "context"
+
+// Outgoing emails. Production deployments plug in a real provider, LogSender
+// is meant for development
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Sender interface {
+	Send(ctx context.Context, message Message) error
+}
@@ -0,0 +1,325 @@
+package migrations
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+
+	utils_pgx "finanstar/server/utils"
+)
+
+// Versioned SQL migrations embedded into the binary. Every version consists
+// of "<version>_<name>.up.sql" and "<version>_<name>.down.sql" files.
+
+const (
+	// Key of transaction level advisory lock preventing concurrent runners
+	MIGRATIONS_ADVISORY_LOCK_KEY = 0x66696e616e
+)
+
+const (
+	INVALID_MIGRATION_FILE_NAME_ERROR = "Invalid migration file name"
+	DUPLICATE_MIGRATION_ERROR         = "Duplicate migration version"
+	INCOMPLETE_MIGRATION_ERROR        = "Migration must have both up and down files"
+	UNKNOWN_APPLIED_MIGRATION_ERROR   = "Database has applied migration unknown to this build"
+)
+
+//go:embed sql/*.sql
+var embedded embed.FS
+
+var migrationFileNameRegexp = regexp.MustCompile(
+	`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`,
+)
+
+type Migration struct {
+	Version uint32
+	Name    string
+	Up      string
+	Down    string
+}
+
+func EmbeddedMigrations() ([]Migration, error) {
+	fsys, err := fs.Sub(embedded, "sql")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return LoadMigrations(fsys)
+}
+
+// Reads migrations from the root of fsys ordered by version
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[uint32]*Migration)
+
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".sql" {
+			continue
+		}
+
+		match := migrationFileNameRegexp.FindStringSubmatch(entry.Name())
+
+		if match == nil {
+			return nil, fmt.Errorf(
+				"%s: %s",
+				INVALID_MIGRATION_FILE_NAME_ERROR,
+				entry.Name(),
+			)
+		}
+
+		version, err := strconv.ParseUint(match[1], 10, 32)
+
+		if err != nil {
+			return nil, fmt.Errorf(
+				"%s: %s",
+				INVALID_MIGRATION_FILE_NAME_ERROR,
+				entry.Name(),
+			)
+		}
+
+		content, err := fs.ReadFile(fsys, entry.Name())
+
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[uint32(version)]
+
+		if !ok {
+			migration = &Migration{Version: uint32(version), Name: match[2]}
+			byVersion[uint32(version)] = migration
+		}
+
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("%s: %d", DUPLICATE_MIGRATION_ERROR, version)
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+
+	for _, migration := range byVersion {
+		if len(migration.Up) == 0 || len(migration.Down) == 0 {
+			return nil, fmt.Errorf(
+				"%s: %d",
+				INCOMPLETE_MIGRATION_ERROR,
+				migration.Version,
+			)
+		}
+
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+func NewMigrator(
+	db utils_pgx.PgxPoolIface,
+	migrations []Migration,
+) *Migrator {
+	return &Migrator{db, migrations}
+}
+
+type Migrator struct {
+	db         utils_pgx.PgxPoolIface
+	migrations []Migration
+}
+
+// Applies all pending migrations in a single transaction, returns applied
+// versions
+func (self *Migrator) Up(ctx context.Context) ([]uint32, error) {
+	applied := make([]uint32, 0)
+
+	err := self.inLockedTx(ctx, func(tx pgx.Tx, versions map[uint32]bool) error {
+		for _, migration := range self.migrations {
+			if versions[migration.Version] {
+				continue
+			}
+
+			if _, err := tx.Exec(ctx, migration.Up); err != nil {
+				return fmt.Errorf(
+					"Migration %d_%s up failed with error: %w",
+					migration.Version,
+					migration.Name,
+					err,
+				)
+			}
+
+			_, err := tx.Exec(
+				ctx,
+				`INSERT INTO schema_migrations (version, name) VALUES ($1, $2);`,
+				migration.Version,
+				migration.Name,
+			)
+
+			if err != nil {
+				return err
+			}
+
+			applied = append(applied, migration.Version)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return applied, nil
+}
+
+// Reverts up to steps latest applied migrations, returns reverted versions
+func (self *Migrator) Down(ctx context.Context, steps int) ([]uint32, error) {
+	reverted := make([]uint32, 0)
+
+	err := self.inLockedTx(ctx, func(tx pgx.Tx, versions map[uint32]bool) error {
+		for index := len(self.migrations) - 1; index >= 0; index-- {
+			if len(reverted) >= steps {
+				break
+			}
+
+			migration := self.migrations[index]
+
+			if !versions[migration.Version] {
+				continue
+			}
+
+			if _, err := tx.Exec(ctx, migration.Down); err != nil {
+				return fmt.Errorf(
+					"Migration %d_%s down failed with error: %w",
+					migration.Version,
+					migration.Name,
+					err,
+				)
+			}
+
+			_, err := tx.Exec(
+				ctx,
+				`DELETE FROM schema_migrations WHERE version = $1;`,
+				migration.Version,
+			)
+
+			if err != nil {
+				return err
+			}
+
+			reverted = append(reverted, migration.Version)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return reverted, nil
+}
+
+func (self *Migrator) inLockedTx(
+	ctx context.Context,
+	fn func(tx pgx.Tx, versions map[uint32]bool) error,
+) error {
+	tx, err := self.db.Begin(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(
+		ctx,
+		`SELECT pg_advisory_xact_lock($1);`,
+		int64(MIGRATIONS_ADVISORY_LOCK_KEY),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		ctx,
+		`
+			CREATE TABLE IF NOT EXISTS schema_migrations (
+				version BIGINT PRIMARY KEY,
+				name TEXT NOT NULL,
+				applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+			);
+		`,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	versions, err := self.appliedVersions(ctx, tx)
+
+	if err != nil {
+		return err
+	}
+
+	if err = fn(tx, versions); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (self *Migrator) appliedVersions(
+	ctx context.Context,
+	tx pgx.Tx,
+) (map[uint32]bool, error) {
+	rows, err := tx.Query(ctx, `SELECT version FROM schema_migrations;`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	appliedVersions, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[uint32]bool, len(self.migrations))
+
+	for _, migration := range self.migrations {
+		known[migration.Version] = true
+	}
+
+	versions := make(map[uint32]bool, len(appliedVersions))
+
+	for _, version := range appliedVersions {
+		if !known[uint32(version)] {
+			return nil, errors.New(UNKNOWN_APPLIED_MIGRATION_ERROR)
+		}
+
+		versions[uint32(version)] = true
+	}
+
+	return versions, nil
+}
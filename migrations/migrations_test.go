@@ -0,0 +1,197 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"testing/fstest"
+
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/require"
+)
+
+var testMigrations = []Migration{
+	{Version: 1, Name: `create_users`, Up: `CREATE TABLE users ();`, Down: `DROP TABLE users;`},
+	{Version: 2, Name: `create_wallets`, Up: `CREATE TABLE wallets ();`, Down: `DROP TABLE wallets;`},
+}
+
+func expectLockedTx(db pgxmock.PgxPoolIface, appliedVersions ...int64) {
+	db.ExpectBegin()
+	db.
+		ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_xact_lock($1);`)).
+		WithArgs(int64(MIGRATIONS_ADVISORY_LOCK_KEY)).
+		WillReturnResult(pgxmock.NewResult(`SELECT`, 1))
+	db.
+		ExpectExec(`CREATE TABLE IF NOT EXISTS schema_migrations`).
+		WillReturnResult(pgxmock.NewResult(`CREATE TABLE`, 0))
+
+	rows := db.NewRows([]string{`version`})
+
+	for _, version := range appliedVersions {
+		rows.AddRow(version)
+	}
+
+	db.
+		ExpectQuery(regexp.QuoteMeta(`SELECT version FROM schema_migrations;`)).
+		WillReturnRows(rows)
+}
+
+func TestLoadMigrations(t *testing.T) {
+	t.Parallel()
+
+	subtests := []struct {
+		name     string
+		fsys     fstest.MapFS
+		versions []uint32
+		error    string
+	}{
+		{
+			name: `LoadsOrderedMigrations`,
+			fsys: fstest.MapFS{
+				`0002_b.up.sql`:   {Data: []byte(`up`)},
+				`0002_b.down.sql`: {Data: []byte(`down`)},
+				`0001_a.up.sql`:   {Data: []byte(`up`)},
+				`0001_a.down.sql`: {Data: []byte(`down`)},
+				`README.md`:       {Data: []byte(`ignored`)},
+			},
+			versions: []uint32{1, 2},
+		},
+		{
+			name: `RejectsMissingDown`,
+			fsys: fstest.MapFS{
+				`0001_a.up.sql`: {Data: []byte(`up`)},
+			},
+			error: INCOMPLETE_MIGRATION_ERROR,
+		},
+		{
+			name: `RejectsDuplicateVersion`,
+			fsys: fstest.MapFS{
+				`0001_a.up.sql`:   {Data: []byte(`up`)},
+				`0001_a.down.sql`: {Data: []byte(`down`)},
+				`0001_b.up.sql`:   {Data: []byte(`up`)},
+			},
+			error: DUPLICATE_MIGRATION_ERROR,
+		},
+		{
+			name: `RejectsInvalidName`,
+			fsys: fstest.MapFS{
+				`create_users.sql`: {Data: []byte(`up`)},
+			},
+			error: INVALID_MIGRATION_FILE_NAME_ERROR,
+		},
+	}
+
+	for _, test := range subtests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+			migrations, err := LoadMigrations(test.fsys)
+
+			if len(test.error) != 0 {
+				require.ErrorContains(err, test.error)
+				return
+			}
+
+			require.Nil(err)
+			require.Len(migrations, len(test.versions))
+
+			for index, version := range test.versions {
+				require.Equal(version, migrations[index].Version)
+			}
+		})
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	migrations, err := EmbeddedMigrations()
+
+	require.Nil(err)
+	require.NotEmpty(migrations)
+	require.Equal(uint32(1), migrations[0].Version)
+}
+
+func TestMigratorUp(t *testing.T) {
+	t.Parallel()
+
+	t.Run(`AppliesPendingMigrations`, func(t *testing.T) {
+		require := require.New(t)
+		db, err := pgxmock.NewPool()
+
+		require.Nil(err)
+		expectLockedTx(db, 1)
+		db.
+			ExpectExec(regexp.QuoteMeta(testMigrations[1].Up)).
+			WillReturnResult(pgxmock.NewResult(`CREATE TABLE`, 0))
+		db.
+			ExpectExec(`INSERT INTO schema_migrations`).
+			WithArgs(uint32(2), `create_wallets`).
+			WillReturnResult(pgxmock.NewResult(`INSERT`, 1))
+		db.ExpectCommit()
+		db.ExpectRollback()
+
+		applied, err := NewMigrator(db, testMigrations).Up(context.Background())
+
+		require.Nil(err)
+		require.Equal([]uint32{2}, applied)
+		require.Nil(db.ExpectationsWereMet())
+	})
+
+	t.Run(`RollsBackOnFailure`, func(t *testing.T) {
+		require := require.New(t)
+		db, err := pgxmock.NewPool()
+
+		require.Nil(err)
+		expectLockedTx(db)
+		db.
+			ExpectExec(regexp.QuoteMeta(testMigrations[0].Up)).
+			WillReturnError(errors.New(`syntax error`))
+		db.ExpectRollback()
+
+		applied, err := NewMigrator(db, testMigrations).Up(context.Background())
+
+		require.Nil(applied)
+		require.ErrorContains(err, `syntax error`)
+		require.Nil(db.ExpectationsWereMet())
+	})
+
+	t.Run(`RejectsUnknownAppliedVersion`, func(t *testing.T) {
+		require := require.New(t)
+		db, err := pgxmock.NewPool()
+
+		require.Nil(err)
+		expectLockedTx(db, 1, 3)
+		db.ExpectRollback()
+
+		_, err = NewMigrator(db, testMigrations).Up(context.Background())
+
+		require.EqualError(err, UNKNOWN_APPLIED_MIGRATION_ERROR)
+		require.Nil(db.ExpectationsWereMet())
+	})
+}
+
+func TestMigratorDown(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	db, err := pgxmock.NewPool()
+
+	require.Nil(err)
+	expectLockedTx(db, 1, 2)
+	db.
+		ExpectExec(regexp.QuoteMeta(testMigrations[1].Down)).
+		WillReturnResult(pgxmock.NewResult(`DROP TABLE`, 0))
+	db.
+		ExpectExec(`DELETE FROM schema_migrations`).
+		WithArgs(uint32(2)).
+		WillReturnResult(pgxmock.NewResult(`DELETE`, 1))
+	db.ExpectCommit()
+	db.ExpectRollback()
+
+	reverted, err := NewMigrator(db, testMigrations).Down(context.Background(), 1)
+
+	require.Nil(err)
+	require.Equal([]uint32{2}, reverted)
+	require.Nil(db.ExpectationsWereMet())
+}
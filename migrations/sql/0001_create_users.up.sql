@@ -0,0 +1,6 @@
+CREATE TABLE users (
+	id INTEGER GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+	login TEXT NOT NULL,
+	password TEXT NOT NULL,
+	CONSTRAINT users_login_key UNIQUE (login)
+);
@@ -0,0 +1,14 @@
+CREATE TABLE sessions (
+	id TEXT PRIMARY KEY,
+	handle TEXT NOT NULL,
+	user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	ip TEXT NOT NULL,
+	user_agent TEXT NOT NULL,
+	device TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	last_seen_at TIMESTAMPTZ NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX sessions_user_id_idx ON sessions (user_id);
+CREATE INDEX sessions_expires_at_idx ON sessions (expires_at);
@@ -0,0 +1,4 @@
+DROP TABLE user_recovery_codes;
+DROP TABLE user_totp;
+
+ALTER TABLE sessions DROP COLUMN pending_second_factor;
@@ -0,0 +1,18 @@
+ALTER TABLE sessions
+	ADD COLUMN pending_second_factor BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE user_totp (
+	user_id INTEGER PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+	secret TEXT NOT NULL,
+	-- Unconfirmed secret doesn't protect sign in yet
+	confirmed_at TIMESTAMPTZ,
+	-- Codes of this and earlier steps are rejected as replayed
+	last_used_step BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE user_recovery_codes (
+	user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	code_hash TEXT NOT NULL,
+	used_at TIMESTAMPTZ,
+	PRIMARY KEY (user_id, code_hash)
+);
@@ -0,0 +1,8 @@
+CREATE TABLE password_reset_tokens (
+	-- Tokens are stored hashed, so leaked table doesn't let anyone reset
+	token_hash TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX password_reset_tokens_user_id_idx ON password_reset_tokens (user_id);
@@ -0,0 +1,14 @@
+-- Existing accounts start unverified as well
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMPTZ;
+
+CREATE TABLE email_verification_tokens (
+	token_hash TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	-- Login the token was emailed to, token is void once user changes it
+	login TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX email_verification_tokens_user_id_idx
+	ON email_verification_tokens (user_id, created_at);
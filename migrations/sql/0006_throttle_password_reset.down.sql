@@ -0,0 +1,4 @@
+DROP INDEX password_reset_tokens_user_id_idx;
+CREATE INDEX password_reset_tokens_user_id_idx ON password_reset_tokens (user_id);
+
+ALTER TABLE password_reset_tokens DROP COLUMN created_at;
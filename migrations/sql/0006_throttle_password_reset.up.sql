@@ -0,0 +1,7 @@
+-- Reset emails to the same user are throttled by the latest token
+ALTER TABLE password_reset_tokens ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE password_reset_tokens ALTER COLUMN created_at DROP DEFAULT;
+
+DROP INDEX password_reset_tokens_user_id_idx;
+CREATE INDEX password_reset_tokens_user_id_idx
+	ON password_reset_tokens (user_id, created_at);
@@ -0,0 +1,8 @@
+-- Accounts predating email verification are treated as verified, otherwise
+-- enabling a restrictive policy would lock every one of them out. They are
+-- the ones never emailed a token, as sign up and login change always are
+UPDATE users SET email_verified_at = now()
+WHERE email_verified_at IS NULL
+	AND NOT EXISTS (
+		SELECT 1 FROM email_verification_tokens WHERE user_id = users.id
+	);
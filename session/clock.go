@@ -0,0 +1,44 @@
+package session
+
+import (
+	"sync"
+	"time"
+)
+
+// Source of current time, lets tests control session expiry
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Clock backed by time.Now
+var SystemClock Clock = systemClock{}
+
+// Clock that stands still until it is advanced, safe for concurrent use
+type ManualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+func (self *ManualClock) Now() time.Time {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	return self.now
+}
+
+func (self *ManualClock) Advance(duration time.Duration) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	self.now = self.now.Add(duration)
+}
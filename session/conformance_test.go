@@ -0,0 +1,58 @@
+package session_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"finanstar/server/session"
+	"finanstar/server/session/sessiontest"
+)
+
+func TestDragonflySessionManagerConformance(t *testing.T) {
+	t.Parallel()
+
+	sessiontest.Run(t, func(t *testing.T, options sessiontest.Options) sessiontest.Harness {
+		server := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+
+		t.Cleanup(func() { client.Close() })
+		server.SetTime(options.Clock.Now())
+
+		return sessiontest.Harness{
+			Manager: session.NewDragonflySessionManager(
+				client,
+				&session.DragonflySessionManagerOptions{
+					ExpirationOptions: options.ExpirationOptions,
+					LimitOptions:      options.LimitOptions,
+					Clock:             options.Clock,
+					Observer:          options.Observer,
+				},
+			),
+			Advance: func(duration time.Duration) {
+				options.Clock.Advance(duration)
+				server.SetTime(options.Clock.Now())
+				server.FastForward(duration)
+			},
+		}
+	})
+}
+
+func TestMemorySessionManagerConformance(t *testing.T) {
+	t.Parallel()
+
+	sessiontest.Run(t, func(t *testing.T, options sessiontest.Options) sessiontest.Harness {
+		return sessiontest.Harness{
+			Manager: session.NewMemorySessionManager(
+				&session.MemorySessionManagerOptions{
+					ExpirationOptions: options.ExpirationOptions,
+					LimitOptions:      options.LimitOptions,
+					Clock:             options.Clock,
+					Observer:          options.Observer,
+				},
+			),
+		}
+	})
+}
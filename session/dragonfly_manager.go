@@ -3,22 +3,90 @@ package session
 import (
 	"context"
 	"errors"
-	"finanstar/server/crypto"
+	"finanstar/server/apperror"
 	"fmt"
+	"log"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
-// Implementation of session manager using Dragonfly DB
+// Implementation of session manager using Dragonfly DB. Every session is a
+// hash expiring with the session. Sessions of a user are indexed by sorted
+// set scored by session expiry in unix milliseconds, the set expires with its
+// newest session and janitor prunes members of expired sessions from it.
+// Create, delete and reset are atomic Lua scripts, see dragonfly_scripts.go
 
 const (
-	KNOWN_SESSIONS_SET_KEY_PREFIX = "known-sessions-set"
-	SESSION_KEY_PREFIX            = "session"
+	// Sorted sets, unrelated to plain sets of former
+	// LEGACY_KNOWN_SESSIONS_SET_KEY_PREFIX
+	KNOWN_SESSIONS_SET_KEY_PREFIX = "known-sessions"
+	// Plain sets without expiry indexing sessions of former string format,
+	// see MigrateLegacySessions
+	LEGACY_KNOWN_SESSIONS_SET_KEY_PREFIX = "known-sessions-set"
+	SESSION_KEY_PREFIX                   = "session"
 )
 
-func NewDragonflySessionManager(client *redis.Client) *DragonflySessionManager {
-	return &DragonflySessionManager{client}
+const (
+	SESSION_JANITOR_INTERVAL = time.Hour
+	// How many keys janitor requests per SCAN call
+	SESSION_JANITOR_SCAN_COUNT = 100
+)
+
+// Fields of session hash
+const (
+	SESSION_USER_ID_FIELD      = "user_id"
+	SESSION_CREATED_AT_FIELD   = "created_at"
+	SESSION_LAST_SEEN_AT_FIELD = "last_seen_at"
+	SESSION_IP_FIELD           = "ip"
+	SESSION_USER_AGENT_FIELD   = "user_agent"
+	SESSION_DEVICE_FIELD       = "device"
+	// Absent in sessions created before second factor was introduced
+	SESSION_PENDING_SECOND_FACTOR_FIELD = "pending_second_factor"
+)
+
+type DragonflySessionManagerOptions struct {
+	ExpirationOptions
+	LimitOptions
+	// Defaults to SystemClock, must agree with storage time as TTLs are
+	// computed from it
+	Clock Clock
+	// Defaults to NopSessionObserver
+	Observer SessionObserver
+	// How often RunJanitor prunes known sessions sets
+	JanitorInterval time.Duration
+}
+
+func NewDragonflySessionManager(
+	client *redis.Client,
+	options *DragonflySessionManagerOptions,
+) *DragonflySessionManager {
+	dsm := &DragonflySessionManager{client: client}
+
+	if options != nil {
+		dsm.options = *options
+	}
+
+	dsm.options.ExpirationOptions = dsm.options.ExpirationOptions.WithDefaults()
+	dsm.options.LimitOptions = dsm.options.LimitOptions.WithDefaults()
+
+	if dsm.options.Clock == nil {
+		dsm.options.Clock = SystemClock
+	}
+
+	if dsm.options.Observer == nil {
+		dsm.options.Observer = NopSessionObserver{}
+	}
+
+	if dsm.options.JanitorInterval <= 0 {
+		dsm.options.JanitorInterval = SESSION_JANITOR_INTERVAL
+	}
+
+	return dsm
 }
 
 type CreateDragonflyClientOptions struct {
@@ -47,184 +115,714 @@ func CreateDragonflyClient(
 }
 
 type DragonflySessionManager struct {
-	client *redis.Client
+	client  *redis.Client
+	options DragonflySessionManagerOptions
 }
 
 func (dsm *DragonflySessionManager) CreateSession(
 	ctx context.Context,
 	sData *SessionData,
 ) (string, error) {
-	// Ensuring that sId will saved only if it is unique
-	for {
-		sId, err := crypto.GenerateSecureId(SESSION_ID_LENGTH)
+	now := dsm.options.Clock.Now()
+	fields := encodeSessionFields(&SessionData{
+		UserId:              sData.UserId,
+		ClientInfo:          sData.ClientInfo,
+		PendingSecondFactor: sData.PendingSecondFactor,
+		CreatedAt:           now,
+		LastSeenAt:          now,
+	})
+	expiresAt := dsm.options.sessionExpiresAt(sData.PendingSecondFactor, now, now)
+	setKey := knownSessionsSetKey(sData.UserId)
+	// Leading sId is replaced on every attempt
+	args := []any{
+		"",
+		encodeTime(now),
+		encodeTime(expiresAt),
+		dsm.options.MaxSessions,
+		dsm.options.LimitPolicy,
+		SESSION_KEY_PREFIX,
+		SESSION_LAST_SEEN_AT_FIELD,
+		SESSION_PENDING_SECOND_FACTOR_FIELD,
+		strconv.FormatBool(sData.PendingSecondFactor),
+	}
 
-		if err != nil {
-			return "", err
-		}
+	for field, value := range fields {
+		args = append(args, field, value)
+	}
 
-		tx := dsm.client.TxPipeline()
+	var evictedSIds []string
 
-		setCmd := tx.SetNX(
+	// Session is indexed in the set only if sId is unique
+	sId, err := createWithUniqueId(ctx, func(sId string) (bool, error) {
+		args[0] = sId
+		result, err := createSessionScript.Run(
 			ctx,
-			fmt.Sprintf("%s:%s", SESSION_KEY_PREFIX, sId),
-			sData.UserId,
-			SESSION_TTL,
-		)
-		tx.SAdd(
-			ctx,
-			fmt.Sprintf("%s:%d", KNOWN_SESSIONS_SET_KEY_PREFIX, sData.UserId),
-			sId,
-		)
-
-		_, err = tx.Exec(ctx)
+			dsm.client,
+			[]string{sessionKey(sId), setKey},
+			args...,
+		).Slice()
 
 		if err != nil {
-			return "", err
+			return false, err
 		}
 
-		setCmdSuccessful, err := setCmd.Result()
+		switch status, _ := result[0].(int64); status {
+		case -1:
+			return false, ErrSessionLimitReached
+		case 0:
+			return false, nil
+		}
 
-		if setCmdSuccessful {
-			return sId, nil
+		for _, evictedSId := range result[1:] {
+			evictedSIds = append(evictedSIds, evictedSId.(string))
 		}
+
+		return true, nil
+	})
+
+	if err != nil {
+		return "", err
 	}
+
+	for _, evictedSId := range evictedSIds {
+		dsm.options.Observer.OnDeleted(ctx, sData.UserId, SessionHandle(evictedSId))
+	}
+
+	dsm.options.Observer.OnCreated(ctx, &SessionData{
+		UserId:              sData.UserId,
+		Handle:              SessionHandle(sId),
+		ClientInfo:          sData.ClientInfo,
+		PendingSecondFactor: sData.PendingSecondFactor,
+		CreatedAt:           now,
+		LastSeenAt:          now,
+		ExpiresAt:           expiresAt,
+	})
+
+	return sId, nil
 }
 
 func (dsm *DragonflySessionManager) DeleteSession(
 	ctx context.Context,
 	sId string,
 ) error {
-	sessionKey := fmt.Sprintf("%s:%s", SESSION_KEY_PREFIX, sId)
-	userId, err := dsm.client.Get(
-		ctx,
-		sessionKey,
-	).Result()
+	err := dsm.deleteSession(ctx, sId)
 
 	if err == redis.Nil {
-		return errors.New(SESSION_NOT_FOUND_ERROR)
+		return apperror.Wrap(ErrSessionNotFound, err)
 	}
 
-	if err != nil {
-		return err
-	}
+	return err
+}
 
-	knownSessionsSetKey := fmt.Sprintf(
-		"%s:%s",
+// Returns redis.Nil if there is no such session
+func (dsm *DragonflySessionManager) deleteSession(
+	ctx context.Context,
+	sId string,
+) error {
+	rawUserId, err := deleteSessionScript.Run(
+		ctx,
+		dsm.client,
+		[]string{sessionKey(sId)},
+		sId,
+		SESSION_USER_ID_FIELD,
 		KNOWN_SESSIONS_SET_KEY_PREFIX,
-		userId,
-	)
-	pipe := dsm.client.TxPipeline()
+	).Text()
 
-	pipe.Del(ctx, sessionKey)
-	pipe.SRem(ctx, knownSessionsSetKey, sId)
+	if isLegacySession(err) {
+		converted, err := dsm.convertLegacySession(ctx, sId)
 
-	_, err = pipe.Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		if !converted {
+			return redis.Nil
+		}
+
+		return dsm.deleteSession(ctx, sId)
+	}
 
 	if err != nil {
 		return err
 	}
 
-	// #region Prevent memory leak in storage
-	sIds, err := dsm.client.SMembers(ctx, knownSessionsSetKey).Result()
+	userId, err := decodeUserId(rawUserId)
 
 	if err != nil {
 		return err
 	}
 
-	if len(sIds) == 0 {
-		err = dsm.client.Del(ctx, knownSessionsSetKey).Err()
-
-		if err != nil {
-			return err
-		}
-	}
-	// #endregion
+	dsm.options.Observer.OnDeleted(ctx, userId, SessionHandle(sId))
 
 	return nil
 }
 
+// Extends session by idle timeout, but not past its lifetime, and marks it
+// as seen now. Pending session isn't extended past PENDING_SESSION_TIMEOUT.
+// Fails with ErrSessionRenewalConflict when the session keeps changing for
+// SESSION_RENEWAL_MAX_ATTEMPTS tries
 func (dsm *DragonflySessionManager) RenewalSession(
 	ctx context.Context,
 	sId string,
 ) error {
-	success, err := dsm.client.Expire(
-		ctx,
-		fmt.Sprintf("%s:%s", SESSION_KEY_PREFIX, sId),
-		SESSION_TTL,
-	).Result()
+	key := sessionKey(sId)
+	// Owner of renewed session, reported to observer
+	var userId uint32
+
+	renewal := func(tx *redis.Tx) error {
+		values, err := tx.HMGet(
+			ctx,
+			key,
+			SESSION_USER_ID_FIELD,
+			SESSION_CREATED_AT_FIELD,
+			SESSION_PENDING_SECOND_FACTOR_FIELD,
+		).Result()
+
+		if isLegacySession(err) {
+			return errLegacySession
+		}
+
+		if err != nil {
+			return err
+		}
+
+		rawUserId, _ := values[0].(string)
+		rawCreatedAt, _ := values[1].(string)
+		pending := values[2] == "true"
+
+		if values[0] == nil && values[1] == nil {
+			return ErrSessionNotFound
+		}
+
+		userId, err = decodeUserId(rawUserId)
+
+		if err != nil {
+			return err
+		}
+
+		createdAt, err := decodeTime(rawCreatedAt)
+
+		if err != nil {
+			return err
+		}
+
+		now := dsm.options.Clock.Now()
+		expiresAt := dsm.options.sessionExpiresAt(pending, createdAt, now)
+
+		if !expiresAt.After(now) {
+			return ErrSessionNotFound
+		}
+
+		setKey := knownSessionsSetKey(userId)
+
+		if err = tx.Watch(ctx, setKey).Err(); err != nil {
+			return err
+		}
+
+		setExpiresAt, err := newestExpiresAt(ctx, tx, setKey, expiresAt)
+
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, key, SESSION_LAST_SEEN_AT_FIELD, encodeTime(now))
+			pipe.PExpire(ctx, key, expiresAt.Sub(now))
+			pipe.ZAdd(ctx, setKey, knownSession(sId, expiresAt))
+			pipe.PExpire(ctx, setKey, setExpiresAt.Sub(now))
+
+			return nil
+		})
 
-	if err != nil {
 		return err
 	}
 
-	if !success {
-		return errors.New(SESSION_NOT_FOUND_ERROR)
+	// Session was changed concurrently, e.g. renewed by another request
+	for range SESSION_RENEWAL_MAX_ATTEMPTS {
+		err := dsm.client.Watch(ctx, renewal, key)
+
+		if err == redis.TxFailedErr {
+			continue
+		}
+
+		if err == errLegacySession {
+			converted, err := dsm.convertLegacySession(ctx, sId)
+
+			if err != nil {
+				return err
+			}
+
+			if !converted {
+				return ErrSessionNotFound
+			}
+
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		dsm.options.Observer.OnRenewed(ctx, userId, SessionHandle(sId))
+
+		return nil
 	}
 
-	return nil
+	return apperror.Wrap(ErrSessionRenewalConflict, redis.TxFailedErr)
 }
 
 func (dsm *DragonflySessionManager) GetSessionData(
 	ctx context.Context,
 	sId string,
 ) (*SessionData, error) {
-	userId, err := dsm.client.Get(
-		ctx,
-		fmt.Sprintf("%s:%s", SESSION_KEY_PREFIX, sId),
-	).Result()
+	sessions, err := dsm.readSessions(ctx, []string{sId})
 
-	if err == redis.Nil {
-		return nil, errors.New(SESSION_NOT_FOUND_ERROR)
+	if err != nil {
+		return nil, err
 	}
 
+	if len(sessions) == 0 {
+		return nil, ErrSessionNotFound
+	}
+
+	return &sessions[0], nil
+}
+
+func (dsm *DragonflySessionManager) ListSessions(
+	ctx context.Context,
+	userId uint32,
+) ([]SessionData, error) {
+	sIds, err := dsm.aliveKnownSessions(ctx, userId)
+
 	if err != nil {
 		return nil, err
 	}
 
-	userIdConverted, err := strconv.ParseInt(userId, 10, 32)
+	sessions, err := dsm.readSessions(ctx, sIds)
 
 	if err != nil {
-		return nil, errors.New(SESSION_DATA_INVALID_ERROR)
+		return nil, err
 	}
 
-	return &SessionData{UserId: uint32(userIdConverted)}, nil
+	sessions = slices.DeleteFunc(sessions, func(sData SessionData) bool {
+		return sData.PendingSecondFactor
+	})
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+
+	return sessions, nil
 }
 
-func (dsm *DragonflySessionManager) ResetSessions(
+// Reads sessions in a single round-trip, skipping expired ones
+func (dsm *DragonflySessionManager) readSessions(
 	ctx context.Context,
-	userId uint32,
-) error {
-	knownSessionsSetKey := fmt.Sprintf(
-		"%s:%d",
+	sIds []string,
+) ([]SessionData, error) {
+	if len(sIds) == 0 {
+		return []SessionData{}, nil
+	}
+
+	pipe := dsm.client.Pipeline()
+	hGetAllCmds := make([]*redis.MapStringStringCmd, len(sIds))
+	pttlCmds := make([]*redis.DurationCmd, len(sIds))
+
+	for index, sId := range sIds {
+		hGetAllCmds[index] = pipe.HGetAll(ctx, sessionKey(sId))
+		pttlCmds[index] = pipe.PTTL(ctx, sessionKey(sId))
+	}
+
+	_, err := pipe.Exec(ctx)
+
+	if err != nil && !isLegacySession(err) {
+		return nil, err
+	}
+
+	now := dsm.options.Clock.Now()
+	sessions := make([]SessionData, 0, len(sIds))
+	convertedSIds := []string{}
+
+	for index := range sIds {
+		if err := hGetAllCmds[index].Err(); isLegacySession(err) {
+			converted, err := dsm.convertLegacySession(ctx, sIds[index])
+
+			if err != nil {
+				return nil, err
+			}
+
+			if converted {
+				convertedSIds = append(convertedSIds, sIds[index])
+			}
+
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		fields := hGetAllCmds[index].Val()
+
+		if len(fields) == 0 {
+			continue
+		}
+
+		sData, err := decodeSessionFields(fields)
+
+		if err != nil {
+			return nil, err
+		}
+
+		sData.Handle = SessionHandle(sIds[index])
+		sData.ExpiresAt = now.Add(pttlCmds[index].Val())
+		sessions = append(sessions, *sData)
+	}
+
+	// Converted sessions are hashes now, so they are read at most once more
+	convertedSessions, err := dsm.readSessions(ctx, convertedSIds)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append(sessions, convertedSessions...), nil
+}
+
+// Sessions stored before they became hashes are plain strings holding user
+// id, hash commands fail on them with WRONGTYPE. Script errors wrap the
+// reply, so it is looked up anywhere in the message
+func isLegacySession(err error) bool {
+	var redisErr redis.Error
+
+	return errors.As(err, &redisErr) &&
+		strings.Contains(redisErr.Error(), "WRONGTYPE")
+}
+
+// Returned by renewal transaction when session has former string format
+var errLegacySession = errors.New("Session has former string format")
+
+// Converts session of former string format into hash on its first access, so
+// its owner stays signed in. Reports false when there was nothing to convert
+// or the session was invalid
+func (dsm *DragonflySessionManager) convertLegacySession(
+	ctx context.Context,
+	sId string,
+) (bool, error) {
+	now := dsm.options.Clock.Now()
+	fields := encodeSessionFields(&SessionData{CreatedAt: now, LastSeenAt: now})
+	args := []any{
+		sId,
+		now.UnixMilli(),
+		dsm.options.IdleTimeout.Milliseconds(),
 		KNOWN_SESSIONS_SET_KEY_PREFIX,
-		userId,
-	)
-	sIds, err := dsm.client.SMembers(
+		SESSION_USER_ID_FIELD,
+	}
+
+	delete(fields, SESSION_USER_ID_FIELD)
+
+	for field, value := range fields {
+		args = append(args, field, value)
+	}
+
+	converted, err := convertLegacySessionScript.Run(
 		ctx,
-		knownSessionsSetKey,
-	).Result()
+		dsm.client,
+		[]string{sessionKey(sId)},
+		args...,
+	).Int()
 
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	pipe := dsm.client.TxPipeline()
-	delSessionsCmd := pipe.Del(ctx, sIds...)
-	delKnownSessionsSetCmd := pipe.Del(ctx, knownSessionsSetKey)
+	return converted == 1, nil
+}
 
-	_, execErr := pipe.Exec(ctx)
+func (dsm *DragonflySessionManager) RevokeSession(
+	ctx context.Context,
+	userId uint32,
+	handle string,
+) error {
+	sIds, err := dsm.aliveKnownSessions(ctx, userId)
 
-	if execErr != nil {
-		return execErr
+	if err != nil {
+		return err
 	}
 
-	if err = delSessionsCmd.Err(); err != nil {
+	for _, sId := range sIds {
+		if SessionHandle(sId) != handle {
+			continue
+		}
+
+		err := dsm.deleteSession(ctx, sId)
+
+		// Session has expired after it was listed
+		if err == redis.Nil {
+			return apperror.Wrap(ErrSessionHandleNotFound, err)
+		}
+
 		return err
 	}
 
-	if err = delKnownSessionsSetCmd.Err(); err != nil {
+	return ErrSessionHandleNotFound
+}
+
+func (dsm *DragonflySessionManager) ResetOtherSessions(
+	ctx context.Context,
+	userId uint32,
+	keptSId string,
+) error {
+	return dsm.resetKnownSessions(ctx, userId, keptSId)
+}
+
+func (dsm *DragonflySessionManager) ResetSessions(
+	ctx context.Context,
+	userId uint32,
+) error {
+	return dsm.resetKnownSessions(ctx, userId, "")
+}
+
+// Deletes every session of the user except keptSId
+func (dsm *DragonflySessionManager) resetKnownSessions(
+	ctx context.Context,
+	userId uint32,
+	keptSId string,
+) error {
+	err := resetSessionsScript.Run(
+		ctx,
+		dsm.client,
+		[]string{knownSessionsSetKey(userId)},
+		SESSION_KEY_PREFIX,
+		keptSId,
+	).Err()
+
+	if err != nil {
 		return err
 	}
 
+	dsm.options.Observer.OnReset(ctx, userId, keptSessionHandle(keptSId))
+
 	return nil
 }
+
+// Removes members of expired sessions from every known sessions set,
+// returns how many were removed
+func (dsm *DragonflySessionManager) PruneKnownSessions(
+	ctx context.Context,
+) (int64, error) {
+	maxScore := strconv.FormatInt(dsm.options.Clock.Now().UnixMilli(), 10)
+	iterator := dsm.client.Scan(
+		ctx,
+		0,
+		KNOWN_SESSIONS_SET_KEY_PREFIX+":*",
+		SESSION_JANITOR_SCAN_COUNT,
+	).Iterator()
+	pruned := int64(0)
+
+	for iterator.Next(ctx) {
+		removed, err := dsm.client.ZRemRangeByScore(
+			ctx,
+			iterator.Val(),
+			"-inf",
+			maxScore,
+		).Result()
+
+		if err != nil {
+			return pruned, err
+		}
+
+		pruned += removed
+	}
+
+	return pruned, iterator.Err()
+}
+
+// Converts sessions indexed by legacy plain sets and deletes the sets, which
+// never expire and aren't pruned otherwise. Returns how many sessions were
+// converted, expired ones are skipped
+func (dsm *DragonflySessionManager) MigrateLegacySessions(
+	ctx context.Context,
+) (int64, error) {
+	iterator := dsm.client.Scan(
+		ctx,
+		0,
+		LEGACY_KNOWN_SESSIONS_SET_KEY_PREFIX+":*",
+		SESSION_JANITOR_SCAN_COUNT,
+	).Iterator()
+	migrated := int64(0)
+
+	for iterator.Next(ctx) {
+		setKey := iterator.Val()
+		sIds, err := dsm.client.SMembers(ctx, setKey).Result()
+
+		if err != nil {
+			return migrated, err
+		}
+
+		for _, sId := range sIds {
+			converted, err := dsm.convertLegacySession(ctx, sId)
+
+			if err != nil {
+				return migrated, err
+			}
+
+			if converted {
+				migrated++
+			}
+		}
+
+		if err = dsm.client.Del(ctx, setKey).Err(); err != nil {
+			return migrated, err
+		}
+	}
+
+	return migrated, iterator.Err()
+}
+
+// Migrates legacy sessions once and prunes known sessions sets every
+// JanitorInterval until ctx is done
+func (dsm *DragonflySessionManager) RunJanitor(ctx context.Context) {
+	migrated, err := dsm.MigrateLegacySessions(ctx)
+
+	if err != nil {
+		log.Printf("Failed to migrate legacy sessions: %v", err)
+	} else if migrated != 0 {
+		log.Printf("Migrated %d legacy sessions", migrated)
+	}
+
+	ticker := time.NewTicker(dsm.options.JanitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := dsm.PruneKnownSessions(ctx); err != nil {
+				log.Printf("Failed to prune known sessions: %v", err)
+			}
+		}
+	}
+}
+
+// Returns sIds of the user sessions which haven't expired yet
+func (dsm *DragonflySessionManager) aliveKnownSessions(
+	ctx context.Context,
+	userId uint32,
+) ([]string, error) {
+	return dsm.client.ZRangeByScore(
+		ctx,
+		knownSessionsSetKey(userId),
+		&redis.ZRangeBy{
+			Min: "(" + strconv.FormatInt(dsm.options.Clock.Now().UnixMilli(), 10),
+			Max: "+inf",
+		},
+	).Result()
+}
+
+// Latest of expiresAt and expiry of the newest session in the set, i.e. when
+// the set has to expire
+func newestExpiresAt(
+	ctx context.Context,
+	tx *redis.Tx,
+	setKey string,
+	expiresAt time.Time,
+) (time.Time, error) {
+	newest, err := tx.ZRevRangeWithScores(ctx, setKey, 0, 0).Result()
+
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if len(newest) != 0 {
+		newestExpiresAt := time.UnixMilli(int64(newest[0].Score))
+
+		if newestExpiresAt.After(expiresAt) {
+			return newestExpiresAt, nil
+		}
+	}
+
+	return expiresAt, nil
+}
+
+func knownSession(sId string, expiresAt time.Time) redis.Z {
+	return redis.Z{Score: float64(expiresAt.UnixMilli()), Member: sId}
+}
+
+func sessionKey(sId string) string {
+	return fmt.Sprintf("%s:%s", SESSION_KEY_PREFIX, sId)
+}
+
+func knownSessionsSetKey(userId uint32) string {
+	return fmt.Sprintf("%s:%d", KNOWN_SESSIONS_SET_KEY_PREFIX, userId)
+}
+
+func encodeSessionFields(sData *SessionData) map[string]any {
+	return map[string]any{
+		SESSION_USER_ID_FIELD:      sData.UserId,
+		SESSION_CREATED_AT_FIELD:   encodeTime(sData.CreatedAt),
+		SESSION_LAST_SEEN_AT_FIELD: encodeTime(sData.LastSeenAt),
+		SESSION_IP_FIELD:           sData.Ip,
+		SESSION_USER_AGENT_FIELD:   sData.UserAgent,
+		SESSION_DEVICE_FIELD:       sData.Device,
+		SESSION_PENDING_SECOND_FACTOR_FIELD: strconv.FormatBool(
+			sData.PendingSecondFactor,
+		),
+	}
+}
+
+func decodeSessionFields(fields map[string]string) (*SessionData, error) {
+	userId, err := decodeUserId(fields[SESSION_USER_ID_FIELD])
+
+	if err != nil {
+		return nil, err
+	}
+
+	createdAt, err := decodeTime(fields[SESSION_CREATED_AT_FIELD])
+
+	if err != nil {
+		return nil, err
+	}
+
+	lastSeenAt, err := decodeTime(fields[SESSION_LAST_SEEN_AT_FIELD])
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &SessionData{
+		UserId: userId,
+		ClientInfo: ClientInfo{
+			Ip:        fields[SESSION_IP_FIELD],
+			UserAgent: fields[SESSION_USER_AGENT_FIELD],
+			Device:    fields[SESSION_DEVICE_FIELD],
+		},
+		PendingSecondFactor: fields[SESSION_PENDING_SECOND_FACTOR_FIELD] == "true",
+		CreatedAt:           createdAt,
+		LastSeenAt:          lastSeenAt,
+	}, nil
+}
+
+func decodeUserId(value string) (uint32, error) {
+	userId, err := strconv.ParseUint(value, 10, 32)
+
+	if err != nil {
+		return 0, apperror.Wrap(ErrSessionDataInvalid, err)
+	}
+
+	return uint32(userId), nil
+}
+
+// Times are stored as unix milliseconds
+func encodeTime(value time.Time) string {
+	return strconv.FormatInt(value.UnixMilli(), 10)
+}
+
+func decodeTime(value string) (time.Time, error) {
+	milliseconds, err := strconv.ParseInt(value, 10, 64)
+
+	if err != nil {
+		return time.Time{}, apperror.Wrap(ErrSessionDataInvalid, err)
+	}
+
+	return time.UnixMilli(milliseconds), nil
+}
@@ -0,0 +1,35 @@
+package session
+
+import (
+	_ "embed"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Lua scripts making create, delete and reset single atomic round-trips, and
+// converting sessions of former string format.
+// Scripts are run with EVALSHA and loaded on the first NOSCRIPT reply.
+//
+// Create script touches hashes of the user's other sessions to count and
+// evict them, delete and reset scripts access keys derived from stored data.
+// None of these keys can be declared upfront, so Dragonfly has to run with
+// --default_lua_flags=allow-undeclared-keys
+
+var (
+	//go:embed lua/create_session.lua
+	createSessionSource string
+	//go:embed lua/delete_session.lua
+	deleteSessionSource string
+	//go:embed lua/reset_sessions.lua
+	resetSessionsSource string
+	//go:embed lua/convert_legacy_session.lua
+	convertLegacySessionSource string
+)
+
+var (
+	createSessionScript = redis.NewScript(createSessionSource)
+	deleteSessionScript = redis.NewScript(deleteSessionSource)
+	resetSessionsScript = redis.NewScript(resetSessionsSource)
+
+	convertLegacySessionScript = redis.NewScript(convertLegacySessionSource)
+)
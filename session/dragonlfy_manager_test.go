@@ -2,287 +2,582 @@ package session
 
 import (
 	"context"
-	"errors"
-	"finanstar/server/crypto"
-	"fmt"
 	"strconv"
+	"sync"
 	"testing"
+	"time"
 
-	"github.com/go-redis/redismock/v9"
+	"github.com/alicebob/miniredis/v2"
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/require"
 )
 
-func checkMockExpectationsWereMet(t *testing.T, mock redismock.ClientMock) {
-	if err := mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("Mock expectations were not met, error = %s", err.Error())
+type testDragonfly struct {
+	dsm    *DragonflySessionManager
+	server *miniredis.Miniredis
+	clock  *ManualClock
+}
+
+// Manager backed by in-process Redis stand-in, whose time follows the clock
+func newTestDragonfly(t *testing.T) *testDragonfly {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	clock := NewManualClock(time.UnixMilli(time.Now().UnixMilli()))
+
+	t.Cleanup(func() { client.Close() })
+	server.SetTime(clock.Now())
+
+	return &testDragonfly{
+		dsm: NewDragonflySessionManager(client, &DragonflySessionManagerOptions{
+			ExpirationOptions: ExpirationOptions{
+				Lifetime:    30 * 24 * time.Hour,
+				IdleTimeout: 24 * time.Hour,
+			},
+			Clock: clock,
+		}),
+		server: server,
+		clock:  clock,
+	}
+}
+
+func (self *testDragonfly) advance(duration time.Duration) {
+	self.clock.Advance(duration)
+	self.server.SetTime(self.clock.Now())
+	self.server.FastForward(duration)
+}
+
+func (self *testDragonfly) create(t *testing.T, userId uint32) string {
+	sId, err := self.dsm.CreateSession(
+		context.Background(),
+		&SessionData{UserId: userId},
+	)
+
+	require.Nil(t, err)
+
+	return sId
+}
+
+// Members of the user known sessions set ordered by expiry
+func (self *testDragonfly) knownSessions(t *testing.T, userId uint32) []string {
+	key := knownSessionsSetKey(userId)
+
+	if !self.server.Exists(key) {
+		return []string{}
 	}
+
+	sIds, err := self.server.ZMembers(key)
+
+	require.Nil(t, err)
+
+	return sIds
 }
 
 func TestCreateSession(t *testing.T) {
 	t.Parallel()
-	client, mock := redismock.NewClientMock()
-	dsm := NewDragonflySessionManager(client)
 	require := require.New(t)
+	df := newTestDragonfly(t)
 
-	userId := uint32(1337)
-
-	mock.ExpectTxPipeline()
-	mock.
-		Regexp().
-		ExpectSetNX(
-			`[a-z]+`,
-			userId,
-			SESSION_TTL,
-		).
-		SetVal(true)
-	mock.
-		Regexp().
-		ExpectSAdd(
-			fmt.Sprintf("%s:%d", KNOWN_SESSIONS_SET_KEY_PREFIX, userId),
-			`[a-z]+`,
-		).
-		SetVal(1)
-	mock.ExpectTxPipelineExec().SetVal(make([]interface{}, 0))
-
-	sId, err := dsm.CreateSession(
+	sId, err := df.dsm.CreateSession(
 		context.Background(),
-		&SessionData{UserId: userId},
+		&SessionData{
+			UserId: 1337,
+			ClientInfo: ClientInfo{
+				Ip:        `127.0.0.1`,
+				UserAgent: `curl/8.0`,
+				Device:    `Laptop`,
+			},
+		},
 	)
 
-	require.Nilf(err, "Expected sId, but got error")
-	require.Equalf(
-		SESSION_ID_LENGTH*2,
-		len(sId),
-		"Expected sId to be %d length, but got %d",
-		SESSION_ID_LENGTH*2,
-		len(sId),
-	)
+	require.Nil(err)
+	require.Len(sId, SESSION_ID_LENGTH*2)
+
+	key := sessionKey(sId)
+	setKey := knownSessionsSetKey(1337)
+	now := encodeTime(df.clock.Now())
+
+	for field, value := range map[string]string{
+		SESSION_USER_ID_FIELD:      `1337`,
+		SESSION_CREATED_AT_FIELD:   now,
+		SESSION_LAST_SEEN_AT_FIELD: now,
+		SESSION_IP_FIELD:           `127.0.0.1`,
+		SESSION_USER_AGENT_FIELD:   `curl/8.0`,
+		SESSION_DEVICE_FIELD:       `Laptop`,
+	} {
+		require.Equal(value, df.server.HGet(key, field))
+	}
+
+	score, err := df.server.ZScore(setKey, sId)
 
-	checkMockExpectationsWereMet(t, mock)
+	require.Nil(err)
+	require.Equal(float64(df.clock.Now().Add(24*time.Hour).UnixMilli()), score)
+	require.Equal(24*time.Hour, df.server.TTL(key))
+	require.Equal(24*time.Hour, df.server.TTL(setKey))
 }
 
-func TestDeleteSession(t *testing.T) {
+func TestKnownSessionsSetExpiresWithNewestSession(t *testing.T) {
 	t.Parallel()
-	client, mock := redismock.NewClientMock()
-	dsm := NewDragonflySessionManager(client)
 	require := require.New(t)
+	df := newTestDragonfly(t)
+	setKey := knownSessionsSetKey(1337)
 
-	userId := uint32(1337)
-	sId, err := crypto.GenerateSecureId(SESSION_ID_LENGTH)
+	sId := df.create(t, 1337)
 
-	require.Nil(err)
+	df.advance(time.Hour)
+	df.create(t, 1337)
+	require.Equal(24*time.Hour, df.server.TTL(setKey))
 
-	testVariants := []struct {
-		title          string
-		getSuccessful  bool
-		delResult      int
-		sRemResult     int
-		sMembersResult int
-	}{
-		{"Defined second session", true, 1, 1, 1},
-		{"Undefined session", false, 0, 0, 0},
-		{"Defined last session", true, 1, 1, 0},
-	}
+	df.advance(time.Hour)
+	require.Equal(23*time.Hour, df.server.TTL(setKey))
 
-	for _, tt := range testVariants {
-		t.Run(tt.title, (func(t *testing.T) {
-			getExpect := mock.ExpectGet(
-				fmt.Sprintf(`%s:%s`, SESSION_KEY_PREFIX, sId),
-			)
+	// Renewed session becomes the newest one
+	require.Nil(df.dsm.RenewalSession(context.Background(), sId))
+	require.Equal(24*time.Hour, df.server.TTL(setKey))
 
-			if tt.getSuccessful {
-				getExpect.SetVal(strconv.Itoa(int(userId)))
-				knownSessionSet := fmt.Sprintf(
-					`%s:%d`,
-					KNOWN_SESSIONS_SET_KEY_PREFIX,
-					userId,
-				)
-
-				mock.ExpectTxPipeline()
-				mock.
-					ExpectDel(fmt.Sprintf(`%s:%s`, SESSION_KEY_PREFIX, sId)).
-					SetVal(int64(tt.delResult))
-				mock.
-					ExpectSRem(knownSessionSet, sId).
-					SetVal(int64(tt.sRemResult))
-				mock.ExpectTxPipelineExec().SetVal(make([]interface{}, 0))
-
-				sIds := make([]string, tt.sMembersResult)
-
-				for index := range sIds {
-					id, err := crypto.GenerateSecureId(SESSION_ID_LENGTH)
-
-					require.Nil(err)
-					sIds[index] = id
-				}
-
-				mock.ExpectSMembers(knownSessionSet).SetVal(sIds)
-
-				if tt.sMembersResult == 0 {
-					mock.ExpectDel(knownSessionSet).SetVal(1)
-				}
-			} else {
-				getExpect.RedisNil()
-			}
+	df.advance(24 * time.Hour)
+	require.False(df.server.Exists(setKey))
+}
 
-			err = dsm.DeleteSession(context.Background(), sId)
+func TestDeleteSession(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	df := newTestDragonfly(t)
+	ctx := context.Background()
 
-			if tt.getSuccessful {
-				require.Nilf(
-					err,
-					"Expected run without error, but got %v",
-					err,
-				)
-			} else {
-				require.EqualError(err, SESSION_NOT_FOUND_ERROR)
-			}
+	sId := df.create(t, 1337)
+	otherSId := df.create(t, 1337)
 
-			checkMockExpectationsWereMet(t, mock)
-		}))
-	}
+	require.Nil(df.dsm.DeleteSession(ctx, sId))
+	require.False(df.server.Exists(sessionKey(sId)))
+	require.Equal([]string{otherSId}, df.knownSessions(t, 1337))
+
+	// Set is removed with its last member
+	require.Nil(df.dsm.DeleteSession(ctx, otherSId))
+	require.Empty(df.server.Keys())
+
+	require.ErrorIs(df.dsm.DeleteSession(ctx, sId), ErrSessionNotFound)
 }
 
 func TestRenewalSession(t *testing.T) {
 	t.Parallel()
-	client, mock := redismock.NewClientMock()
-	dsm := NewDragonflySessionManager(client)
 	require := require.New(t)
+	df := newTestDragonfly(t)
+	ctx := context.Background()
+
+	sId := df.create(t, 1337)
+	key := sessionKey(sId)
 
-	sId, err := crypto.GenerateSecureId(SESSION_ID_LENGTH)
+	df.advance(time.Hour)
+	require.Nil(df.dsm.RenewalSession(ctx, sId))
+
+	score, err := df.server.ZScore(knownSessionsSetKey(1337), sId)
 
 	require.Nil(err)
+	require.Equal(float64(df.clock.Now().Add(24*time.Hour).UnixMilli()), score)
+	require.Equal(24*time.Hour, df.server.TTL(key))
+	require.Equal(
+		encodeTime(df.clock.Now()),
+		df.server.HGet(key, SESSION_LAST_SEEN_AT_FIELD),
+	)
 
-	testVariants := []struct {
-		title  string
-		result bool
-	}{
-		{"Defined session", true},
-		{"Undefined session", false},
-	}
+	require.ErrorIs(df.dsm.RenewalSession(ctx, `unknown`), ErrSessionNotFound)
 
-	for _, tt := range testVariants {
-		t.Run(tt.title, func(t *testing.T) {
-			mock.
-				ExpectExpire(
-					fmt.Sprintf("%s:%s", SESSION_KEY_PREFIX, sId),
-					SESSION_TTL,
-				).
-				SetVal(tt.result)
-
-			err = dsm.RenewalSession(context.Background(), sId)
-
-			if tt.result {
-				require.Nil(err)
-			} else {
-				require.EqualError(err, SESSION_NOT_FOUND_ERROR)
-			}
+	// Session past its lifetime
+	df.server.HSet(
+		key,
+		SESSION_CREATED_AT_FIELD,
+		encodeTime(df.clock.Now().Add(-30*24*time.Hour)),
+	)
+	require.ErrorIs(df.dsm.RenewalSession(ctx, sId), ErrSessionNotFound)
+}
 
-			checkMockExpectationsWereMet(t, mock)
-		})
+// Changes the key right before every transaction, so it never commits
+type conflictingHook struct {
+	server *miniredis.Miniredis
+	key    string
+}
+
+func (self conflictingHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (self conflictingHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return next
+}
+
+func (self conflictingHook) ProcessPipelineHook(
+	next redis.ProcessPipelineHook,
+) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		self.server.HSet(self.key, `conflict`, `true`)
+
+		return next(ctx, cmds)
 	}
 }
 
-func TestGetSessionData(t *testing.T) {
+func TestRenewalSessionGivesUpOnConflicts(t *testing.T) {
 	t.Parallel()
-	client, mock := redismock.NewClientMock()
-	dsm := NewDragonflySessionManager(client)
 	require := require.New(t)
+	df := newTestDragonfly(t)
+	sId := df.create(t, 1337)
 
-	userId := 1337
-	sId, err := crypto.GenerateSecureId(SESSION_ID_LENGTH)
+	df.dsm.client.AddHook(conflictingHook{df.server, sessionKey(sId)})
 
-	require.Nil(err)
+	require.ErrorIs(
+		df.dsm.RenewalSession(context.Background(), sId),
+		ErrSessionRenewalConflict,
+	)
+}
+
+func TestGetSessionData(t *testing.T) {
+	t.Parallel()
+
+	createdAt := time.UnixMilli(time.Now().Add(-time.Hour).UnixMilli())
 
 	testVariants := []struct {
-		title        string
-		getCmdResult interface{}
-		resultError  error
+		title       string
+		fields      map[string]string
+		resultError error
 	}{
-		{"Defined valid session data", strconv.Itoa(userId), nil},
-		{"Undefined session", nil, redis.Nil},
+		{
+			"Defined valid session data",
+			map[string]string{
+				SESSION_USER_ID_FIELD:      `1337`,
+				SESSION_CREATED_AT_FIELD:   encodeTime(createdAt),
+				SESSION_LAST_SEEN_AT_FIELD: encodeTime(createdAt),
+				SESSION_IP_FIELD:           `127.0.0.1`,
+				SESSION_USER_AGENT_FIELD:   `curl/8.0`,
+				SESSION_DEVICE_FIELD:       `Laptop`,
+			},
+			nil,
+		},
+		{"Undefined session", map[string]string{}, ErrSessionNotFound},
 		{
 			"Defined invalid session data",
-			"l33t",
-			errors.New(SESSION_DATA_INVALID_ERROR),
+			map[string]string{SESSION_USER_ID_FIELD: `l33t`},
+			ErrSessionDataInvalid,
+		},
+		{
+			"Defined session data without timestamps",
+			map[string]string{SESSION_USER_ID_FIELD: strconv.Itoa(1337)},
+			ErrSessionDataInvalid,
 		},
 	}
 
 	for _, tt := range testVariants {
 		t.Run(tt.title, func(t *testing.T) {
-			expectGetMock := mock.ExpectGet(
-				fmt.Sprintf("%s:%s", SESSION_KEY_PREFIX, sId),
-			)
+			require := require.New(t)
+			df := newTestDragonfly(t)
+			key := sessionKey(`sid`)
 
-			if tt.resultError == redis.Nil {
-				expectGetMock.RedisNil()
-			} else {
-				expectGetMock.SetVal(tt.getCmdResult.(string))
+			for field, value := range tt.fields {
+				df.server.HSet(key, field, value)
 			}
 
-			sData, err := dsm.GetSessionData(context.Background(), sId)
+			if len(tt.fields) != 0 {
+				df.server.SetTTL(key, 24*time.Hour)
+			}
+
+			sData, err := df.dsm.GetSessionData(context.Background(), `sid`)
 
 			if tt.resultError != nil {
-				if tt.resultError == redis.Nil {
-					require.EqualError(err, SESSION_NOT_FOUND_ERROR)
-				}
-
-				if tt.resultError.Error() == SESSION_DATA_INVALID_ERROR {
-					require.EqualError(err, tt.resultError.Error())
-				}
-			} else {
-				require.Equalf(
-					uint32(userId),
-					sData.UserId,
-					"Expected valid session data %d, but got %d",
-					userId,
-					sData.UserId,
-				)
+				require.ErrorIs(err, tt.resultError)
+				return
 			}
 
-			checkMockExpectationsWereMet(t, mock)
+			require.Nil(err)
+			require.Equal(&SessionData{
+				UserId: 1337,
+				Handle: SessionHandle(`sid`),
+				ClientInfo: ClientInfo{
+					Ip:        `127.0.0.1`,
+					UserAgent: `curl/8.0`,
+					Device:    `Laptop`,
+				},
+				CreatedAt:  createdAt,
+				LastSeenAt: createdAt,
+				ExpiresAt:  df.clock.Now().Add(24 * time.Hour),
+			}, sData)
 		})
 	}
 }
 
-func TestResetSession(t *testing.T) {
+func TestLegacySessionIsConverted(t *testing.T) {
+	t.Parallel()
+
+	testVariants := []struct {
+		title string
+		call  func(dsm *DragonflySessionManager, sId string) error
+		// Whether session is left in place by the call
+		kept bool
+	}{
+		{`GetSessionData`, func(dsm *DragonflySessionManager, sId string) error {
+			sData, err := dsm.GetSessionData(context.Background(), sId)
+
+			if err == nil && sData.UserId != 1337 {
+				return ErrSessionDataInvalid
+			}
+
+			return err
+		}, true},
+		{`RenewalSession`, func(dsm *DragonflySessionManager, sId string) error {
+			return dsm.RenewalSession(context.Background(), sId)
+		}, true},
+		{`DeleteSession`, func(dsm *DragonflySessionManager, sId string) error {
+			return dsm.DeleteSession(context.Background(), sId)
+		}, false},
+	}
+
+	for _, variant := range testVariants {
+		t.Run(variant.title, func(t *testing.T) {
+			require := require.New(t)
+			df := newTestDragonfly(t)
+			key := sessionKey(`legacy`)
+
+			// Sessions held just user id before they became hashes
+			require.Nil(df.server.Set(key, `1337`))
+			df.server.SetTTL(key, time.Hour)
+
+			require.Nil(variant.call(df.dsm, `legacy`))
+			require.Equal(variant.kept, df.server.Exists(key))
+
+			if variant.kept {
+				require.Equal(`1337`, df.server.HGet(key, SESSION_USER_ID_FIELD))
+				require.Equal([]string{`legacy`}, df.knownSessions(t, 1337))
+			}
+		})
+	}
+}
+
+func TestMigrateLegacySessions(t *testing.T) {
 	t.Parallel()
-	client, mock := redismock.NewClientMock()
-	dsm := NewDragonflySessionManager(client)
 	require := require.New(t)
+	df := newTestDragonfly(t)
+	legacySetKey := LEGACY_KNOWN_SESSIONS_SET_KEY_PREFIX + `:1337`
+
+	require.Nil(df.server.Set(sessionKey(`legacy`), `1337`))
+	df.server.SetTTL(sessionKey(`legacy`), time.Hour)
+	// Member of expired session
+	_, err := df.server.SAdd(legacySetKey, `legacy`, `expired`)
+
+	require.Nil(err)
+
+	migrated, err := df.dsm.MigrateLegacySessions(context.Background())
 
-	sId1, err := crypto.GenerateSecureId(SESSION_ID_LENGTH)
 	require.Nil(err)
-	sId2, err := crypto.GenerateSecureId(SESSION_ID_LENGTH)
+	require.Equal(int64(1), migrated)
+	require.False(df.server.Exists(legacySetKey))
+	require.Equal([]string{`legacy`}, df.knownSessions(t, 1337))
+}
+
+func TestInvalidLegacySessionIsDropped(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	df := newTestDragonfly(t)
+
+	require.Nil(df.server.Set(sessionKey(`legacy`), `garbage`))
+
+	_, err := df.dsm.GetSessionData(context.Background(), `legacy`)
+
+	require.ErrorIs(err, ErrSessionNotFound)
+	require.False(df.server.Exists(sessionKey(`legacy`)))
+}
+
+func TestListSessions(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	df := newTestDragonfly(t)
+
+	df.create(t, 1337)
+	df.advance(12 * time.Hour)
+
+	olderSId := df.create(t, 1337)
+
+	df.advance(time.Hour)
+
+	newerSId := df.create(t, 1337)
+
+	df.create(t, 1336)
+
+	// First session has expired, its member is pruned by janitor only
+	df.advance(12 * time.Hour)
+	require.Len(df.knownSessions(t, 1337), 3)
+
+	sessions, err := df.dsm.ListSessions(context.Background(), 1337)
+
 	require.Nil(err)
+	require.Len(sessions, 2)
+	require.Equal(SessionHandle(newerSId), sessions[0].Handle)
+	require.Equal(SessionHandle(olderSId), sessions[1].Handle)
+}
+
+func TestRevokeSession(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	df := newTestDragonfly(t)
+	ctx := context.Background()
+
+	sId := df.create(t, 1337)
+	otherSId := df.create(t, 1337)
+
+	require.Nil(df.dsm.RevokeSession(ctx, 1337, SessionHandle(otherSId)))
+	require.False(df.server.Exists(sessionKey(otherSId)))
+	require.Equal([]string{sId}, df.knownSessions(t, 1337))
+	require.ErrorIs(
+		df.dsm.RevokeSession(ctx, 1337, SessionHandle(otherSId)),
+		ErrSessionHandleNotFound,
+	)
+}
+
+func TestResetOtherSessions(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	df := newTestDragonfly(t)
+
+	sId := df.create(t, 1337)
+
+	df.create(t, 1337)
+	df.create(t, 1337)
+
+	require.Nil(df.dsm.ResetOtherSessions(context.Background(), 1337, sId))
+	require.ElementsMatch(
+		[]string{sessionKey(sId), knownSessionsSetKey(1337)},
+		df.server.Keys(),
+	)
+	require.Equal([]string{sId}, df.knownSessions(t, 1337))
+}
+
+func TestResetSession(t *testing.T) {
+	t.Parallel()
 
 	testVariants := []struct {
-		title  string
-		sIds   []string
-		userId uint32
+		title    string
+		sessions int
 	}{
-		{"Defined session", []string{sId1, sId2}, 1337},
-		{"Undefined session", []string{}, 1336},
+		{"Defined sessions", 3},
+		{"Undefined sessions", 0},
 	}
 
 	for _, tt := range testVariants {
 		t.Run(tt.title, func(t *testing.T) {
-			knownSessionsSet := fmt.Sprintf(
-				"%s:%d",
-				KNOWN_SESSIONS_SET_KEY_PREFIX,
-				tt.userId,
-			)
+			require := require.New(t)
+			df := newTestDragonfly(t)
 
-			mock.ExpectSMembers(knownSessionsSet).SetVal(tt.sIds)
-			mock.ExpectTxPipeline()
-			mock.ExpectDel(tt.sIds...).SetVal(int64(len(tt.sIds)))
-			mock.ExpectDel(knownSessionsSet).SetVal(1)
-			mock.ExpectTxPipelineExec()
+			for range tt.sessions {
+				df.create(t, 1337)
+			}
 
-			err := dsm.ResetSessions(context.Background(), tt.userId)
+			otherUserSId := df.create(t, 1336)
 
-			require.Nil(err)
-			checkMockExpectationsWereMet(t, mock)
+			require.Nil(df.dsm.ResetSessions(context.Background(), 1337))
+
+			// Neither session keys nor the set of the user are left
+			require.ElementsMatch(
+				[]string{sessionKey(otherUserSId), knownSessionsSetKey(1336)},
+				df.server.Keys(),
+			)
 		})
 	}
 }
+
+func TestPruneKnownSessions(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	df := newTestDragonfly(t)
+	ctx := context.Background()
+
+	df.create(t, 1337)
+	df.create(t, 1336)
+	df.advance(12 * time.Hour)
+
+	sId := df.create(t, 1337)
+	otherUserSId := df.create(t, 1336)
+
+	df.advance(12 * time.Hour)
+
+	pruned, err := df.dsm.PruneKnownSessions(ctx)
+
+	require.Nil(err)
+	require.Equal(int64(2), pruned)
+	require.Equal([]string{sId}, df.knownSessions(t, 1337))
+	require.Equal([]string{otherUserSId}, df.knownSessions(t, 1336))
+
+	pruned, err = df.dsm.PruneKnownSessions(ctx)
+
+	require.Nil(err)
+	require.Zero(pruned)
+}
+
+func TestSessionScriptsAreCached(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	df := newTestDragonfly(t)
+	ctx := context.Background()
+
+	sId := df.create(t, 1337)
+
+	require.Nil(df.dsm.DeleteSession(ctx, sId))
+	require.Nil(df.dsm.ResetSessions(ctx, 1337))
+
+	cached, err := df.dsm.client.ScriptExists(
+		ctx,
+		createSessionScript.Hash(),
+		deleteSessionScript.Hash(),
+		resetSessionsScript.Hash(),
+	).Result()
+
+	require.Nil(err)
+	require.Equal([]bool{true, true, true}, cached)
+
+	// Flushed script cache is refilled on NOSCRIPT reply
+	require.Nil(df.dsm.client.ScriptFlush(ctx).Err())
+	df.create(t, 1337)
+}
+
+func TestResetSessionsRacingCreate(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	df := newTestDragonfly(t)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+
+	concurrency := 32
+	errs := make([]error, 2*concurrency)
+
+	for index := range concurrency {
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+
+			_, errs[2*index] = df.dsm.CreateSession(ctx, &SessionData{UserId: 1337})
+		}()
+
+		go func() {
+			defer wg.Done()
+
+			errs[2*index+1] = df.dsm.ResetSessions(ctx, 1337)
+		}()
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		require.Nil(err)
+	}
+
+	// Every session survived reset is known and every known session exists
+	sIds := df.knownSessions(t, 1337)
+	keys := make([]string, 0, len(sIds)+1)
+
+	for _, sId := range sIds {
+		keys = append(keys, sessionKey(sId))
+	}
+
+	if len(sIds) != 0 {
+		keys = append(keys, knownSessionsSetKey(1337))
+	}
+
+	require.ElementsMatch(keys, df.server.Keys())
+}
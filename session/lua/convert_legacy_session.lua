@@ -0,0 +1,45 @@
+-- Converts session stored as plain string holding user id, the format used
+-- before sessions became hashes, into session hash indexed in the known
+-- sessions set of the user. Creation time wasn't stored, so the session
+-- counts as created now and keeps its remaining TTL. Session with invalid
+-- user id is deleted.
+--
+-- KEYS[1] session key
+-- ARGV[1] sId
+-- ARGV[2] current time, unix milliseconds
+-- ARGV[3] TTL given to session without expiry, milliseconds
+-- ARGV[4] known sessions set key prefix
+-- ARGV[5] name of user id field of session hash
+-- ARGV[6...] rest of session hash fields and values
+--
+-- Returns 1 if session is converted, 0 if it isn't a string or is deleted
+
+if redis.call('TYPE', KEYS[1]).ok ~= 'string' then
+	return 0
+end
+
+local userId = redis.call('GET', KEYS[1])
+local ttl = redis.call('PTTL', KEYS[1])
+
+redis.call('DEL', KEYS[1])
+
+if not string.match(userId, '^%d+$') then
+	return 0
+end
+
+if ttl < 0 then
+	ttl = tonumber(ARGV[3])
+end
+
+local now = tonumber(ARGV[2])
+local setKey = ARGV[4] .. ':' .. userId
+
+redis.call('HSET', KEYS[1], ARGV[5], userId, unpack(ARGV, 6))
+redis.call('PEXPIRE', KEYS[1], string.format('%d', ttl))
+redis.call('ZADD', setKey, string.format('%d', now + ttl), ARGV[1])
+
+local newest = redis.call('ZREVRANGE', setKey, 0, 0, 'WITHSCORES')
+
+redis.call('PEXPIRE', setKey, string.format('%d', tonumber(newest[2]) - now))
+
+return 1
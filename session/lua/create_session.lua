@@ -0,0 +1,72 @@
+-- Creates session hash unless sId is taken and indexes it in the known
+-- sessions set of the user, which expires with its newest session. When user
+-- is at the sessions limit, either rejects creation or evicts least recently
+-- seen sessions. Pending sessions are neither counted nor evicted, creating
+-- one doesn't check the limit.
+--
+-- KEYS[1] session key
+-- KEYS[2] known sessions set key
+-- ARGV[1] sId
+-- ARGV[2] current time, unix milliseconds
+-- ARGV[3] session expiry, unix milliseconds
+-- ARGV[4] maximum number of sessions, 0 means unlimited
+-- ARGV[5] limit policy, "reject" or "evict"
+-- ARGV[6] session key prefix
+-- ARGV[7] name of last seen at field of session hash
+-- ARGV[8] name of pending second factor field of session hash
+-- ARGV[9] "true" if the session is pending second factor
+-- ARGV[10...] session hash fields and values
+--
+-- Returns {1, evicted sIds...} if session is created, {0} if sId is taken,
+-- {-1} if limit is reached
+
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return { 0 }
+end
+
+local now = tonumber(ARGV[2])
+local expiresAt = tonumber(ARGV[3])
+local maxSessions = tonumber(ARGV[4])
+local result = { 1 }
+
+if maxSessions > 0 and ARGV[9] ~= 'true' then
+	redis.call('ZREMRANGEBYSCORE', KEYS[2], '-inf', ARGV[2])
+
+	local sessions = {}
+
+	for _, sId in ipairs(redis.call('ZRANGE', KEYS[2], 0, -1)) do
+		local fields = redis.call('HMGET', ARGV[6] .. ':' .. sId, ARGV[7], ARGV[8])
+
+		if fields[2] ~= 'true' then
+			table.insert(sessions, { sId = sId, lastSeenAt = tonumber(fields[1]) or 0 })
+		end
+	end
+
+	local excess = #sessions - maxSessions + 1
+
+	if excess > 0 then
+		if ARGV[5] == 'reject' then
+			return { -1 }
+		end
+
+		table.sort(sessions, function(a, b)
+			return a.lastSeenAt < b.lastSeenAt
+		end)
+
+		for index = 1, excess do
+			redis.call('DEL', ARGV[6] .. ':' .. sessions[index].sId)
+			redis.call('ZREM', KEYS[2], sessions[index].sId)
+			table.insert(result, sessions[index].sId)
+		end
+	end
+end
+
+redis.call('HSET', KEYS[1], unpack(ARGV, 10))
+redis.call('PEXPIRE', KEYS[1], string.format('%d', expiresAt - now))
+redis.call('ZADD', KEYS[2], ARGV[3], ARGV[1])
+
+local newest = redis.call('ZREVRANGE', KEYS[2], 0, 0, 'WITHSCORES')
+
+redis.call('PEXPIRE', KEYS[2], string.format('%d', tonumber(newest[2]) - now))
+
+return result
@@ -0,0 +1,19 @@
+-- Deletes session hash and its member of the known sessions set of the user.
+--
+-- KEYS[1] session key
+-- ARGV[1] sId
+-- ARGV[2] name of user id field of session hash
+-- ARGV[3] known sessions set key prefix
+--
+-- Returns user id of deleted session, nil if there is no such session
+
+local userId = redis.call('HGET', KEYS[1], ARGV[2])
+
+if not userId then
+	return false
+end
+
+redis.call('DEL', KEYS[1])
+redis.call('ZREM', ARGV[3] .. ':' .. userId, ARGV[1])
+
+return userId
@@ -0,0 +1,20 @@
+-- Deletes every session of the user except the kept one, along with their
+-- members of the known sessions set.
+--
+-- KEYS[1] known sessions set key
+-- ARGV[1] session key prefix
+-- ARGV[2] kept sId, empty to delete every session
+--
+-- Returns how many sessions are deleted, including already expired ones
+
+local deleted = 0
+
+for _, sId in ipairs(redis.call('ZRANGE', KEYS[1], 0, -1)) do
+	if sId ~= ARGV[2] then
+		redis.call('DEL', ARGV[1] .. ':' .. sId)
+		redis.call('ZREM', KEYS[1], sId)
+		deleted = deleted + 1
+	end
+end
+
+return deleted
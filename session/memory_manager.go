@@ -0,0 +1,392 @@
+package session
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// Implementation of session manager keeping sessions in process memory. It is
+// meant for tests and single-node development, sessions don't survive restart
+
+type MemorySessionManagerOptions struct {
+	ExpirationOptions
+	LimitOptions
+	// Defaults to SystemClock
+	Clock Clock
+	// Defaults to NopSessionObserver
+	Observer SessionObserver
+}
+
+func NewMemorySessionManager(
+	options *MemorySessionManagerOptions,
+) *MemorySessionManager {
+	msm := &MemorySessionManager{
+		sessions:     map[string]*SessionData{},
+		userSessions: map[uint32]map[string]struct{}{},
+	}
+
+	if options != nil {
+		msm.options = *options
+	}
+
+	msm.options.ExpirationOptions = msm.options.ExpirationOptions.WithDefaults()
+	msm.options.LimitOptions = msm.options.LimitOptions.WithDefaults()
+
+	if msm.options.Clock == nil {
+		msm.options.Clock = SystemClock
+	}
+
+	if msm.options.Observer == nil {
+		msm.options.Observer = NopSessionObserver{}
+	}
+
+	return msm
+}
+
+type MemorySessionManager struct {
+	options MemorySessionManagerOptions
+
+	mu       sync.Mutex
+	sessions map[string]*SessionData
+	// Counterpart of known sessions set, sIds of every user session
+	userSessions map[uint32]map[string]struct{}
+}
+
+func (msm *MemorySessionManager) CreateSession(
+	ctx context.Context,
+	sData *SessionData,
+) (string, error) {
+	events := msm.events(ctx)
+	defer events.report()
+
+	msm.mu.Lock()
+	defer msm.mu.Unlock()
+
+	now := msm.options.Clock.Now()
+
+	// Drops expired sessions of the user, so they don't pile up
+	for sId := range msm.userSessions[sData.UserId] {
+		msm.aliveSession(sId)
+	}
+
+	if !sData.PendingSecondFactor {
+		if err := msm.makeRoom(sData.UserId, events); err != nil {
+			return "", err
+		}
+	}
+
+	return createWithUniqueId(ctx, func(sId string) (bool, error) {
+		if _, exists := msm.aliveSession(sId); exists {
+			return false, nil
+		}
+
+		msm.sessions[sId] = &SessionData{
+			UserId:              sData.UserId,
+			Handle:              SessionHandle(sId),
+			ClientInfo:          sData.ClientInfo,
+			PendingSecondFactor: sData.PendingSecondFactor,
+			CreatedAt:           now,
+			LastSeenAt:          now,
+			ExpiresAt: msm.options.sessionExpiresAt(
+				sData.PendingSecondFactor,
+				now,
+				now,
+			),
+		}
+
+		if msm.userSessions[sData.UserId] == nil {
+			msm.userSessions[sData.UserId] = map[string]struct{}{}
+		}
+
+		msm.userSessions[sData.UserId][sId] = struct{}{}
+		events.created(*msm.sessions[sId])
+
+		return true, nil
+	})
+}
+
+// Makes room for one more session of the user according to LimitOptions,
+// expects expired sessions of the user to be dropped. Pending sessions aren't
+// counted. Must be called with mu held
+func (msm *MemorySessionManager) makeRoom(
+	userId uint32,
+	events *memoryEvents,
+) error {
+	if msm.options.MaxSessions <= 0 {
+		return nil
+	}
+
+	sIds := make([]string, 0, len(msm.userSessions[userId]))
+
+	for sId := range msm.userSessions[userId] {
+		if !msm.sessions[sId].PendingSecondFactor {
+			sIds = append(sIds, sId)
+		}
+	}
+
+	excess := len(sIds) - msm.options.MaxSessions + 1
+
+	if excess <= 0 {
+		return nil
+	}
+
+	if msm.options.LimitPolicy == SESSION_LIMIT_POLICY_REJECT {
+		return ErrSessionLimitReached
+	}
+
+	sort.Slice(sIds, func(i, j int) bool {
+		return msm.sessions[sIds[i]].LastSeenAt.Before(msm.sessions[sIds[j]].LastSeenAt)
+	})
+
+	for _, sId := range sIds[:excess] {
+		msm.deleteSession(sId)
+		events.deleted(userId, SessionHandle(sId))
+	}
+
+	return nil
+}
+
+func (msm *MemorySessionManager) DeleteSession(
+	ctx context.Context,
+	sId string,
+) error {
+	events := msm.events(ctx)
+	defer events.report()
+
+	msm.mu.Lock()
+	defer msm.mu.Unlock()
+
+	sData, exists := msm.aliveSession(sId)
+
+	if !exists {
+		return ErrSessionNotFound
+	}
+
+	msm.deleteSession(sId)
+	events.deleted(sData.UserId, sData.Handle)
+
+	return nil
+}
+
+// Extends session by idle timeout, but not past its lifetime, and marks it
+// as seen now
+func (msm *MemorySessionManager) RenewalSession(
+	ctx context.Context,
+	sId string,
+) error {
+	events := msm.events(ctx)
+	defer events.report()
+
+	msm.mu.Lock()
+	defer msm.mu.Unlock()
+
+	sData, exists := msm.aliveSession(sId)
+
+	if !exists {
+		return ErrSessionNotFound
+	}
+
+	now := msm.options.Clock.Now()
+	expiresAt := msm.options.sessionExpiresAt(
+		sData.PendingSecondFactor,
+		sData.CreatedAt,
+		now,
+	)
+
+	if !expiresAt.After(now) {
+		return ErrSessionNotFound
+	}
+
+	sData.LastSeenAt = now
+	sData.ExpiresAt = expiresAt
+	events.renewed(sData.UserId, sData.Handle)
+
+	return nil
+}
+
+func (msm *MemorySessionManager) GetSessionData(
+	ctx context.Context,
+	sId string,
+) (*SessionData, error) {
+	msm.mu.Lock()
+	defer msm.mu.Unlock()
+
+	sData, exists := msm.aliveSession(sId)
+
+	if !exists {
+		return nil, ErrSessionNotFound
+	}
+
+	result := *sData
+
+	return &result, nil
+}
+
+func (msm *MemorySessionManager) ListSessions(
+	ctx context.Context,
+	userId uint32,
+) ([]SessionData, error) {
+	msm.mu.Lock()
+	defer msm.mu.Unlock()
+
+	sessions := []SessionData{}
+
+	for sId := range msm.userSessions[userId] {
+		if sData, exists := msm.aliveSession(sId); exists && !sData.PendingSecondFactor {
+			sessions = append(sessions, *sData)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+
+	return sessions, nil
+}
+
+func (msm *MemorySessionManager) RevokeSession(
+	ctx context.Context,
+	userId uint32,
+	handle string,
+) error {
+	events := msm.events(ctx)
+	defer events.report()
+
+	msm.mu.Lock()
+	defer msm.mu.Unlock()
+
+	for sId := range msm.userSessions[userId] {
+		if SessionHandle(sId) != handle {
+			continue
+		}
+
+		if _, exists := msm.aliveSession(sId); !exists {
+			return ErrSessionHandleNotFound
+		}
+
+		msm.deleteSession(sId)
+		events.deleted(userId, handle)
+
+		return nil
+	}
+
+	return ErrSessionHandleNotFound
+}
+
+func (msm *MemorySessionManager) ResetOtherSessions(
+	ctx context.Context,
+	userId uint32,
+	keptSId string,
+) error {
+	events := msm.events(ctx)
+	defer events.report()
+
+	msm.mu.Lock()
+	defer msm.mu.Unlock()
+
+	for sId := range msm.userSessions[userId] {
+		if sId != keptSId {
+			msm.deleteSession(sId)
+		}
+	}
+
+	events.reset(userId, keptSessionHandle(keptSId))
+
+	return nil
+}
+
+func (msm *MemorySessionManager) ResetSessions(
+	ctx context.Context,
+	userId uint32,
+) error {
+	events := msm.events(ctx)
+	defer events.report()
+
+	msm.mu.Lock()
+	defer msm.mu.Unlock()
+
+	for sId := range msm.userSessions[userId] {
+		msm.deleteSession(sId)
+	}
+
+	events.reset(userId, "")
+
+	return nil
+}
+
+// Returns session unless it has expired, expired session is dropped. Must be
+// called with mu held
+func (msm *MemorySessionManager) aliveSession(sId string) (*SessionData, bool) {
+	sData, exists := msm.sessions[sId]
+
+	if !exists {
+		return nil, false
+	}
+
+	if !sData.ExpiresAt.After(msm.options.Clock.Now()) {
+		msm.deleteSession(sId)
+
+		return nil, false
+	}
+
+	return sData, true
+}
+
+// Must be called with mu held
+func (msm *MemorySessionManager) deleteSession(sId string) {
+	sData, exists := msm.sessions[sId]
+
+	if !exists {
+		return
+	}
+
+	delete(msm.sessions, sId)
+	delete(msm.userSessions[sData.UserId], sId)
+
+	if len(msm.userSessions[sData.UserId]) == 0 {
+		delete(msm.userSessions, sData.UserId)
+	}
+}
+
+func (msm *MemorySessionManager) events(ctx context.Context) *memoryEvents {
+	return &memoryEvents{ctx: ctx, observer: msm.options.Observer}
+}
+
+// Events recorded while mu is held and reported once it is released, so
+// observer may call the manager back
+type memoryEvents struct {
+	ctx      context.Context
+	observer SessionObserver
+	pending  []func()
+}
+
+func (self *memoryEvents) created(sData SessionData) {
+	self.pending = append(self.pending, func() {
+		self.observer.OnCreated(self.ctx, &sData)
+	})
+}
+
+func (self *memoryEvents) renewed(userId uint32, handle string) {
+	self.pending = append(self.pending, func() {
+		self.observer.OnRenewed(self.ctx, userId, handle)
+	})
+}
+
+func (self *memoryEvents) deleted(userId uint32, handle string) {
+	self.pending = append(self.pending, func() {
+		self.observer.OnDeleted(self.ctx, userId, handle)
+	})
+}
+
+func (self *memoryEvents) reset(userId uint32, keptHandle string) {
+	self.pending = append(self.pending, func() {
+		self.observer.OnReset(self.ctx, userId, keptHandle)
+	})
+}
+
+func (self *memoryEvents) report() {
+	for _, event := range self.pending {
+		event()
+	}
+}
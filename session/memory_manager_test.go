@@ -0,0 +1,215 @@
+package session
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestMemorySessionManager() (*MemorySessionManager, *ManualClock) {
+	clock := NewManualClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	msm := NewMemorySessionManager(&MemorySessionManagerOptions{
+		ExpirationOptions: ExpirationOptions{
+			Lifetime:    30 * 24 * time.Hour,
+			IdleTimeout: 24 * time.Hour,
+		},
+		Clock: clock,
+	})
+
+	return msm, clock
+}
+
+func TestMemorySessionManagerCreateSession(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	msm, clock := newTestMemorySessionManager()
+	ctx := context.Background()
+
+	sId, err := msm.CreateSession(ctx, &SessionData{
+		UserId:     1337,
+		ClientInfo: ClientInfo{Ip: "192.0.2.1", Device: "Laptop"},
+	})
+
+	require.Nil(err)
+	require.Len(sId, SESSION_ID_LENGTH*2)
+
+	sData, err := msm.GetSessionData(ctx, sId)
+
+	require.Nil(err)
+	require.Equal(&SessionData{
+		UserId:     1337,
+		Handle:     SessionHandle(sId),
+		ClientInfo: ClientInfo{Ip: "192.0.2.1", Device: "Laptop"},
+		CreatedAt:  clock.Now(),
+		LastSeenAt: clock.Now(),
+		ExpiresAt:  clock.Now().Add(24 * time.Hour),
+	}, sData)
+
+	// Returned data is a copy
+	sData.UserId = 1
+	sData, err = msm.GetSessionData(ctx, sId)
+
+	require.Nil(err)
+	require.Equal(uint32(1337), sData.UserId)
+}
+
+func TestMemorySessionManagerExpiry(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	msm, clock := newTestMemorySessionManager()
+	ctx := context.Background()
+
+	sId, err := msm.CreateSession(ctx, &SessionData{UserId: 1337})
+
+	require.Nil(err)
+
+	// Renewal extends idle timeout and marks session as seen
+	clock.Advance(20 * time.Hour)
+	require.Nil(msm.RenewalSession(ctx, sId))
+
+	sData, err := msm.GetSessionData(ctx, sId)
+
+	require.Nil(err)
+	require.Equal(clock.Now(), sData.LastSeenAt)
+	require.Equal(clock.Now().Add(24*time.Hour), sData.ExpiresAt)
+
+	// Renewal doesn't extend session past its lifetime
+	for range 34 {
+		clock.Advance(20 * time.Hour)
+		require.Nil(msm.RenewalSession(ctx, sId))
+	}
+
+	sData, err = msm.GetSessionData(ctx, sId)
+
+	require.Nil(err)
+	require.Equal(sData.CreatedAt.Add(30*24*time.Hour), sData.ExpiresAt)
+
+	clock.Advance(sData.ExpiresAt.Sub(clock.Now()))
+
+	_, err = msm.GetSessionData(ctx, sId)
+
+	require.ErrorIs(err, ErrSessionNotFound)
+	require.ErrorIs(msm.RenewalSession(ctx, sId), ErrSessionNotFound)
+	require.ErrorIs(msm.DeleteSession(ctx, sId), ErrSessionNotFound)
+}
+
+func TestMemorySessionManagerDeleteSession(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	msm, _ := newTestMemorySessionManager()
+	ctx := context.Background()
+
+	sId, err := msm.CreateSession(ctx, &SessionData{UserId: 1337})
+
+	require.Nil(err)
+	require.Nil(msm.DeleteSession(ctx, sId))
+
+	_, err = msm.GetSessionData(ctx, sId)
+
+	require.ErrorIs(err, ErrSessionNotFound)
+	require.ErrorIs(msm.DeleteSession(ctx, sId), ErrSessionNotFound)
+
+	sessions, err := msm.ListSessions(ctx, 1337)
+
+	require.Nil(err)
+	require.Empty(sessions)
+}
+
+func TestMemorySessionManagerListSessions(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	msm, clock := newTestMemorySessionManager()
+	ctx := context.Background()
+
+	expiredSId, err := msm.CreateSession(ctx, &SessionData{UserId: 1337})
+	require.Nil(err)
+	clock.Advance(12 * time.Hour)
+	olderSId, err := msm.CreateSession(ctx, &SessionData{UserId: 1337})
+	require.Nil(err)
+	clock.Advance(time.Hour)
+	newerSId, err := msm.CreateSession(ctx, &SessionData{UserId: 1337})
+	require.Nil(err)
+	_, err = msm.CreateSession(ctx, &SessionData{UserId: 1336})
+	require.Nil(err)
+
+	clock.Advance(12 * time.Hour)
+
+	sessions, err := msm.ListSessions(ctx, 1337)
+
+	require.Nil(err)
+	require.Len(sessions, 2)
+	require.Equal(SessionHandle(newerSId), sessions[0].Handle)
+	require.Equal(SessionHandle(olderSId), sessions[1].Handle)
+	require.NotContains(
+		[]string{sessions[0].Handle, sessions[1].Handle},
+		SessionHandle(expiredSId),
+	)
+}
+
+func TestMemorySessionManagerRevokeSession(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	msm, _ := newTestMemorySessionManager()
+	ctx := context.Background()
+
+	sId, err := msm.CreateSession(ctx, &SessionData{UserId: 1337})
+	require.Nil(err)
+	otherSId, err := msm.CreateSession(ctx, &SessionData{UserId: 1337})
+	require.Nil(err)
+
+	// Sessions of other users can't be revoked
+	require.ErrorIs(
+		msm.RevokeSession(ctx, 1336, SessionHandle(otherSId)),
+		ErrSessionHandleNotFound,
+	)
+	require.Nil(msm.RevokeSession(ctx, 1337, SessionHandle(otherSId)))
+	require.ErrorIs(
+		msm.RevokeSession(ctx, 1337, SessionHandle(otherSId)),
+		ErrSessionHandleNotFound,
+	)
+
+	_, err = msm.GetSessionData(ctx, otherSId)
+	require.ErrorIs(err, ErrSessionNotFound)
+	_, err = msm.GetSessionData(ctx, sId)
+	require.Nil(err)
+}
+
+func TestMemorySessionManagerResetSessions(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	msm, _ := newTestMemorySessionManager()
+	ctx := context.Background()
+
+	sIds := make([]string, 3)
+
+	for index := range sIds {
+		sId, err := msm.CreateSession(ctx, &SessionData{UserId: 1337})
+
+		require.Nil(err)
+		sIds[index] = sId
+	}
+
+	otherUserSId, err := msm.CreateSession(ctx, &SessionData{UserId: 1336})
+
+	require.Nil(err)
+	require.Nil(msm.ResetOtherSessions(ctx, 1337, sIds[1]))
+
+	sessions, err := msm.ListSessions(ctx, 1337)
+
+	require.Nil(err)
+	require.Len(sessions, 1)
+	require.Equal(SessionHandle(sIds[1]), sessions[0].Handle)
+
+	require.Nil(msm.ResetSessions(ctx, 1337))
+
+	sessions, err = msm.ListSessions(ctx, 1337)
+
+	require.Nil(err)
+	require.Empty(sessions)
+
+	_, err = msm.GetSessionData(ctx, otherUserSId)
+
+	require.Nil(err)
+}
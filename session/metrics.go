@@ -0,0 +1,16 @@
+package session
+
+import "expvar"
+
+// Session counters published with expvar under "session" key
+
+const (
+	// Generated sId was already taken
+	SESSION_ID_COLLISIONS_METRIC = "id_collisions"
+	// CreateSession gave up with ErrSessionIdCollision
+	SESSION_ID_ATTEMPTS_EXHAUSTED_METRIC = "id_attempts_exhausted"
+	// Session token had invalid format, unknown key or wrong tag
+	SESSION_TOKEN_REJECTIONS_METRIC = "token_rejections"
+)
+
+var metrics = expvar.NewMap("session")
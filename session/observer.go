@@ -0,0 +1,37 @@
+package session
+
+import "context"
+
+// Receives session lifecycle events once the change is stored. Methods are
+// called synchronously by the manager, so slow work like sending emails must
+// be offloaded. Sessions expiring on their own are not reported
+type SessionObserver interface {
+	// sData is filled the same way GetSessionData fills it
+	OnCreated(ctx context.Context, sData *SessionData)
+	OnRenewed(ctx context.Context, userId uint32, handle string)
+	// Session was deleted, revoked or evicted by sessions limit
+	OnDeleted(ctx context.Context, userId uint32, handle string)
+	// Every session of the user except the kept one was deleted, keptHandle
+	// is empty when no session was kept
+	OnReset(ctx context.Context, userId uint32, keptHandle string)
+}
+
+// Ignores every event, embed it to implement only some of the methods
+type NopSessionObserver struct{}
+
+func (NopSessionObserver) OnCreated(ctx context.Context, sData *SessionData) {}
+
+func (NopSessionObserver) OnRenewed(ctx context.Context, userId uint32, handle string) {}
+
+func (NopSessionObserver) OnDeleted(ctx context.Context, userId uint32, handle string) {}
+
+func (NopSessionObserver) OnReset(ctx context.Context, userId uint32, keptHandle string) {}
+
+// Handle of kept session, empty when there is none
+func keptSessionHandle(keptSId string) string {
+	if keptSId == "" {
+		return ""
+	}
+
+	return SessionHandle(keptSId)
+}
@@ -0,0 +1,470 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"finanstar/server/apperror"
+	utils_pgx "finanstar/server/utils"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Implementation of session manager using PostgreSQL sessions table, for
+// deployments without Dragonfly. Expired rows are invisible to reads and are
+// deleted by the sweeper
+
+const (
+	SESSION_SWEEP_INTERVAL = time.Hour
+	// First key of transaction level advisory lock serializing creation of
+	// sessions of the same user, the second key is user id
+	SESSION_LIMIT_ADVISORY_LOCK_CLASS = 0x73657373
+)
+
+const sessionColumns = `user_id, handle, ip, user_agent, device, pending_second_factor, created_at, last_seen_at, expires_at`
+
+type PostgresqlSessionManagerOptions struct {
+	ExpirationOptions
+	LimitOptions
+	// How often RunSweeper deletes expired sessions
+	SweepInterval time.Duration
+	// Defaults to SystemClock
+	Clock Clock
+	// Defaults to NopSessionObserver. When ctx carries transaction, events
+	// are reported before it is committed
+	Observer SessionObserver
+}
+
+func NewPostgresqlSessionManager(
+	db utils_pgx.PgxPoolIface,
+	options *PostgresqlSessionManagerOptions,
+) *PostgresqlSessionManager {
+	psm := &PostgresqlSessionManager{
+		db:        db,
+		txManager: utils_pgx.NewTxManager(db, nil),
+	}
+
+	if options != nil {
+		psm.options = *options
+	}
+
+	psm.options.ExpirationOptions = psm.options.ExpirationOptions.WithDefaults()
+	psm.options.LimitOptions = psm.options.LimitOptions.WithDefaults()
+
+	if psm.options.SweepInterval <= 0 {
+		psm.options.SweepInterval = SESSION_SWEEP_INTERVAL
+	}
+
+	if psm.options.Clock == nil {
+		psm.options.Clock = SystemClock
+	}
+
+	if psm.options.Observer == nil {
+		psm.options.Observer = NopSessionObserver{}
+	}
+
+	return psm
+}
+
+type PostgresqlSessionManager struct {
+	db        utils_pgx.PgxPoolIface
+	txManager *utils_pgx.TxManager
+	options   PostgresqlSessionManagerOptions
+}
+
+func (psm *PostgresqlSessionManager) CreateSession(
+	ctx context.Context,
+	sData *SessionData,
+) (string, error) {
+	now := psm.options.Clock.Now()
+
+	var (
+		sId         string
+		evictedSIds []string
+		err         error
+	)
+
+	// Pending sessions don't count towards the limit
+	if psm.options.MaxSessions <= 0 || sData.PendingSecondFactor {
+		sId, err = psm.insertSession(ctx, sData, now)
+	} else {
+		err = psm.txManager.RunInTx(ctx, func(ctx context.Context) error {
+			evictedSIds, err = psm.makeRoom(ctx, sData.UserId, now)
+
+			if err != nil {
+				return err
+			}
+
+			sId, err = psm.insertSession(ctx, sData, now)
+
+			return err
+		})
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	for _, evictedSId := range evictedSIds {
+		psm.options.Observer.OnDeleted(ctx, sData.UserId, SessionHandle(evictedSId))
+	}
+
+	psm.options.Observer.OnCreated(ctx, &SessionData{
+		UserId:              sData.UserId,
+		Handle:              SessionHandle(sId),
+		ClientInfo:          sData.ClientInfo,
+		PendingSecondFactor: sData.PendingSecondFactor,
+		CreatedAt:           now,
+		LastSeenAt:          now,
+		ExpiresAt:           psm.options.sessionExpiresAt(sData.PendingSecondFactor, now, now),
+	})
+
+	return sId, nil
+}
+
+func (psm *PostgresqlSessionManager) insertSession(
+	ctx context.Context,
+	sData *SessionData,
+	now time.Time,
+) (string, error) {
+	expiresAt := psm.options.sessionExpiresAt(sData.PendingSecondFactor, now, now)
+
+	// Ensuring that sId will saved only if it is unique
+	return createWithUniqueId(ctx, func(sId string) (bool, error) {
+		tag, err := utils_pgx.QuerierFromContext(ctx, psm.db).Exec(
+			ctx,
+			`INSERT INTO sessions (id, `+sessionColumns+`)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8, $9)
+			ON CONFLICT (id) DO NOTHING;`,
+			sId,
+			sData.UserId,
+			SessionHandle(sId),
+			sData.Ip,
+			sData.UserAgent,
+			sData.Device,
+			sData.PendingSecondFactor,
+			now,
+			expiresAt,
+		)
+
+		if err != nil {
+			return false, err
+		}
+
+		return tag.RowsAffected() != 0, nil
+	})
+}
+
+// Makes room for one more session of the user according to LimitOptions,
+// returns sIds of evicted sessions. Pending sessions aren't counted. Must be
+// called in transaction, it holds the user lock till commit
+func (psm *PostgresqlSessionManager) makeRoom(
+	ctx context.Context,
+	userId uint32,
+	now time.Time,
+) ([]string, error) {
+	querier := utils_pgx.QuerierFromContext(ctx, psm.db)
+	_, err := querier.Exec(
+		ctx,
+		`SELECT pg_advisory_xact_lock($1, $2);`,
+		int32(SESSION_LIMIT_ADVISORY_LOCK_CLASS),
+		int32(userId),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var count int
+
+	err = querier.QueryRow(
+		ctx,
+		`SELECT count(*) FROM sessions
+		WHERE user_id = $1 AND expires_at > $2 AND NOT pending_second_factor;`,
+		userId,
+		now,
+	).Scan(&count)
+
+	if err != nil {
+		return nil, err
+	}
+
+	excess := count - psm.options.MaxSessions + 1
+
+	if excess <= 0 {
+		return nil, nil
+	}
+
+	if psm.options.LimitPolicy == SESSION_LIMIT_POLICY_REJECT {
+		return nil, ErrSessionLimitReached
+	}
+
+	rows, err := querier.Query(
+		ctx,
+		`DELETE FROM sessions WHERE id IN (
+			SELECT id FROM sessions
+			WHERE user_id = $1 AND expires_at > $2 AND NOT pending_second_factor
+			ORDER BY last_seen_at LIMIT $3
+		) RETURNING id;`,
+		userId,
+		now,
+		excess,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+func (psm *PostgresqlSessionManager) DeleteSession(
+	ctx context.Context,
+	sId string,
+) error {
+	var userId uint32
+
+	err := utils_pgx.QuerierFromContext(ctx, psm.db).QueryRow(
+		ctx,
+		`DELETE FROM sessions WHERE id = $1 AND expires_at > $2
+		RETURNING user_id;`,
+		sId,
+		psm.options.Clock.Now(),
+	).Scan(&userId)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return apperror.Wrap(ErrSessionNotFound, err)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	psm.options.Observer.OnDeleted(ctx, userId, SessionHandle(sId))
+
+	return nil
+}
+
+// Extends session by idle timeout, but not past its lifetime, and marks it
+// as seen now. Pending session keeps its expiry
+func (psm *PostgresqlSessionManager) RenewalSession(
+	ctx context.Context,
+	sId string,
+) error {
+	now := psm.options.Clock.Now()
+
+	var userId uint32
+
+	err := utils_pgx.QuerierFromContext(ctx, psm.db).QueryRow(
+		ctx,
+		`UPDATE sessions
+		SET last_seen_at = $2, expires_at = CASE
+			WHEN pending_second_factor THEN expires_at
+			ELSE LEAST($3, created_at + $4::interval)
+		END
+		WHERE id = $1 AND expires_at > $2 AND created_at + $4::interval > $2
+		RETURNING user_id;`,
+		sId,
+		now,
+		now.Add(psm.options.IdleTimeout),
+		psm.options.Lifetime,
+	).Scan(&userId)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return apperror.Wrap(ErrSessionNotFound, err)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	psm.options.Observer.OnRenewed(ctx, userId, SessionHandle(sId))
+
+	return nil
+}
+
+func (psm *PostgresqlSessionManager) GetSessionData(
+	ctx context.Context,
+	sId string,
+) (*SessionData, error) {
+	row := utils_pgx.QuerierFromContext(ctx, psm.db).QueryRow(
+		ctx,
+		`SELECT `+sessionColumns+` FROM sessions
+		WHERE id = $1 AND expires_at > $2;`,
+		sId,
+		psm.options.Clock.Now(),
+	)
+	sData, err := scanSession(row)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, apperror.Wrap(ErrSessionNotFound, err)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return sData, nil
+}
+
+func (psm *PostgresqlSessionManager) ListSessions(
+	ctx context.Context,
+	userId uint32,
+) ([]SessionData, error) {
+	rows, err := utils_pgx.QuerierFromContext(ctx, psm.db).Query(
+		ctx,
+		`SELECT `+sessionColumns+` FROM sessions
+		WHERE user_id = $1 AND expires_at > $2 AND NOT pending_second_factor
+		ORDER BY last_seen_at DESC;`,
+		userId,
+		psm.options.Clock.Now(),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	sessions := []SessionData{}
+
+	for rows.Next() {
+		sData, err := scanSession(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, *sData)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+func (psm *PostgresqlSessionManager) RevokeSession(
+	ctx context.Context,
+	userId uint32,
+	handle string,
+) error {
+	tag, err := utils_pgx.QuerierFromContext(ctx, psm.db).Exec(
+		ctx,
+		`DELETE FROM sessions
+		WHERE user_id = $1 AND handle = $2 AND expires_at > $3;`,
+		userId,
+		handle,
+		psm.options.Clock.Now(),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrSessionHandleNotFound
+	}
+
+	psm.options.Observer.OnDeleted(ctx, userId, handle)
+
+	return nil
+}
+
+func (psm *PostgresqlSessionManager) ResetOtherSessions(
+	ctx context.Context,
+	userId uint32,
+	keptSId string,
+) error {
+	_, err := utils_pgx.QuerierFromContext(ctx, psm.db).Exec(
+		ctx,
+		`DELETE FROM sessions WHERE user_id = $1 AND id <> $2;`,
+		userId,
+		keptSId,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	psm.options.Observer.OnReset(ctx, userId, keptSessionHandle(keptSId))
+
+	return nil
+}
+
+func (psm *PostgresqlSessionManager) ResetSessions(
+	ctx context.Context,
+	userId uint32,
+) error {
+	_, err := utils_pgx.QuerierFromContext(ctx, psm.db).Exec(
+		ctx,
+		`DELETE FROM sessions WHERE user_id = $1;`,
+		userId,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	psm.options.Observer.OnReset(ctx, userId, "")
+
+	return nil
+}
+
+// Deletes expired sessions, returns how many were deleted
+func (psm *PostgresqlSessionManager) SweepExpiredSessions(
+	ctx context.Context,
+) (int64, error) {
+	tag, err := psm.db.Exec(
+		ctx,
+		`DELETE FROM sessions WHERE expires_at <= $1;`,
+		psm.options.Clock.Now(),
+	)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// Sweeps expired sessions every SweepInterval until ctx is done
+func (psm *PostgresqlSessionManager) RunSweeper(ctx context.Context) {
+	ticker := time.NewTicker(psm.options.SweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := psm.SweepExpiredSessions(ctx); err != nil {
+				log.Printf("Failed to sweep expired sessions: %v", err)
+			}
+		}
+	}
+}
+
+func scanSession(row pgx.Row) (*SessionData, error) {
+	var sData SessionData
+
+	err := row.Scan(
+		&sData.UserId,
+		&sData.Handle,
+		&sData.Ip,
+		&sData.UserAgent,
+		&sData.Device,
+		&sData.PendingSecondFactor,
+		&sData.CreatedAt,
+		&sData.LastSeenAt,
+		&sData.ExpiresAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &sData, nil
+}
@@ -0,0 +1,421 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPostgresqlSessionManager(
+	t *testing.T,
+) (*PostgresqlSessionManager, pgxmock.PgxPoolIface, *ManualClock) {
+	db, err := pgxmock.NewPool()
+
+	require.Nil(t, err)
+
+	clock := NewManualClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	psm := NewPostgresqlSessionManager(db, &PostgresqlSessionManagerOptions{
+		ExpirationOptions: ExpirationOptions{
+			Lifetime:    30 * 24 * time.Hour,
+			IdleTimeout: 24 * time.Hour,
+		},
+		Clock: clock,
+	})
+
+	return psm, db, clock
+}
+
+func TestPostgresqlCreateSession(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	psm, db, clock := newTestPostgresqlSessionManager(t)
+	now := clock.Now()
+	args := []any{
+		pgxmock.AnyArg(),
+		uint32(1337),
+		pgxmock.AnyArg(),
+		`192.0.2.1`,
+		`curl/8.0`,
+		`Laptop`,
+		false,
+		now,
+		now.Add(24 * time.Hour),
+	}
+
+	// First generated sId collides with existing one
+	db.
+		ExpectExec(`INSERT INTO sessions .* ON CONFLICT \(id\) DO NOTHING;`).
+		WithArgs(args...).
+		WillReturnResult(pgxmock.NewResult(`INSERT`, 0))
+	db.
+		ExpectExec(`INSERT INTO sessions .* ON CONFLICT \(id\) DO NOTHING;`).
+		WithArgs(args...).
+		WillReturnResult(pgxmock.NewResult(`INSERT`, 1))
+
+	sId, err := psm.CreateSession(context.Background(), &SessionData{
+		UserId: 1337,
+		ClientInfo: ClientInfo{
+			Ip:        `192.0.2.1`,
+			UserAgent: `curl/8.0`,
+			Device:    `Laptop`,
+		},
+	})
+
+	require.Nil(err)
+	require.Len(sId, SESSION_ID_LENGTH*2)
+	require.Nil(db.ExpectationsWereMet())
+}
+
+func TestPostgresqlCreateSessionLimit(t *testing.T) {
+	t.Parallel()
+
+	subtests := []struct {
+		name     string
+		policy   string
+		sessions int
+		evicted  int
+		error    error
+	}{
+		{`CreatesUnderLimit`, SESSION_LIMIT_POLICY_REJECT, 1, 0, nil},
+		{`RejectsAtLimit`, SESSION_LIMIT_POLICY_REJECT, 2, 0, ErrSessionLimitReached},
+		{`EvictsAtLimit`, SESSION_LIMIT_POLICY_EVICT, 2, 1, nil},
+		{`EvictsOverLimit`, SESSION_LIMIT_POLICY_EVICT, 4, 3, nil},
+	}
+
+	for _, test := range subtests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+			db, err := pgxmock.NewPool()
+
+			require.Nil(err)
+
+			clock := NewManualClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+			psm := NewPostgresqlSessionManager(db, &PostgresqlSessionManagerOptions{
+				LimitOptions: LimitOptions{MaxSessions: 2, LimitPolicy: test.policy},
+				Clock:        clock,
+			})
+
+			db.ExpectBegin()
+			db.
+				ExpectExec(`SELECT pg_advisory_xact_lock\(\$1, \$2\);`).
+				WithArgs(int32(SESSION_LIMIT_ADVISORY_LOCK_CLASS), int32(1337)).
+				WillReturnResult(pgxmock.NewResult(`SELECT`, 1))
+			db.
+				ExpectQuery(`SELECT count\(\*\) FROM sessions .* AND NOT pending_second_factor;`).
+				WithArgs(uint32(1337), clock.Now()).
+				WillReturnRows(db.NewRows([]string{`count`}).AddRow(test.sessions))
+
+			if test.evicted != 0 {
+				evictedRows := db.NewRows([]string{`id`})
+
+				for index := range test.evicted {
+					evictedRows.AddRow(fmt.Sprintf(`evicted%d`, index))
+				}
+
+				db.
+					ExpectQuery(`DELETE FROM sessions WHERE id IN`).
+					WithArgs(uint32(1337), clock.Now(), test.evicted).
+					WillReturnRows(evictedRows)
+			}
+
+			if test.error != nil {
+				db.ExpectRollback()
+			} else {
+				db.
+					ExpectExec(`INSERT INTO sessions`).
+					WithArgs(
+						pgxmock.AnyArg(),
+						uint32(1337),
+						pgxmock.AnyArg(),
+						``,
+						``,
+						``,
+						false,
+						clock.Now(),
+						clock.Now().Add(SESSION_IDLE_TIMEOUT),
+					).
+					WillReturnResult(pgxmock.NewResult(`INSERT`, 1))
+				db.ExpectCommit()
+			}
+
+			_, err = psm.CreateSession(
+				context.Background(),
+				&SessionData{UserId: 1337},
+			)
+
+			if test.error != nil {
+				require.ErrorIs(err, test.error)
+			} else {
+				require.Nil(err)
+			}
+
+			require.Nil(db.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestPostgresqlCreatePendingSession(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	db, err := pgxmock.NewPool()
+
+	require.Nil(err)
+
+	clock := NewManualClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	psm := NewPostgresqlSessionManager(db, &PostgresqlSessionManagerOptions{
+		LimitOptions: LimitOptions{MaxSessions: 1},
+		Clock:        clock,
+	})
+
+	// Neither counted nor making room, expires after PENDING_SESSION_TIMEOUT
+	db.
+		ExpectExec(`INSERT INTO sessions`).
+		WithArgs(
+			pgxmock.AnyArg(),
+			uint32(1337),
+			pgxmock.AnyArg(),
+			``,
+			``,
+			``,
+			true,
+			clock.Now(),
+			clock.Now().Add(PENDING_SESSION_TIMEOUT),
+		).
+		WillReturnResult(pgxmock.NewResult(`INSERT`, 1))
+
+	_, err = psm.CreateSession(
+		context.Background(),
+		&SessionData{UserId: 1337, PendingSecondFactor: true},
+	)
+
+	require.Nil(err)
+	require.Nil(db.ExpectationsWereMet())
+}
+
+func TestPostgresqlDeleteSession(t *testing.T) {
+	t.Parallel()
+
+	subtests := []struct {
+		name  string
+		error error
+	}{
+		{`DeletesSession`, nil},
+		{`ReturnsSessionNotFound`, ErrSessionNotFound},
+	}
+
+	for _, test := range subtests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+			psm, db, clock := newTestPostgresqlSessionManager(t)
+
+			rows := db.NewRows([]string{`user_id`})
+
+			if test.error == nil {
+				rows.AddRow(uint32(1337))
+			}
+
+			db.
+				ExpectQuery(`DELETE FROM sessions WHERE id = \$1 AND expires_at > \$2`).
+				WithArgs(`sid`, clock.Now()).
+				WillReturnRows(rows)
+
+			err := psm.DeleteSession(context.Background(), `sid`)
+
+			if test.error != nil {
+				require.ErrorIs(err, test.error)
+			} else {
+				require.Nil(err)
+			}
+
+			require.Nil(db.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestPostgresqlRenewalSession(t *testing.T) {
+	t.Parallel()
+
+	subtests := []struct {
+		name  string
+		error error
+	}{
+		{`RenewsSession`, nil},
+		{`ReturnsSessionNotFound`, ErrSessionNotFound},
+	}
+
+	for _, test := range subtests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+			psm, db, clock := newTestPostgresqlSessionManager(t)
+			now := clock.Now()
+
+			rows := db.NewRows([]string{`user_id`})
+
+			if test.error == nil {
+				rows.AddRow(uint32(1337))
+			}
+
+			db.
+				ExpectQuery(`UPDATE sessions SET last_seen_at = \$2, expires_at = CASE`).
+				WithArgs(`sid`, now, now.Add(24*time.Hour), 30*24*time.Hour).
+				WillReturnRows(rows)
+
+			err := psm.RenewalSession(context.Background(), `sid`)
+
+			if test.error != nil {
+				require.ErrorIs(err, test.error)
+			} else {
+				require.Nil(err)
+			}
+
+			require.Nil(db.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestPostgresqlGetSessionData(t *testing.T) {
+	t.Parallel()
+
+	columns := []string{
+		`user_id`,
+		`handle`,
+		`ip`,
+		`user_agent`,
+		`device`,
+		`pending_second_factor`,
+		`created_at`,
+		`last_seen_at`,
+		`expires_at`,
+	}
+
+	t.Run(`ReturnsSession`, func(t *testing.T) {
+		require := require.New(t)
+		psm, db, clock := newTestPostgresqlSessionManager(t)
+		now := clock.Now()
+
+		db.
+			ExpectQuery(`SELECT .* FROM sessions WHERE id = \$1 AND expires_at > \$2;`).
+			WithArgs(`sid`, now).
+			WillReturnRows(
+				db.NewRows(columns).AddRow(
+					uint32(1337),
+					SessionHandle(`sid`),
+					`192.0.2.1`,
+					`curl/8.0`,
+					`Laptop`,
+					true,
+					now.Add(-time.Hour),
+					now,
+					now.Add(24*time.Hour),
+				),
+			)
+
+		sData, err := psm.GetSessionData(context.Background(), `sid`)
+
+		require.Nil(err)
+		require.Equal(&SessionData{
+			UserId: 1337,
+			Handle: SessionHandle(`sid`),
+			ClientInfo: ClientInfo{
+				Ip:        `192.0.2.1`,
+				UserAgent: `curl/8.0`,
+				Device:    `Laptop`,
+			},
+			PendingSecondFactor: true,
+			CreatedAt:           now.Add(-time.Hour),
+			LastSeenAt:          now,
+			ExpiresAt:           now.Add(24 * time.Hour),
+		}, sData)
+		require.Nil(db.ExpectationsWereMet())
+	})
+
+	t.Run(`ReturnsSessionNotFound`, func(t *testing.T) {
+		require := require.New(t)
+		psm, db, clock := newTestPostgresqlSessionManager(t)
+
+		db.
+			ExpectQuery(`SELECT .* FROM sessions WHERE id = \$1 AND expires_at > \$2;`).
+			WithArgs(`sid`, clock.Now()).
+			WillReturnRows(db.NewRows(columns))
+
+		_, err := psm.GetSessionData(context.Background(), `sid`)
+
+		require.ErrorIs(err, ErrSessionNotFound)
+		require.Nil(db.ExpectationsWereMet())
+	})
+}
+
+func TestPostgresqlRevokeSession(t *testing.T) {
+	t.Parallel()
+
+	subtests := []struct {
+		name         string
+		rowsAffected int64
+		error        error
+	}{
+		{`RevokesSession`, 1, nil},
+		{`ReturnsSessionHandleNotFound`, 0, ErrSessionHandleNotFound},
+	}
+
+	for _, test := range subtests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+			psm, db, clock := newTestPostgresqlSessionManager(t)
+
+			db.
+				ExpectExec(`DELETE FROM sessions WHERE user_id = \$1 AND handle = \$2`).
+				WithArgs(uint32(1337), `handle`, clock.Now()).
+				WillReturnResult(pgxmock.NewResult(`DELETE`, test.rowsAffected))
+
+			err := psm.RevokeSession(context.Background(), 1337, `handle`)
+
+			if test.error != nil {
+				require.ErrorIs(err, test.error)
+			} else {
+				require.Nil(err)
+			}
+
+			require.Nil(db.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestPostgresqlResetSessions(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	psm, db, _ := newTestPostgresqlSessionManager(t)
+
+	db.
+		ExpectExec(`DELETE FROM sessions WHERE user_id = \$1 AND id <> \$2;`).
+		WithArgs(uint32(1337), `sid`).
+		WillReturnResult(pgxmock.NewResult(`DELETE`, 2))
+	db.
+		ExpectExec(`DELETE FROM sessions WHERE user_id = \$1;`).
+		WithArgs(uint32(1337)).
+		WillReturnResult(pgxmock.NewResult(`DELETE`, 1))
+
+	require.Nil(psm.ResetOtherSessions(context.Background(), 1337, `sid`))
+	require.Nil(psm.ResetSessions(context.Background(), 1337))
+	require.Nil(db.ExpectationsWereMet())
+}
+
+func TestPostgresqlSweepExpiredSessions(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	psm, db, clock := newTestPostgresqlSessionManager(t)
+
+	db.
+		ExpectExec(`DELETE FROM sessions WHERE expires_at <= \$1;`).
+		WithArgs(clock.Now()).
+		WillReturnResult(pgxmock.NewResult(`DELETE`, 3))
+
+	deleted, err := psm.SweepExpiredSessions(context.Background())
+
+	require.Nil(err)
+	require.Equal(int64(3), deleted)
+	require.Nil(db.ExpectationsWereMet())
+}
@@ -2,27 +2,203 @@ package session
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"finanstar/server/crypto"
 	"time"
 )
 
 const (
-	SESSION_TTL       = time.Duration(14*24) * time.Hour
-	SESSION_ID_LENGTH = 16
+	SESSION_LIFETIME     = time.Duration(90*24) * time.Hour
+	SESSION_IDLE_TIMEOUT = time.Duration(14*24) * time.Hour
+	SESSION_ID_LENGTH    = 16
+	// Length of session handle in bytes, it is hex encoded
+	SESSION_HANDLE_LENGTH = 8
+	// How many generated sIds CreateSession tries before giving up, each
+	// taken one is a collision
+	SESSION_ID_MAX_ATTEMPTS = 5
+	// How many times RenewalSession retries after session was changed
+	// concurrently before giving up
+	SESSION_RENEWAL_MAX_ATTEMPTS = 5
+	// Suggested cap of alive sessions per user, managers are unlimited by
+	// default
+	SESSION_MAX_SESSIONS = 20
+	// Session pending second factor expires this long after creation, it
+	// isn't renewed past it
+	PENDING_SESSION_TIMEOUT = 5 * time.Minute
 )
 
+// What CreateSession does when user already holds MaxSessions sessions
 const (
-	SESSION_NOT_FOUND_ERROR    = "There is no session with provided sId"
-	SESSION_DATA_INVALID_ERROR = "Associated data with sId is invalid"
+	SESSION_LIMIT_POLICY_REJECT = "reject"
+	// Deletes least recently seen sessions to make room for the new one
+	SESSION_LIMIT_POLICY_EVICT = "evict"
 )
 
+const (
+	SESSION_NOT_FOUND_ERROR        = "There is no session with provided sId"
+	SESSION_DATA_INVALID_ERROR     = "Associated data with sId is invalid"
+	SESSION_HANDLE_NOT_FOUND_ERROR = "User has no session with provided handle"
+	SESSION_ID_COLLISION_ERROR     = "Failed to generate unique sId"
+	SESSION_LIMIT_REACHED_ERROR    = "User has reached maximum number of sessions"
+	SESSION_RENEWAL_CONFLICT_ERROR = "Session is changed concurrently, try again"
+)
+
+var (
+	ErrSessionNotFound        = errors.New(SESSION_NOT_FOUND_ERROR)
+	ErrSessionDataInvalid     = errors.New(SESSION_DATA_INVALID_ERROR)
+	ErrSessionHandleNotFound  = errors.New(SESSION_HANDLE_NOT_FOUND_ERROR)
+	ErrSessionIdCollision     = errors.New(SESSION_ID_COLLISION_ERROR)
+	ErrSessionLimitReached    = errors.New(SESSION_LIMIT_REACHED_ERROR)
+	ErrSessionRenewalConflict = errors.New(SESSION_RENEWAL_CONFLICT_ERROR)
+)
+
+// Sessions pending second factor expire after PENDING_SESSION_TIMEOUT, they
+// neither count towards LimitOptions nor get evicted and aren't listed
 type SessionManager interface {
+	// Fails with ErrSessionLimitReached or evicts least recently seen
+	// sessions when user is at the limit, see LimitOptions
 	CreateSession(ctx context.Context, sData *SessionData) (string, error)
 	DeleteSession(ctx context.Context, sId string) error
 	RenewalSession(ctx context.Context, sId string) error
 	GetSessionData(ctx context.Context, sId string) (*SessionData, error)
 	ResetSessions(ctx context.Context, userId uint32) error
+	// Returns alive sessions of the user, most recently seen first
+	ListSessions(ctx context.Context, userId uint32) ([]SessionData, error)
+	// Deletes session of the user identified by its public handle
+	RevokeSession(ctx context.Context, userId uint32, handle string) error
+	// Deletes every session of the user except the one with keptSId
+	ResetOtherSessions(ctx context.Context, userId uint32, keptSId string) error
+}
+
+type ClientInfo struct {
+	Ip        string
+	UserAgent string
+	// Human readable device label, e.g. "Firefox on Linux"
+	Device string
 }
 
 type SessionData struct {
 	UserId uint32
+	// Public identifier of the session, see SessionHandle
+	Handle string
+	ClientInfo
+	// Password was verified, but second factor wasn't yet. Such session only
+	// lets the client confirm the second factor
+	PendingSecondFactor bool
+	// Filled by SessionManager on read, ignored on create
+	CreatedAt time.Time
+	// Updated on session renewal
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+}
+
+// Derives public session identifier from sId. Unlike sId it is safe to show
+// it to other sessions of the user, sId can't be restored from it
+func SessionHandle(sId string) string {
+	sum := sha256.Sum256([]byte(sId))
+
+	return hex.EncodeToString(sum[:SESSION_HANDLE_LENGTH])
+}
+
+type ExpirationOptions struct {
+	// Maximum session age, session can't be renewed past it
+	Lifetime time.Duration
+	// Session expires when it isn't renewed for this duration
+	IdleTimeout time.Duration
+}
+
+// Fills unset options with SESSION_LIFETIME and SESSION_IDLE_TIMEOUT
+func (self ExpirationOptions) WithDefaults() ExpirationOptions {
+	if self.Lifetime <= 0 {
+		self.Lifetime = SESSION_LIFETIME
+	}
+
+	if self.IdleTimeout <= 0 {
+		self.IdleTimeout = SESSION_IDLE_TIMEOUT
+	}
+
+	return self
+}
+
+// Moment when session created at createdAt expires if it is renewed at now
+func (self ExpirationOptions) ExpiresAt(createdAt, now time.Time) time.Time {
+	idleExpiresAt := now.Add(self.IdleTimeout)
+	maxExpiresAt := createdAt.Add(self.Lifetime)
+
+	if idleExpiresAt.After(maxExpiresAt) {
+		return maxExpiresAt
+	}
+
+	return idleExpiresAt
+}
+
+// ExpiresAt which keeps pending session within PENDING_SESSION_TIMEOUT
+func (self ExpirationOptions) sessionExpiresAt(
+	pending bool,
+	createdAt time.Time,
+	now time.Time,
+) time.Time {
+	expiresAt := self.ExpiresAt(createdAt, now)
+	pendingExpiresAt := createdAt.Add(PENDING_SESSION_TIMEOUT)
+
+	if pending && pendingExpiresAt.Before(expiresAt) {
+		return pendingExpiresAt
+	}
+
+	return expiresAt
+}
+
+// Caps how many alive sessions a user can hold at once
+type LimitOptions struct {
+	// Zero means unlimited
+	MaxSessions int
+	// One of SESSION_LIMIT_POLICY_* constants
+	LimitPolicy string
+}
+
+// Fills unset policy with SESSION_LIMIT_POLICY_EVICT
+func (self LimitOptions) WithDefaults() LimitOptions {
+	if self.LimitPolicy == "" {
+		self.LimitPolicy = SESSION_LIMIT_POLICY_EVICT
+	}
+
+	return self
+}
+
+// Generates sIds until store saves one, store returns false if sId is taken.
+// Gives up with ErrSessionIdCollision after SESSION_ID_MAX_ATTEMPTS
+// collisions or with ctx error once ctx is done
+func createWithUniqueId(
+	ctx context.Context,
+	store func(sId string) (bool, error),
+) (string, error) {
+	for range SESSION_ID_MAX_ATTEMPTS {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+
+		sId, err := crypto.GenerateSecureId(SESSION_ID_LENGTH)
+
+		if err != nil {
+			return "", err
+		}
+
+		stored, err := store(sId)
+
+		if err != nil {
+			return "", err
+		}
+
+		if stored {
+			return sId, nil
+		}
+
+		metrics.Add(SESSION_ID_COLLISIONS_METRIC, 1)
+	}
+
+	metrics.Add(SESSION_ID_ATTEMPTS_EXHAUSTED_METRIC, 1)
+
+	return "", ErrSessionIdCollision
 }
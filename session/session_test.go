@@ -0,0 +1,155 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpirationOptionsExpiresAt(t *testing.T) {
+	t.Parallel()
+
+	options := ExpirationOptions{
+		Lifetime:    30 * 24 * time.Hour,
+		IdleTimeout: 24 * time.Hour,
+	}
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	testVariants := []struct {
+		title     string
+		now       time.Time
+		expiresAt time.Time
+	}{
+		{"Fresh session", createdAt, createdAt.Add(24 * time.Hour)},
+		{
+			"Active session",
+			createdAt.Add(10 * 24 * time.Hour),
+			createdAt.Add(11 * 24 * time.Hour),
+		},
+		{
+			"Session close to its lifetime",
+			createdAt.Add(29*24*time.Hour + 12*time.Hour),
+			createdAt.Add(30 * 24 * time.Hour),
+		},
+	}
+
+	for _, tt := range testVariants {
+		t.Run(tt.title, func(t *testing.T) {
+			require.Equal(t, tt.expiresAt, options.ExpiresAt(createdAt, tt.now))
+		})
+	}
+}
+
+func TestExpirationOptionsWithDefaults(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	options := ExpirationOptions{IdleTimeout: time.Hour}.WithDefaults()
+
+	require.Equal(SESSION_LIFETIME, options.Lifetime)
+	require.Equal(time.Hour, options.IdleTimeout)
+}
+
+func TestSessionHandle(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	handle := SessionHandle("0123456789abcdef")
+
+	require.Len(handle, 2*SESSION_HANDLE_LENGTH)
+	require.Equal(handle, SessionHandle("0123456789abcdef"))
+	require.NotEqual(handle, SessionHandle("fedcba9876543210"))
+	require.NotContains("0123456789abcdef", handle)
+}
+
+func metricValue(name string) int64 {
+	if value, ok := metrics.Get(name).(*expvar.Int); ok {
+		return value.Value()
+	}
+
+	return 0
+}
+
+// Not parallel, as metrics are shared by the package
+func TestCreateWithUniqueId(t *testing.T) {
+	t.Run("Retries taken sId", func(t *testing.T) {
+		require := require.New(t)
+		collisions := metricValue(SESSION_ID_COLLISIONS_METRIC)
+		taken := ""
+
+		sId, err := createWithUniqueId(
+			context.Background(),
+			func(sId string) (bool, error) {
+				if taken == "" {
+					taken = sId
+					return false, nil
+				}
+
+				return true, nil
+			},
+		)
+
+		require.Nil(err)
+		require.Len(sId, SESSION_ID_LENGTH*2)
+		require.NotEqual(taken, sId)
+		require.Equal(collisions+1, metricValue(SESSION_ID_COLLISIONS_METRIC))
+	})
+
+	t.Run("Gives up after max attempts", func(t *testing.T) {
+		require := require.New(t)
+		collisions := metricValue(SESSION_ID_COLLISIONS_METRIC)
+		exhausted := metricValue(SESSION_ID_ATTEMPTS_EXHAUSTED_METRIC)
+		attempts := 0
+
+		_, err := createWithUniqueId(
+			context.Background(),
+			func(sId string) (bool, error) {
+				attempts++
+				return false, nil
+			},
+		)
+
+		require.ErrorIs(err, ErrSessionIdCollision)
+		require.Equal(SESSION_ID_MAX_ATTEMPTS, attempts)
+		require.Equal(
+			collisions+SESSION_ID_MAX_ATTEMPTS,
+			metricValue(SESSION_ID_COLLISIONS_METRIC),
+		)
+		require.Equal(
+			exhausted+1,
+			metricValue(SESSION_ID_ATTEMPTS_EXHAUSTED_METRIC),
+		)
+	})
+
+	t.Run("Stops when context is done", func(t *testing.T) {
+		require := require.New(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		attempts := 0
+
+		_, err := createWithUniqueId(ctx, func(sId string) (bool, error) {
+			attempts++
+			cancel()
+			return false, nil
+		})
+
+		require.ErrorIs(err, context.Canceled)
+		require.Equal(1, attempts)
+	})
+
+	t.Run("Returns store error", func(t *testing.T) {
+		storeErr := errors.New("connection refused")
+
+		_, err := createWithUniqueId(
+			context.Background(),
+			func(sId string) (bool, error) {
+				return false, storeErr
+			},
+		)
+
+		require.ErrorIs(t, err, storeErr)
+	})
+}
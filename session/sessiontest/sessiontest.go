@@ -0,0 +1,678 @@
+package sessiontest
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"finanstar/server/session"
+)
+
+// Implementation agnostic conformance suite for session.SessionManager. Every
+// implementation is expected to pass it:
+//
+//	func TestConformance(t *testing.T) {
+//		sessiontest.Run(t, func(t *testing.T, options sessiontest.Options) sessiontest.Harness {
+//			...
+//		})
+//	}
+
+const (
+	// Storages may keep timestamps with coarser precision than time.Time
+	TIME_PRECISION = time.Millisecond
+	// How many sessions are created at once by concurrency cases
+	CONCURRENCY = 32
+)
+
+type Options struct {
+	session.ExpirationOptions
+	// Unlimited unless case sets it
+	session.LimitOptions
+	// Clock the manager must read time from, Harness.Advance moves it
+	Clock *session.ManualClock
+	// Observer the manager must report events to
+	Observer session.SessionObserver
+}
+
+type Harness struct {
+	Manager session.SessionManager
+	// Moves time forward for both Options.Clock and storage, so TTLs kept by
+	// storage run out. Defaults to advancing Options.Clock only
+	Advance func(duration time.Duration)
+}
+
+// Builds fresh manager with empty storage for every case
+type Factory func(t *testing.T, options Options) Harness
+
+type testCase struct {
+	name string
+	run  func(t *testing.T, h *harness)
+}
+
+// Case run against manager with sessions limit
+type limitTestCase struct {
+	testCase
+	limit session.LimitOptions
+}
+
+// Wraps Harness with helpers shared by cases. Assertions must be made from
+// the test goroutine only
+type harness struct {
+	Harness
+	*require.Assertions
+	ctx      context.Context
+	options  Options
+	recorder *recorder
+}
+
+type event struct {
+	kind   string
+	userId uint32
+	// Kept handle for reset events
+	handle     string
+	clientInfo session.ClientInfo
+}
+
+// Observer recording every event it receives
+type recorder struct {
+	mu     sync.Mutex
+	events []event
+}
+
+func (self *recorder) record(e event) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	self.events = append(self.events, e)
+}
+
+func (self *recorder) OnCreated(ctx context.Context, sData *session.SessionData) {
+	self.record(event{`created`, sData.UserId, sData.Handle, sData.ClientInfo})
+}
+
+func (self *recorder) OnRenewed(ctx context.Context, userId uint32, handle string) {
+	self.record(event{`renewed`, userId, handle, session.ClientInfo{}})
+}
+
+func (self *recorder) OnDeleted(ctx context.Context, userId uint32, handle string) {
+	self.record(event{`deleted`, userId, handle, session.ClientInfo{}})
+}
+
+func (self *recorder) OnReset(ctx context.Context, userId uint32, keptHandle string) {
+	self.record(event{`reset`, userId, keptHandle, session.ClientInfo{}})
+}
+
+// Returns events recorded since previous call
+func (self *recorder) flush() []event {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	events := self.events
+	self.events = nil
+
+	return events
+}
+
+func Run(t *testing.T, factory Factory) {
+	for _, test := range testCases {
+		runCase(t, factory, test, session.LimitOptions{})
+	}
+
+	for _, test := range limitTestCases {
+		runCase(t, factory, test.testCase, test.limit)
+	}
+}
+
+func runCase(
+	t *testing.T,
+	factory Factory,
+	test testCase,
+	limit session.LimitOptions,
+) {
+	t.Run(test.name, func(t *testing.T) {
+		recorder := &recorder{}
+		options := Options{
+			ExpirationOptions: session.ExpirationOptions{
+				Lifetime:    10 * time.Hour,
+				IdleTimeout: 4 * time.Hour,
+			},
+			LimitOptions: limit,
+			// Millisecond aligned, so storages with coarser precision
+			// return exactly the same timestamps
+			Clock: session.NewManualClock(
+				time.UnixMilli(time.Now().UnixMilli()),
+			),
+			Observer: recorder,
+		}
+		h := &harness{
+			Harness:    factory(t, options),
+			Assertions: require.New(t),
+			ctx:        context.Background(),
+			options:    options,
+			recorder:   recorder,
+		}
+
+		if h.Advance == nil {
+			h.Advance = options.Clock.Advance
+		}
+
+		test.run(t, h)
+	})
+}
+
+func (self *harness) create(userId uint32) string {
+	sId, err := self.Manager.CreateSession(
+		self.ctx,
+		&session.SessionData{UserId: userId},
+	)
+
+	self.Nil(err)
+
+	return sId
+}
+
+func (self *harness) requireAlive(sId string) *session.SessionData {
+	sData, err := self.Manager.GetSessionData(self.ctx, sId)
+
+	self.Nil(err)
+
+	return sData
+}
+
+func (self *harness) requireGone(sId string) {
+	_, err := self.Manager.GetSessionData(self.ctx, sId)
+
+	self.ErrorIs(err, session.ErrSessionNotFound)
+}
+
+func (self *harness) handles(userId uint32) []string {
+	sessions, err := self.Manager.ListSessions(self.ctx, userId)
+
+	self.Nil(err)
+
+	handles := make([]string, len(sessions))
+
+	for index, sData := range sessions {
+		handles[index] = sData.Handle
+	}
+
+	return handles
+}
+
+var testCases = []testCase{
+	{`CreateReturnsDistinctIds`, func(t *testing.T, h *harness) {
+		sId := h.create(1337)
+		otherSId := h.create(1337)
+
+		h.Len(sId, session.SESSION_ID_LENGTH*2)
+		h.NotEqual(sId, otherSId)
+	}},
+	{`GetReturnsCreatedData`, func(t *testing.T, h *harness) {
+		clientInfo := session.ClientInfo{
+			Ip:        `192.0.2.1`,
+			UserAgent: `curl/8.0`,
+			Device:    `Laptop`,
+		}
+		now := h.options.Clock.Now()
+		sId, err := h.Manager.CreateSession(h.ctx, &session.SessionData{
+			UserId:     1337,
+			ClientInfo: clientInfo,
+			// Ignored on create
+			CreatedAt: now.Add(-time.Hour),
+		})
+
+		h.Nil(err)
+
+		sData := h.requireAlive(sId)
+
+		h.Equal(uint32(1337), sData.UserId)
+		h.Equal(session.SessionHandle(sId), sData.Handle)
+		h.Equal(clientInfo, sData.ClientInfo)
+		h.WithinDuration(now, sData.CreatedAt, TIME_PRECISION)
+		h.WithinDuration(now, sData.LastSeenAt, TIME_PRECISION)
+		h.WithinDuration(
+			now.Add(h.options.IdleTimeout),
+			sData.ExpiresAt,
+			TIME_PRECISION,
+		)
+	}},
+	{`PendingSecondFactorIsKept`, func(t *testing.T, h *harness) {
+		sId, err := h.Manager.CreateSession(h.ctx, &session.SessionData{
+			UserId:              1337,
+			PendingSecondFactor: true,
+		})
+
+		h.Nil(err)
+		h.True(h.requireAlive(sId).PendingSecondFactor)
+
+		fullSId := h.create(1337)
+
+		h.False(h.requireAlive(fullSId).PendingSecondFactor)
+
+		// Pending session isn't listed
+		h.Equal([]string{session.SessionHandle(fullSId)}, h.handles(1337))
+	}},
+	{`PendingSessionExpiresAfterTimeout`, func(t *testing.T, h *harness) {
+		now := h.options.Clock.Now()
+		sId, err := h.Manager.CreateSession(h.ctx, &session.SessionData{
+			UserId:              1337,
+			PendingSecondFactor: true,
+		})
+
+		h.Nil(err)
+		h.WithinDuration(
+			now.Add(session.PENDING_SESSION_TIMEOUT),
+			h.requireAlive(sId).ExpiresAt,
+			TIME_PRECISION,
+		)
+
+		// Renewal doesn't extend it
+		h.Advance(session.PENDING_SESSION_TIMEOUT - time.Second)
+		h.Nil(h.Manager.RenewalSession(h.ctx, sId))
+		h.WithinDuration(
+			now.Add(session.PENDING_SESSION_TIMEOUT),
+			h.requireAlive(sId).ExpiresAt,
+			TIME_PRECISION,
+		)
+		h.Advance(time.Second)
+		h.requireGone(sId)
+	}},
+	{`GetRejectsUnknownSession`, func(t *testing.T, h *harness) {
+		h.requireGone(`unknown`)
+	}},
+	{`SessionExpiresAfterIdleTimeout`, func(t *testing.T, h *harness) {
+		sId := h.create(1337)
+
+		h.Advance(h.options.IdleTimeout - time.Second)
+		h.requireAlive(sId)
+		h.Advance(time.Second)
+		h.requireGone(sId)
+		h.ErrorIs(
+			h.Manager.RenewalSession(h.ctx, sId),
+			session.ErrSessionNotFound,
+		)
+		h.Empty(h.handles(1337))
+	}},
+	{`RenewalExtendsIdleTimeout`, func(t *testing.T, h *harness) {
+		sId := h.create(1337)
+
+		h.Advance(h.options.IdleTimeout - time.Hour)
+		h.Nil(h.Manager.RenewalSession(h.ctx, sId))
+
+		now := h.options.Clock.Now()
+		sData := h.requireAlive(sId)
+
+		h.WithinDuration(now, sData.LastSeenAt, TIME_PRECISION)
+		h.WithinDuration(
+			now.Add(h.options.IdleTimeout),
+			sData.ExpiresAt,
+			TIME_PRECISION,
+		)
+
+		h.Advance(h.options.IdleTimeout - time.Second)
+		h.requireAlive(sId)
+	}},
+	{`RenewalDoesNotExtendPastLifetime`, func(t *testing.T, h *harness) {
+		sId := h.create(1337)
+		createdAt := h.options.Clock.Now()
+
+		for h.options.Clock.Now().Before(createdAt.Add(h.options.Lifetime - time.Hour)) {
+			h.Advance(time.Hour)
+			h.Nil(h.Manager.RenewalSession(h.ctx, sId))
+		}
+
+		sData := h.requireAlive(sId)
+
+		h.WithinDuration(
+			createdAt.Add(h.options.Lifetime),
+			sData.ExpiresAt,
+			TIME_PRECISION,
+		)
+
+		h.Advance(time.Hour)
+		h.requireGone(sId)
+		h.ErrorIs(
+			h.Manager.RenewalSession(h.ctx, sId),
+			session.ErrSessionNotFound,
+		)
+	}},
+	{`RenewalRejectsUnknownSession`, func(t *testing.T, h *harness) {
+		h.ErrorIs(
+			h.Manager.RenewalSession(h.ctx, `unknown`),
+			session.ErrSessionNotFound,
+		)
+	}},
+	{`DeleteRemovesOnlyThatSession`, func(t *testing.T, h *harness) {
+		sId := h.create(1337)
+		otherSId := h.create(1337)
+
+		h.Nil(h.Manager.DeleteSession(h.ctx, sId))
+		h.requireGone(sId)
+		h.requireAlive(otherSId)
+		h.Equal([]string{session.SessionHandle(otherSId)}, h.handles(1337))
+		h.ErrorIs(
+			h.Manager.DeleteSession(h.ctx, sId),
+			session.ErrSessionNotFound,
+		)
+	}},
+	{`ListReturnsMostRecentlySeenFirst`, func(t *testing.T, h *harness) {
+		olderSId := h.create(1337)
+
+		h.Advance(time.Minute)
+
+		newerSId := h.create(1337)
+
+		h.create(1336)
+		h.Equal(
+			[]string{
+				session.SessionHandle(newerSId),
+				session.SessionHandle(olderSId),
+			},
+			h.handles(1337),
+		)
+
+		h.Advance(time.Minute)
+		h.Nil(h.Manager.RenewalSession(h.ctx, olderSId))
+		h.Equal(
+			[]string{
+				session.SessionHandle(olderSId),
+				session.SessionHandle(newerSId),
+			},
+			h.handles(1337),
+		)
+		h.Empty(h.handles(1335))
+	}},
+	{`RevokeRemovesSessionByHandle`, func(t *testing.T, h *harness) {
+		sId := h.create(1337)
+		otherSId := h.create(1337)
+
+		h.Nil(h.Manager.RevokeSession(h.ctx, 1337, session.SessionHandle(otherSId)))
+		h.requireGone(otherSId)
+		h.requireAlive(sId)
+		h.ErrorIs(
+			h.Manager.RevokeSession(h.ctx, 1337, session.SessionHandle(otherSId)),
+			session.ErrSessionHandleNotFound,
+		)
+	}},
+	{`RevokeRejectsSessionOfOtherUser`, func(t *testing.T, h *harness) {
+		sId := h.create(1337)
+
+		h.ErrorIs(
+			h.Manager.RevokeSession(h.ctx, 1336, session.SessionHandle(sId)),
+			session.ErrSessionHandleNotFound,
+		)
+		h.requireAlive(sId)
+	}},
+	{`RevokeRejectsExpiredSession`, func(t *testing.T, h *harness) {
+		sId := h.create(1337)
+
+		h.Advance(h.options.IdleTimeout)
+		h.ErrorIs(
+			h.Manager.RevokeSession(h.ctx, 1337, session.SessionHandle(sId)),
+			session.ErrSessionHandleNotFound,
+		)
+	}},
+	{`ResetOtherSessionsKeepsCurrentOne`, func(t *testing.T, h *harness) {
+		sId := h.create(1337)
+		otherSId := h.create(1337)
+		otherUserSId := h.create(1336)
+
+		h.Nil(h.Manager.ResetOtherSessions(h.ctx, 1337, sId))
+		h.requireAlive(sId)
+		h.requireGone(otherSId)
+		h.requireAlive(otherUserSId)
+		h.Nil(h.Manager.ResetOtherSessions(h.ctx, 1335, sId))
+	}},
+	{`ResetRemovesEverySessionOfUser`, func(t *testing.T, h *harness) {
+		sIds := []string{h.create(1337), h.create(1337)}
+		otherUserSId := h.create(1336)
+
+		h.Nil(h.Manager.ResetSessions(h.ctx, 1337))
+
+		for _, sId := range sIds {
+			h.requireGone(sId)
+		}
+
+		h.Empty(h.handles(1337))
+		h.requireAlive(otherUserSId)
+
+		// Reset of user without sessions is no-op
+		h.Nil(h.Manager.ResetSessions(h.ctx, 1337))
+
+		// User can sign in again after reset
+		h.requireAlive(h.create(1337))
+	}},
+	{`ObserverReceivesLifecycleEvents`, func(t *testing.T, h *harness) {
+		clientInfo := session.ClientInfo{Device: `Laptop`}
+		sId, err := h.Manager.CreateSession(h.ctx, &session.SessionData{
+			UserId:     1337,
+			ClientInfo: clientInfo,
+		})
+
+		h.Nil(err)
+
+		otherSId := h.create(1337)
+		revokedSId := h.create(1337)
+		handle := session.SessionHandle(sId)
+		otherHandle := session.SessionHandle(otherSId)
+		revokedHandle := session.SessionHandle(revokedSId)
+
+		h.Equal(
+			[]event{
+				{`created`, 1337, handle, clientInfo},
+				{`created`, 1337, otherHandle, session.ClientInfo{}},
+				{`created`, 1337, revokedHandle, session.ClientInfo{}},
+			},
+			h.recorder.flush(),
+		)
+
+		h.Nil(h.Manager.RenewalSession(h.ctx, sId))
+		h.Nil(h.Manager.DeleteSession(h.ctx, otherSId))
+		h.Nil(h.Manager.RevokeSession(h.ctx, 1337, revokedHandle))
+		h.Nil(h.Manager.ResetOtherSessions(h.ctx, 1337, sId))
+		h.Nil(h.Manager.ResetSessions(h.ctx, 1337))
+		h.Equal(
+			[]event{
+				{`renewed`, 1337, handle, session.ClientInfo{}},
+				{`deleted`, 1337, otherHandle, session.ClientInfo{}},
+				{`deleted`, 1337, revokedHandle, session.ClientInfo{}},
+				{`reset`, 1337, handle, session.ClientInfo{}},
+				{`reset`, 1337, ``, session.ClientInfo{}},
+			},
+			h.recorder.flush(),
+		)
+
+		// Failed calls aren't reported
+		h.ErrorIs(
+			h.Manager.RenewalSession(h.ctx, sId),
+			session.ErrSessionNotFound,
+		)
+		h.ErrorIs(
+			h.Manager.DeleteSession(h.ctx, sId),
+			session.ErrSessionNotFound,
+		)
+		h.ErrorIs(
+			h.Manager.RevokeSession(h.ctx, 1337, handle),
+			session.ErrSessionHandleNotFound,
+		)
+		h.Empty(h.recorder.flush())
+	}},
+	{`ConcurrentCreatesAreAllKept`, func(t *testing.T, h *harness) {
+		var wg sync.WaitGroup
+
+		sIds := make([]string, CONCURRENCY)
+		errs := make([]error, CONCURRENCY)
+
+		for index := range CONCURRENCY {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				sIds[index], errs[index] = h.Manager.CreateSession(
+					h.ctx,
+					&session.SessionData{UserId: 1337},
+				)
+			}()
+		}
+
+		wg.Wait()
+
+		expectedHandles := make([]string, CONCURRENCY)
+
+		for index, sId := range sIds {
+			h.Nil(errs[index])
+			expectedHandles[index] = session.SessionHandle(sId)
+		}
+
+		h.ElementsMatch(expectedHandles, h.handles(1337))
+	}},
+	{`ConcurrentRenewalsAndDeletesDoNotResurrect`, func(t *testing.T, h *harness) {
+		sIds := make([]string, CONCURRENCY)
+
+		for index := range sIds {
+			sIds[index] = h.create(1337)
+		}
+
+		h.Advance(time.Hour)
+
+		var wg sync.WaitGroup
+
+		renewalErrs := make([]error, CONCURRENCY)
+		deleteErrs := make([]error, CONCURRENCY)
+
+		for index, sId := range sIds {
+			wg.Add(2)
+
+			go func() {
+				defer wg.Done()
+
+				renewalErrs[index] = h.Manager.RenewalSession(h.ctx, sId)
+			}()
+
+			go func() {
+				defer wg.Done()
+
+				deleteErrs[index] = h.Manager.DeleteSession(h.ctx, sId)
+			}()
+		}
+
+		wg.Wait()
+
+		for index := range sIds {
+			// Renewal either wins the race or finds session deleted
+			if renewalErrs[index] != nil {
+				h.ErrorIs(renewalErrs[index], session.ErrSessionNotFound)
+			}
+
+			h.Nil(deleteErrs[index])
+		}
+
+		for _, sId := range sIds {
+			h.requireGone(sId)
+		}
+
+		h.Empty(h.handles(1337))
+	}},
+}
+
+var limitTestCases = []limitTestCase{
+	{
+		testCase{`LimitIgnoresPendingSessions`, func(t *testing.T, h *harness) {
+			pending := &session.SessionData{UserId: 1337, PendingSecondFactor: true}
+			pendingSId, err := h.Manager.CreateSession(h.ctx, pending)
+
+			h.Nil(err)
+
+			sId := h.create(1337)
+
+			// Pending session is created over the limit, so password alone
+			// can't push the owner's sessions out
+			otherPendingSId, err := h.Manager.CreateSession(h.ctx, pending)
+
+			h.Nil(err)
+
+			// Full session evicts only full ones
+			h.Advance(time.Minute)
+
+			otherSId := h.create(1337)
+
+			h.requireGone(sId)
+			h.requireAlive(pendingSId)
+			h.requireAlive(otherPendingSId)
+			h.Equal([]string{session.SessionHandle(otherSId)}, h.handles(1337))
+		}},
+		session.LimitOptions{
+			MaxSessions: 1,
+			LimitPolicy: session.SESSION_LIMIT_POLICY_EVICT,
+		},
+	},
+	{
+		testCase{`LimitRejectsSessionOverLimit`, func(t *testing.T, h *harness) {
+			sIds := []string{h.create(1337), h.create(1337)}
+
+			h.recorder.flush()
+
+			_, err := h.Manager.CreateSession(
+				h.ctx,
+				&session.SessionData{UserId: 1337},
+			)
+
+			h.ErrorIs(err, session.ErrSessionLimitReached)
+			h.Empty(h.recorder.flush())
+
+			for _, sId := range sIds {
+				h.requireAlive(sId)
+			}
+
+			// Limit is per user and expired sessions don't count
+			h.create(1336)
+			h.Advance(h.options.IdleTimeout)
+			h.create(1337)
+		}},
+		session.LimitOptions{
+			MaxSessions: 2,
+			LimitPolicy: session.SESSION_LIMIT_POLICY_REJECT,
+		},
+	},
+	{
+		testCase{`LimitEvictsLeastRecentlySeenSession`, func(t *testing.T, h *harness) {
+			olderSId := h.create(1337)
+
+			h.Advance(time.Minute)
+
+			newerSId := h.create(1337)
+
+			// Renewed session is the most recently seen one now
+			h.Advance(time.Minute)
+			h.Nil(h.Manager.RenewalSession(h.ctx, olderSId))
+			h.Advance(time.Minute)
+			h.recorder.flush()
+
+			sId := h.create(1337)
+
+			h.Equal(
+				[]event{
+					{`deleted`, 1337, session.SessionHandle(newerSId), session.ClientInfo{}},
+					{`created`, 1337, session.SessionHandle(sId), session.ClientInfo{}},
+				},
+				h.recorder.flush(),
+			)
+			h.requireGone(newerSId)
+			h.Equal(
+				[]string{
+					session.SessionHandle(sId),
+					session.SessionHandle(olderSId),
+				},
+				h.handles(1337),
+			)
+		}},
+		session.LimitOptions{
+			MaxSessions: 2,
+			LimitPolicy: session.SESSION_LIMIT_POLICY_EVICT,
+		},
+	},
+}
@@ -0,0 +1,267 @@
+package session
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"finanstar/server/apperror"
+)
+
+// Session manager decorator issuing session tokens "<sId>.<key id>.<tag>",
+// where tag is HMAC-SHA256 of sId. Tampered and guessed tokens are rejected
+// before the wrapped manager touches storage. Keys are rotated by putting the
+// new key first in the ring and dropping the old one once tokens signed with
+// it have expired
+
+const (
+	SESSION_TOKEN_SEPARATOR = "."
+	// Minimum length of signing key secret in bytes
+	SESSION_SIGNING_KEY_MIN_LENGTH = 32
+)
+
+const (
+	SESSION_TOKEN_INVALID_ERROR    = "Session token is invalid"
+	SESSION_KEY_RING_INVALID_ERROR = "Session signing key ring is invalid"
+)
+
+var (
+	ErrSessionTokenInvalid   = errors.New(SESSION_TOKEN_INVALID_ERROR)
+	ErrSessionKeyRingInvalid = errors.New(SESSION_KEY_RING_INVALID_ERROR)
+)
+
+type SigningKey struct {
+	// Short public identifier put into tokens, must not contain separator
+	Id     string
+	Secret []byte
+}
+
+// Signing keys, the first one signs new tokens and every one verifies them
+type KeyRing []SigningKey
+
+// Parses comma separated "<key id>:<base64 secret>" pairs, the first pair is
+// the signing key
+func ParseKeyRing(value string) (KeyRing, error) {
+	keyRing := KeyRing{}
+
+	for index, pair := range strings.Split(value, ",") {
+		id, rawSecret, found := strings.Cut(strings.TrimSpace(pair), ":")
+
+		// Pair isn't quoted, as it may be a secret
+		if !found {
+			return nil, apperror.Wrap(
+				ErrSessionKeyRingInvalid,
+				fmt.Errorf("Key #%d must be <key id>:<base64 secret>", index+1),
+			)
+		}
+
+		secret, err := base64.StdEncoding.DecodeString(rawSecret)
+
+		if err != nil {
+			return nil, apperror.Wrap(ErrSessionKeyRingInvalid, err)
+		}
+
+		keyRing = append(keyRing, SigningKey{Id: id, Secret: secret})
+	}
+
+	return keyRing, keyRing.Validate()
+}
+
+func (self KeyRing) Validate() error {
+	if len(self) == 0 {
+		return apperror.Wrap(
+			ErrSessionKeyRingInvalid,
+			errors.New("At least one key is required"),
+		)
+	}
+
+	ids := map[string]struct{}{}
+
+	for _, key := range self {
+		if len(key.Id) == 0 || strings.Contains(key.Id, SESSION_TOKEN_SEPARATOR) {
+			return apperror.Wrap(
+				ErrSessionKeyRingInvalid,
+				fmt.Errorf(
+					"Key id %q must be non-empty and without %q",
+					key.Id,
+					SESSION_TOKEN_SEPARATOR,
+				),
+			)
+		}
+
+		if len(key.Secret) < SESSION_SIGNING_KEY_MIN_LENGTH {
+			return apperror.Wrap(
+				ErrSessionKeyRingInvalid,
+				fmt.Errorf(
+					"Key %q must be at least %d bytes",
+					key.Id,
+					SESSION_SIGNING_KEY_MIN_LENGTH,
+				),
+			)
+		}
+
+		if _, exists := ids[key.Id]; exists {
+			return apperror.Wrap(
+				ErrSessionKeyRingInvalid,
+				fmt.Errorf("Key id %q is duplicated", key.Id),
+			)
+		}
+
+		ids[key.Id] = struct{}{}
+	}
+
+	return nil
+}
+
+func NewSignedSessionManager(
+	sessions SessionManager,
+	keyRing KeyRing,
+) (*SignedSessionManager, error) {
+	if err := keyRing.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &SignedSessionManager{sessions: sessions, keyRing: keyRing}, nil
+}
+
+type SignedSessionManager struct {
+	sessions SessionManager
+	keyRing  KeyRing
+}
+
+// Returns session token instead of sId
+func (ssm *SignedSessionManager) CreateSession(
+	ctx context.Context,
+	sData *SessionData,
+) (string, error) {
+	sId, err := ssm.sessions.CreateSession(ctx, sData)
+
+	if err != nil {
+		return "", err
+	}
+
+	return ssm.sign(sId), nil
+}
+
+func (ssm *SignedSessionManager) DeleteSession(
+	ctx context.Context,
+	token string,
+) error {
+	sId, err := ssm.verify(token)
+
+	if err != nil {
+		return err
+	}
+
+	return ssm.sessions.DeleteSession(ctx, sId)
+}
+
+func (ssm *SignedSessionManager) RenewalSession(
+	ctx context.Context,
+	token string,
+) error {
+	sId, err := ssm.verify(token)
+
+	if err != nil {
+		return err
+	}
+
+	return ssm.sessions.RenewalSession(ctx, sId)
+}
+
+func (ssm *SignedSessionManager) GetSessionData(
+	ctx context.Context,
+	token string,
+) (*SessionData, error) {
+	sId, err := ssm.verify(token)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ssm.sessions.GetSessionData(ctx, sId)
+}
+
+func (ssm *SignedSessionManager) ResetSessions(
+	ctx context.Context,
+	userId uint32,
+) error {
+	return ssm.sessions.ResetSessions(ctx, userId)
+}
+
+func (ssm *SignedSessionManager) ListSessions(
+	ctx context.Context,
+	userId uint32,
+) ([]SessionData, error) {
+	return ssm.sessions.ListSessions(ctx, userId)
+}
+
+func (ssm *SignedSessionManager) RevokeSession(
+	ctx context.Context,
+	userId uint32,
+	handle string,
+) error {
+	return ssm.sessions.RevokeSession(ctx, userId, handle)
+}
+
+func (ssm *SignedSessionManager) ResetOtherSessions(
+	ctx context.Context,
+	userId uint32,
+	keptToken string,
+) error {
+	sId, err := ssm.verify(keptToken)
+
+	if err != nil {
+		return err
+	}
+
+	return ssm.sessions.ResetOtherSessions(ctx, userId, sId)
+}
+
+func (ssm *SignedSessionManager) sign(sId string) string {
+	key := ssm.keyRing[0]
+
+	return strings.Join(
+		[]string{sId, key.Id, sessionTokenTag(key.Secret, sId)},
+		SESSION_TOKEN_SEPARATOR,
+	)
+}
+
+// Returns sId of the token or ErrSessionNotFound wrapping
+// ErrSessionTokenInvalid
+func (ssm *SignedSessionManager) verify(token string) (string, error) {
+	parts := strings.Split(token, SESSION_TOKEN_SEPARATOR)
+
+	if len(parts) == 3 {
+		sId, keyId, tag := parts[0], parts[1], parts[2]
+
+		for _, key := range ssm.keyRing {
+			if key.Id != keyId {
+				continue
+			}
+
+			expectedTag := sessionTokenTag(key.Secret, sId)
+
+			if hmac.Equal([]byte(tag), []byte(expectedTag)) {
+				return sId, nil
+			}
+
+			break
+		}
+	}
+
+	metrics.Add(SESSION_TOKEN_REJECTIONS_METRIC, 1)
+
+	return "", apperror.Wrap(ErrSessionNotFound, ErrSessionTokenInvalid)
+}
+
+func sessionTokenTag(secret []byte, sId string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(sId))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
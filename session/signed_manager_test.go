@@ -0,0 +1,170 @@
+package session
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testSigningKey(id string) SigningKey {
+	return SigningKey{Id: id, Secret: []byte(strings.Repeat(id, 32))}
+}
+
+// Counts lookups reaching storage
+type countingSessionManager struct {
+	SessionManager
+	lookups int
+}
+
+func (self *countingSessionManager) GetSessionData(
+	ctx context.Context,
+	sId string,
+) (*SessionData, error) {
+	self.lookups++
+
+	return self.SessionManager.GetSessionData(ctx, sId)
+}
+
+func TestSignedSessionManager(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ctx := context.Background()
+	sessions := &countingSessionManager{
+		SessionManager: NewMemorySessionManager(nil),
+	}
+	ssm, err := NewSignedSessionManager(sessions, KeyRing{testSigningKey(`a`)})
+
+	require.Nil(err)
+
+	token, err := ssm.CreateSession(ctx, &SessionData{UserId: 1337})
+
+	require.Nil(err)
+
+	sId, keyId, found := strings.Cut(token, SESSION_TOKEN_SEPARATOR)
+
+	require.True(found)
+	require.Len(sId, SESSION_ID_LENGTH*2)
+	require.True(strings.HasPrefix(keyId, `a.`))
+
+	sData, err := ssm.GetSessionData(ctx, token)
+
+	require.Nil(err)
+	require.Equal(SessionHandle(sId), sData.Handle)
+	require.Nil(ssm.RenewalSession(ctx, token))
+
+	for _, forged := range []string{
+		sId,
+		sId + `.a.`,
+		strings.Replace(token, sId, strings.Repeat(`0`, len(sId)), 1),
+		strings.Replace(token, `.a.`, `.b.`, 1),
+		token + `x`,
+		`0123456789abcdef0123456789abcdef`,
+	} {
+		_, err := ssm.GetSessionData(ctx, forged)
+
+		require.ErrorIs(err, ErrSessionNotFound)
+		require.ErrorIs(err, ErrSessionTokenInvalid)
+		require.ErrorIs(ssm.RenewalSession(ctx, forged), ErrSessionTokenInvalid)
+		require.ErrorIs(ssm.DeleteSession(ctx, forged), ErrSessionTokenInvalid)
+	}
+
+	// Only the valid token reached storage
+	require.Equal(1, sessions.lookups)
+
+	require.Nil(ssm.DeleteSession(ctx, token))
+
+	_, err = ssm.GetSessionData(ctx, token)
+
+	require.ErrorIs(err, ErrSessionNotFound)
+	require.NotErrorIs(err, ErrSessionTokenInvalid)
+}
+
+func TestSignedSessionManagerKeyRotation(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ctx := context.Background()
+	sessions := NewMemorySessionManager(nil)
+	oldSsm, err := NewSignedSessionManager(sessions, KeyRing{testSigningKey(`a`)})
+
+	require.Nil(err)
+
+	oldToken, err := oldSsm.CreateSession(ctx, &SessionData{UserId: 1337})
+
+	require.Nil(err)
+
+	// New key signs, the old one still verifies
+	rotatedSsm, err := NewSignedSessionManager(
+		sessions,
+		KeyRing{testSigningKey(`b`), testSigningKey(`a`)},
+	)
+
+	require.Nil(err)
+
+	newToken, err := rotatedSsm.CreateSession(ctx, &SessionData{UserId: 1337})
+
+	require.Nil(err)
+	require.Contains(newToken, `.b.`)
+
+	_, err = rotatedSsm.GetSessionData(ctx, oldToken)
+
+	require.Nil(err)
+	require.Nil(rotatedSsm.ResetOtherSessions(ctx, 1337, newToken))
+
+	// Old key is dropped
+	newSsm, err := NewSignedSessionManager(sessions, KeyRing{testSigningKey(`b`)})
+
+	require.Nil(err)
+
+	_, err = newSsm.GetSessionData(ctx, oldToken)
+
+	require.ErrorIs(err, ErrSessionTokenInvalid)
+
+	_, err = newSsm.GetSessionData(ctx, newToken)
+
+	require.Nil(err)
+}
+
+func TestParseKeyRing(t *testing.T) {
+	t.Parallel()
+
+	secret := base64.StdEncoding.EncodeToString([]byte(strings.Repeat(`s`, 32)))
+	shortSecret := base64.StdEncoding.EncodeToString([]byte(`short`))
+
+	testVariants := []struct {
+		title  string
+		value  string
+		keyIds []string
+	}{
+		{"Single key", `a:` + secret, []string{`a`}},
+		{"Rotated keys", `b:` + secret + `, a:` + secret, []string{`b`, `a`}},
+		{"Key without id", secret, nil},
+		{"Key id with separator", `a.b:` + secret, nil},
+		{"Duplicated key id", `a:` + secret + `,a:` + secret, nil},
+		{"Short secret", `a:` + shortSecret, nil},
+		{"Invalid base64", `a:not base64`, nil},
+	}
+
+	for _, tt := range testVariants {
+		t.Run(tt.title, func(t *testing.T) {
+			require := require.New(t)
+			keyRing, err := ParseKeyRing(tt.value)
+
+			if tt.keyIds == nil {
+				require.ErrorIs(err, ErrSessionKeyRingInvalid)
+				require.NotContains(err.Error(), secret)
+				return
+			}
+
+			require.Nil(err)
+			require.Len(keyRing, len(tt.keyIds))
+
+			for index, keyId := range tt.keyIds {
+				require.Equal(keyId, keyRing[index].Id)
+				require.Equal([]byte(strings.Repeat(`s`, 32)), keyRing[index].Secret)
+			}
+		})
+	}
+}
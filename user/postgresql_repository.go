@@ -5,12 +5,18 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 
+	"finanstar/server/apperror"
 	utils_pgx "finanstar/server/utils"
 )
 
+const (
+	USERS_LOGIN_CONSTRAINT = "users_login_key"
+)
+
 func NewPostgresqlUserRepository(db utils_pgx.PgxPoolIface) postgresqlUserRepository {
 	return postgresqlUserRepository{db}
 }
@@ -29,16 +35,45 @@ func (self *postgresqlUserRepository) GetByLogin(
 		Password: ``,
 	}
 
-	err := self.db.
+	err := utils_pgx.QuerierFromContext(ctx, self.db).
 		QueryRow(
 			ctx,
-			`SELECT id, login, password FROM users WHERE login = $1;`,
+			`SELECT id, login, password, email_verified_at FROM users WHERE login = $1;`,
 			login,
 		).
-		Scan(&user.Id, &user.Login, &user.Password)
+		Scan(&user.Id, &user.Login, &user.Password, &user.EmailVerifiedAt)
 
-	if err == pgx.ErrNoRows {
-		return nil, errors.New(USER_NOT_FOUND_ERROR)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, apperror.Wrap(ErrUserNotFound, err)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (self *postgresqlUserRepository) GetById(
+	ctx context.Context,
+	id uint32,
+) (*userEntity, error) {
+	user := userEntity{
+		Id:       0,
+		Login:    ``,
+		Password: ``,
+	}
+
+	err := utils_pgx.QuerierFromContext(ctx, self.db).
+		QueryRow(
+			ctx,
+			`SELECT id, login, password, email_verified_at FROM users WHERE id = $1;`,
+			id,
+		).
+		Scan(&user.Id, &user.Login, &user.Password, &user.EmailVerifiedAt)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, apperror.Wrap(ErrUserNotFound, err)
 	}
 
 	if err != nil {
@@ -64,9 +99,14 @@ func (self *postgresqlUserRepository) Update(
 	queryArgs[0] = id
 
 	if dto.Login != nil {
+		// New login isn't verified, the same one keeps its verification
 		updateParams = append(
 			updateParams,
 			fmt.Sprintf(`login = $%d`, len(queryArgs)+1),
+			fmt.Sprintf(
+				`email_verified_at = CASE WHEN login = $%d THEN email_verified_at END`,
+				len(queryArgs)+1,
+			),
 		)
 		queryArgs = append(queryArgs, *dto.Login)
 	}
@@ -80,26 +120,34 @@ func (self *postgresqlUserRepository) Update(
 	}
 
 	if len(updateParams) == 0 {
-		return nil, errors.New(THERE_IS_NO_UPDATE_PARAMS_ERROR)
+		return nil, ErrThereIsNoUpdateParams
 	}
 
-	err := self.db.
+	condition := `id = $1`
+
+	if dto.ExpectedPassword != nil {
+		queryArgs = append(queryArgs, *dto.ExpectedPassword)
+		condition += fmt.Sprintf(` AND password = $%d`, len(queryArgs))
+	}
+
+	err := utils_pgx.QuerierFromContext(ctx, self.db).
 		QueryRow(
 			ctx,
 			fmt.Sprintf(
-				`UPDATE users WHERE id = $1 SET %s RETURNING login, password;`,
+				`UPDATE users SET %s WHERE %s RETURNING login, password, email_verified_at;`,
 				strings.Join(updateParams, `,`),
+				condition,
 			),
 			queryArgs...,
 		).
-		Scan(&user.Login, &user.Password)
+		Scan(&user.Login, &user.Password, &user.EmailVerifiedAt)
 
-	if err == pgx.ErrNoRows {
-		return nil, errors.New(USER_NOT_FOUND_ERROR)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, apperror.Wrap(ErrUserNotFound, err)
 	}
 
 	if err != nil {
-		return nil, err
+		return nil, mapWriteError(err)
 	}
 
 	return &user, nil
@@ -115,26 +163,77 @@ func (self *postgresqlUserRepository) Create(
 		Password: ``,
 	}
 
-	err := self.db.
+	err := utils_pgx.QuerierFromContext(ctx, self.db).
 		QueryRow(
 			ctx,
 			`
 				INSERT INTO users (login, password)
 				VALUES ($1, $2)
-				RETURNING id, login, password;
+				RETURNING id, login, password, email_verified_at;
 			`,
 			dto.Login,
 			dto.Password,
 		).
-		Scan(&user.Id, &user.Login, &user.Password)
+		Scan(&user.Id, &user.Login, &user.Password, &user.EmailVerifiedAt)
 
 	if err != nil {
-		if strings.Contains(err.Error(), utils_pgx.DUPLICATE_VALUE_ERROR) {
-			return nil, errors.New(USER_ALREADY_EXISTS_ERROR)
-		}
+		return nil, mapWriteError(err)
+	}
 
+	return &user, nil
+}
+
+func (self *postgresqlUserRepository) VerifyEmail(
+	ctx context.Context,
+	id uint32,
+	login string,
+	at time.Time,
+) (*userEntity, error) {
+	user := userEntity{
+		Id:       id,
+		Login:    ``,
+		Password: ``,
+	}
+
+	err := utils_pgx.QuerierFromContext(ctx, self.db).
+		QueryRow(
+			ctx,
+			`
+				UPDATE users
+				SET email_verified_at = COALESCE(email_verified_at, $3)
+				WHERE id = $1 AND login = $2
+				RETURNING login, password, email_verified_at;
+			`,
+			id,
+			login,
+			at,
+		).
+		Scan(&user.Login, &user.Password, &user.EmailVerifiedAt)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, apperror.Wrap(ErrUserNotFound, err)
+	}
+
+	if err != nil {
 		return nil, err
 	}
 
 	return &user, nil
 }
+
+// Maps constraint violations to domain errors, so taken login can be told
+// apart from other conflicts
+func mapWriteError(err error) error {
+	classified := utils_pgx.ClassifyError(err)
+
+	if classified.Kind == utils_pgx.UNIQUE_VIOLATION &&
+		classified.Constraint == USERS_LOGIN_CONSTRAINT {
+		return apperror.Wrap(ErrUserAlreadyExists, err)
+	}
+
+	if classified.IsConstraintViolation() {
+		return apperror.Wrap(ErrUserConflict, err)
+	}
+
+	return err
+}
@@ -5,7 +5,9 @@ import (
 	"errors"
 	utils_pgx "finanstar/server/utils"
 	"testing"
+	"time"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/pashagolub/pgxmock/v4"
 	"github.com/stretchr/testify/require"
 )
@@ -19,7 +21,7 @@ type result struct {
 func TestRepositoryGetByLogin(t *testing.T) {
 	t.Parallel()
 
-	expectedSql := `SELECT id, login, password FROM users WHERE login = \$1;`
+	expectedSql := `SELECT id, login, password, email_verified_at FROM users WHERE login = \$1;`
 	testLogin := `test@example.com`
 
 	subtests := []struct {
@@ -39,7 +41,7 @@ func TestRepositoryGetByLogin(t *testing.T) {
 		{
 			name: `ReturnsUserNotFoundError`,
 			result: result{
-				error: errors.New(USER_NOT_FOUND_ERROR),
+				error: ErrUserNotFound,
 			},
 		},
 		{
@@ -58,11 +60,11 @@ func TestRepositoryGetByLogin(t *testing.T) {
 			require.Nil(err)
 			pur := postgresqlUserRepository{db: db}
 
-			rows := db.NewRows([]string{`id`, `login`, `password`})
+			rows := db.NewRows([]string{`id`, `login`, `password`, `email_verified_at`})
 			resultUser := test.result.user
 
 			if resultUser != nil {
-				rows.AddRow(resultUser.Id, resultUser.Login, resultUser.Password)
+				rows.AddRow(resultUser.Id, resultUser.Login, resultUser.Password, resultUser.EmailVerifiedAt)
 			}
 
 			query := db.ExpectQuery(expectedSql).WithArgs(testLogin)
@@ -88,7 +90,7 @@ func TestRepositoryGetByLogin(t *testing.T) {
 				require.NotNil(err)
 
 				if test.result.error != nil {
-					require.EqualError(err, test.result.error.Error())
+					require.ErrorIs(err, test.result.error)
 				} else {
 					require.EqualError(err, test.result.dbError.Error())
 				}
@@ -97,12 +99,136 @@ func TestRepositoryGetByLogin(t *testing.T) {
 	}
 }
 
+func TestRepositoryGetById(t *testing.T) {
+	t.Parallel()
+
+	subtests := []struct {
+		name  string
+		user  *userEntity
+		error error
+	}{
+		{
+			name: `ReturnsUser`,
+			user: &userEntity{
+				Id:       1,
+				Login:    `test@example.com`,
+				Password: `hashed_password`,
+			},
+		},
+		{
+			name:  `ReturnsUserNotFoundError`,
+			error: ErrUserNotFound,
+		},
+	}
+
+	for _, test := range subtests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+			db, err := pgxmock.NewPool()
+
+			require.Nil(err)
+			pur := postgresqlUserRepository{db: db}
+
+			rows := db.NewRows([]string{`id`, `login`, `password`, `email_verified_at`})
+
+			if test.user != nil {
+				rows.AddRow(test.user.Id, test.user.Login, test.user.Password, test.user.EmailVerifiedAt)
+			}
+
+			db.
+				ExpectQuery(`SELECT id, login, password, email_verified_at FROM users WHERE id = \$1;`).
+				WithArgs(uint32(1)).
+				WillReturnRows(rows)
+
+			user, err := pur.GetById(context.Background(), 1)
+
+			if test.error != nil {
+				require.Nil(user)
+				require.ErrorIs(err, test.error)
+			} else {
+				require.Nil(err)
+				require.Equal(test.user, user)
+			}
+
+			require.Nil(db.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestRepositoryVerifyEmail(t *testing.T) {
+	t.Parallel()
+
+	verifiedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	subtests := []struct {
+		name  string
+		user  *userEntity
+		error error
+	}{
+		{
+			name: `VerifiesEmail`,
+			user: &userEntity{
+				Id:              1,
+				Login:           `test@example.com`,
+				Password:        `hashed_password`,
+				EmailVerifiedAt: &verifiedAt,
+			},
+		},
+		{
+			name:  `ReturnsUserNotFoundWhenLoginChanged`,
+			error: ErrUserNotFound,
+		},
+	}
+
+	for _, test := range subtests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+			db, err := pgxmock.NewPool()
+
+			require.Nil(err)
+			pur := postgresqlUserRepository{db: db}
+
+			rows := db.NewRows([]string{`login`, `password`, `email_verified_at`})
+
+			if test.user != nil {
+				rows.AddRow(test.user.Login, test.user.Password, test.user.EmailVerifiedAt)
+			}
+
+			db.
+				ExpectQuery(`
+					UPDATE users
+					SET email_verified_at = COALESCE\(email_verified_at, \$3\)
+					WHERE id = \$1 AND login = \$2
+				`).
+				WithArgs(uint32(1), `test@example.com`, verifiedAt).
+				WillReturnRows(rows)
+
+			user, err := pur.VerifyEmail(
+				context.Background(),
+				1,
+				`test@example.com`,
+				verifiedAt,
+			)
+
+			if test.error != nil {
+				require.Nil(user)
+				require.ErrorIs(err, test.error)
+			} else {
+				require.Nil(err)
+				require.Equal(test.user, user)
+			}
+
+			require.Nil(db.ExpectationsWereMet())
+		})
+	}
+}
+
 func TestRepositoryUpdate(t *testing.T) {
 	t.Parallel()
 
 	type updateDto struct {
-		login    string
-		password string
+		login            string
+		password         string
+		expectedPassword string
 	}
 
 	subtests := []struct {
@@ -117,9 +243,9 @@ func TestRepositoryUpdate(t *testing.T) {
 			userId: 1,
 			expectedSql: `
 				UPDATE users
+				SET login = \$2,email_verified_at = CASE WHEN login = \$2 THEN email_verified_at END,password = \$3
 				WHERE id = \$1
-				SET login = \$2,password = \$3
-				RETURNING login, password;
+				RETURNING login, password, email_verified_at;
 			`,
 			updateDto: updateDto{
 				login:    `test@example.com`,
@@ -142,7 +268,7 @@ func TestRepositoryUpdate(t *testing.T) {
 				password: ``,
 			},
 			result: result{
-				error: errors.New(THERE_IS_NO_UPDATE_PARAMS_ERROR),
+				error: ErrThereIsNoUpdateParams,
 			},
 		},
 		{
@@ -150,9 +276,9 @@ func TestRepositoryUpdate(t *testing.T) {
 			userId: 1,
 			expectedSql: `
 				UPDATE users
+				SET login = \$2,email_verified_at = CASE WHEN login = \$2 THEN email_verified_at END
 				WHERE id = \$1
-				SET login = \$2
-				RETURNING login, password;
+				RETURNING login, password, email_verified_at;
 			`,
 			updateDto: updateDto{
 				login:    `test@example.com`,
@@ -171,9 +297,9 @@ func TestRepositoryUpdate(t *testing.T) {
 			userId: 1,
 			expectedSql: `
 				UPDATE users
-				WHERE id = \$1
 				SET password = \$2
-				RETURNING login, password;
+				WHERE id = \$1
+				RETURNING login, password, email_verified_at;
 			`,
 			updateDto: updateDto{
 				login:    ``,
@@ -187,21 +313,80 @@ func TestRepositoryUpdate(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:   `UpdateToTakenLogin`,
+			userId: 1,
+			expectedSql: `
+				UPDATE users
+				SET login = \$2,email_verified_at = CASE WHEN login = \$2 THEN email_verified_at END
+				WHERE id = \$1
+				RETURNING login, password, email_verified_at;
+			`,
+			updateDto: updateDto{
+				login:    `taken@example.com`,
+				password: ``,
+			},
+			result: result{
+				dbError: &pgconn.PgError{
+					Code:           utils_pgx.UNIQUE_VIOLATION_CODE,
+					ConstraintName: USERS_LOGIN_CONSTRAINT,
+				},
+				error: ErrUserAlreadyExists,
+			},
+		},
+		{
+			name:   `UpdatePasswordExpectingOldOne`,
+			userId: 1,
+			expectedSql: `
+				UPDATE users
+				SET password = \$2
+				WHERE id = \$1 AND password = \$3
+				RETURNING login, password, email_verified_at;
+			`,
+			updateDto: updateDto{
+				password:         `hashed_password`,
+				expectedPassword: `old_hashed_password`,
+			},
+			result: result{
+				user: &userEntity{
+					Id:       1,
+					Login:    `test@example.com`,
+					Password: `hashed_password`,
+				},
+			},
+		},
+		{
+			name:   `UpdatePasswordChangedMeanwhile`,
+			userId: 1,
+			expectedSql: `
+				UPDATE users
+				SET password = \$2
+				WHERE id = \$1 AND password = \$3
+				RETURNING login, password, email_verified_at;
+			`,
+			updateDto: updateDto{
+				password:         `hashed_password`,
+				expectedPassword: `old_hashed_password`,
+			},
+			result: result{
+				error: ErrUserNotFound,
+			},
+		},
 		{
 			name:   `UpdateNonExistingUser`,
 			userId: 1,
 			expectedSql: `
 				UPDATE users
+				SET login = \$2,email_verified_at = CASE WHEN login = \$2 THEN email_verified_at END,password = \$3
 				WHERE id = \$1
-				SET login = \$2,password = \$3
-				RETURNING login, password;
+				RETURNING login, password, email_verified_at;
 			`,
 			updateDto: updateDto{
 				login:    `test@example.com`,
 				password: `hashed_password`,
 			},
 			result: result{
-				error: errors.New(USER_NOT_FOUND_ERROR),
+				error: ErrUserNotFound,
 			},
 		},
 	}
@@ -213,11 +398,11 @@ func TestRepositoryUpdate(t *testing.T) {
 			require.Nil(err)
 			pur := postgresqlUserRepository{db: db}
 
-			rows := db.NewRows([]string{`login`, `password`})
+			rows := db.NewRows([]string{`login`, `password`, `email_verified_at`})
 			resultUser := test.result.user
 
 			if resultUser != nil {
-				rows.AddRow(resultUser.Login, resultUser.Password)
+				rows.AddRow(resultUser.Login, resultUser.Password, resultUser.EmailVerifiedAt)
 			}
 
 			args := []any{test.userId}
@@ -233,6 +418,11 @@ func TestRepositoryUpdate(t *testing.T) {
 				dto.Password = &test.updateDto.password
 			}
 
+			if len(test.updateDto.expectedPassword) != 0 {
+				args = append(args, test.updateDto.expectedPassword)
+				dto.ExpectedPassword = &test.updateDto.expectedPassword
+			}
+
 			query := db.ExpectQuery(test.expectedSql).WithArgs(args...)
 
 			if test.result.dbError != nil {
@@ -260,7 +450,7 @@ func TestRepositoryUpdate(t *testing.T) {
 				require.NotNil(err)
 
 				if test.result.error != nil {
-					require.EqualError(err, test.result.error.Error())
+					require.ErrorIs(err, test.result.error)
 				} else {
 					require.EqualError(err, test.result.dbError.Error())
 				}
@@ -303,8 +493,25 @@ func TestRepositoryCreate(t *testing.T) {
 				password: `hashed_password`,
 			},
 			result: result{
-				dbError: errors.New(utils_pgx.DUPLICATE_VALUE_ERROR),
-				error:   errors.New(USER_ALREADY_EXISTS_ERROR),
+				dbError: &pgconn.PgError{
+					Code:           utils_pgx.UNIQUE_VIOLATION_CODE,
+					ConstraintName: USERS_LOGIN_CONSTRAINT,
+				},
+				error: ErrUserAlreadyExists,
+			},
+		},
+		{
+			name: `CreateConflictingUser`,
+			createDto: createDto{
+				login:    `test@example.com`,
+				password: `hashed_password`,
+			},
+			result: result{
+				dbError: &pgconn.PgError{
+					Code:           utils_pgx.CHECK_VIOLATION_CODE,
+					ConstraintName: `users_login_check`,
+				},
+				error: ErrUserConflict,
 			},
 		},
 		{
@@ -322,7 +529,7 @@ func TestRepositoryCreate(t *testing.T) {
 	expectedSql := `
 		INSERT INTO users \(login, password\)
 		VALUES \(\$1, \$2\)
-		RETURNING id, login, password;
+		RETURNING id, login, password, email_verified_at;
 	`
 
 	for _, test := range subtests {
@@ -336,8 +543,8 @@ func TestRepositoryCreate(t *testing.T) {
 			rows := db.NewRows([]string{})
 
 			if resultUser != nil {
-				rows = db.NewRows([]string{`id`, `login`, `password`})
-				rows.AddRow(resultUser.Id, resultUser.Login, resultUser.Password)
+				rows = db.NewRows([]string{`id`, `login`, `password`, `email_verified_at`})
+				rows.AddRow(resultUser.Id, resultUser.Login, resultUser.Password, resultUser.EmailVerifiedAt)
 			}
 
 			query := db.
@@ -371,7 +578,7 @@ func TestRepositoryCreate(t *testing.T) {
 				require.NotNil(err)
 
 				if test.result.error != nil {
-					require.EqualError(err, test.result.error.Error())
+					require.ErrorIs(err, test.result.error)
 				} else {
 					require.EqualError(err, test.result.dbError.Error())
 				}
@@ -1,6 +1,9 @@
 package user
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 type expectTuple struct {
 	User  *userEntity
@@ -8,9 +11,11 @@ type expectTuple struct {
 }
 
 type testUserRepository struct {
-	createExpect     *expectTuple
-	getByLoginExpect *expectTuple
-	updateExpect     *expectTuple
+	createExpect      *expectTuple
+	getByLoginExpect  *expectTuple
+	getByIdExpect     *expectTuple
+	updateExpect      *expectTuple
+	verifyEmailExpect *expectTuple
 }
 
 func NewTestUserRepository() testUserRepository {
@@ -71,6 +76,27 @@ func (self *testUserRepository) GetByLogin(
 	return nil, nil
 }
 
+func (self *testUserRepository) GetById(
+	ctx context.Context,
+	id uint32,
+) (*userEntity, error) {
+	if self.getByIdExpect != nil {
+		return self.getByIdExpect.User, self.getByIdExpect.Error
+	}
+
+	return nil, nil
+}
+
+func (self *testUserRepository) GetByIdExpectResult(
+	user *userEntity,
+	err error,
+) {
+	self.getByIdExpect = &expectTuple{
+		User:  user,
+		Error: err,
+	}
+}
+
 func (self *testUserRepository) GetByLoginExpectResult(
 	user *userEntity,
 	err error,
@@ -80,3 +106,26 @@ func (self *testUserRepository) GetByLoginExpectResult(
 		Error: err,
 	}
 }
+
+func (self *testUserRepository) VerifyEmail(
+	ctx context.Context,
+	id uint32,
+	login string,
+	at time.Time,
+) (*userEntity, error) {
+	if self.verifyEmailExpect != nil {
+		return self.verifyEmailExpect.User, self.verifyEmailExpect.Error
+	}
+
+	return nil, nil
+}
+
+func (self *testUserRepository) VerifyEmailExpectResult(
+	user *userEntity,
+	err error,
+) {
+	self.verifyEmailExpect = &expectTuple{
+		User:  user,
+		Error: err,
+	}
+}
@@ -1,28 +1,50 @@
 package user
 
-import "context"
+import (
+	"context"
+	"errors"
+	"time"
+)
 
 const (
 	USER_NOT_FOUND_ERROR            = "User not found"
 	THERE_IS_NO_UPDATE_PARAMS_ERROR = "There is no update params"
 	USER_ALREADY_EXISTS_ERROR       = "User already exists"
+	USER_CONFLICT_ERROR             = "User conflicts with existing data"
+)
+
+var (
+	ErrUserNotFound          = errors.New(USER_NOT_FOUND_ERROR)
+	ErrThereIsNoUpdateParams = errors.New(THERE_IS_NO_UPDATE_PARAMS_ERROR)
+	ErrUserAlreadyExists     = errors.New(USER_ALREADY_EXISTS_ERROR)
+	ErrUserConflict          = errors.New(USER_CONFLICT_ERROR)
 )
 
 type UserRepository interface {
 	GetByLogin(ctx context.Context, login string) (*userEntity, error)
+	GetById(ctx context.Context, id uint32) (*userEntity, error)
 	Update(ctx context.Context, id uint32, dto updateUserRepositoryDto) (*userEntity, error)
 	Create(ctx context.Context, dto createUserRepositoryDto) (*userEntity, error)
+	// Marks email verified unless the user has changed login since, fails
+	// with ErrUserNotFound then. Keeps time of the first verification
+	VerifyEmail(ctx context.Context, id uint32, login string, at time.Time) (*userEntity, error)
 }
 
 type userEntity struct {
 	Id       uint32
 	Login    string
 	Password string
+	// Nil until user confirms they own the login email. Changing login
+	// resets it
+	EmailVerifiedAt *time.Time
 }
 
 type updateUserRepositoryDto struct {
 	Login    *string
 	Password *string
+	// User is updated only while stored password hash equals it, otherwise
+	// update fails with ErrUserNotFound
+	ExpectedPassword *string
 }
 
 type createUserRepositoryDto struct {
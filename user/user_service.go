@@ -2,7 +2,9 @@ package user
 
 import (
 	"context"
+	"errors"
 	"finanstar/server/crypto"
+	"time"
 )
 
 type UserService struct {
@@ -10,9 +12,10 @@ type UserService struct {
 }
 
 type UserDto struct {
-	Id       uint32
-	Login    string
-	Password string
+	Id              uint32
+	Login           string
+	Password        string
+	EmailVerifiedAt *time.Time
 }
 
 type UpdateUserDto struct {
@@ -31,9 +34,10 @@ func NewUserService(repository UserRepository) UserService {
 
 func makeUserDto(user *userEntity) *UserDto {
 	return &UserDto{
-		Id:       user.Id,
-		Login:    user.Login,
-		Password: user.Password,
+		Id:              user.Id,
+		Login:           user.Login,
+		Password:        user.Password,
+		EmailVerifiedAt: user.EmailVerifiedAt,
 	}
 }
 
@@ -50,6 +54,19 @@ func (self *UserService) GetByLogin(
 	return makeUserDto(userEntity), nil
 }
 
+func (self *UserService) GetById(
+	ctx context.Context,
+	id uint32,
+) (*UserDto, error) {
+	userEntity, err := self.repository.GetById(ctx, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return makeUserDto(userEntity), nil
+}
+
 func (self *UserService) Update(
 	ctx context.Context,
 	id uint32,
@@ -101,3 +118,51 @@ func (self *UserService) Create(
 
 	return makeUserDto(userEntity), nil
 }
+
+// Hashes password again when stored hash uses outdated params, see
+// crypto.PasswordHashNeedsRehash. Password must be already verified against
+// the stored hash. Hash changed meanwhile, e.g. by password change, is kept
+func (self *UserService) UpgradePasswordHash(
+	ctx context.Context,
+	user *UserDto,
+	password string,
+) error {
+	needsRehash, err := crypto.PasswordHashNeedsRehash(user.Password)
+
+	if err != nil || !needsRehash {
+		return err
+	}
+
+	hashedPassword, err := crypto.HashPassword(password)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = self.repository.Update(ctx, user.Id, updateUserRepositoryDto{
+		Password:         &hashedPassword,
+		ExpectedPassword: &user.Password,
+	})
+
+	if errors.Is(err, ErrUserNotFound) {
+		return nil
+	}
+
+	return err
+}
+
+// Marks email of the user verified as long as login is still the verified one
+func (self *UserService) VerifyEmail(
+	ctx context.Context,
+	id uint32,
+	login string,
+	at time.Time,
+) (*UserDto, error) {
+	userEntity, err := self.repository.VerifyEmail(ctx, id, login, at)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return makeUserDto(userEntity), nil
+}
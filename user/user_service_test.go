@@ -6,6 +6,8 @@ import (
 	"finanstar/server/crypto"
 	"testing"
 
+	"github.com/alexedwards/argon2id"
+	"github.com/pashagolub/pgxmock/v4"
 	"github.com/stretchr/testify/require"
 )
 
@@ -207,3 +209,72 @@ func TestServiceGetByLogin(t *testing.T) {
 		})
 	}
 }
+
+func TestServiceUpgradePasswordHash(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	currentHash, err := crypto.HashPassword(`secure-password`)
+
+	require.Nil(err)
+
+	outdatedParams := crypto.DefaultPasswordHashParams()
+	outdatedParams.Iterations--
+	outdatedHash, err := argon2id.CreateHash(`secure-password`, &outdatedParams)
+
+	require.Nil(err)
+
+	subtests := []struct {
+		name string
+		hash string
+		// Whether UPDATE is expected and whether it finds the old hash
+		expectUpdate bool
+		updated      bool
+	}{
+		{
+			name: `KeepsCurrentHash`,
+			hash: currentHash,
+		},
+		{
+			name:         `ReplacesOutdatedHash`,
+			hash:         outdatedHash,
+			expectUpdate: true,
+			updated:      true,
+		},
+		{
+			name:         `IgnoresHashChangedMeanwhile`,
+			hash:         outdatedHash,
+			expectUpdate: true,
+		},
+	}
+
+	for _, test := range subtests {
+		t.Run(test.name, func(tt *testing.T) {
+			db, err := pgxmock.NewPool()
+
+			require.Nil(err)
+
+			userRepository := NewPostgresqlUserRepository(db)
+			userService := NewUserService(&userRepository)
+
+			if test.expectUpdate {
+				rows := db.NewRows([]string{`login`, `password`, `email_verified_at`})
+
+				if test.updated {
+					rows.AddRow(`test@example.com`, `new_hashed_password`, nil)
+				}
+
+				db.
+					ExpectQuery(`UPDATE users SET password = \$2 WHERE id = \$1 AND password = \$3`).
+					WithArgs(uint32(1), pgxmock.AnyArg(), test.hash).
+					WillReturnRows(rows)
+			}
+
+			require.Nil(userService.UpgradePasswordHash(
+				context.Background(),
+				&UserDto{Id: 1, Login: `test@example.com`, Password: test.hash},
+				`secure-password`,
+			))
+			require.Nil(db.ExpectationsWereMet())
+		})
+	}
+}
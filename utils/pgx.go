@@ -2,18 +2,77 @@ package utils_pgx
 
 import (
 	"context"
+	"errors"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
+// PostgreSQL SQLSTATE codes, see https://www.postgresql.org/docs/current/errcodes-appendix.html
 const (
-	DUPLICATE_VALUE_ERROR = `duplicate key value violates unique constraint`
+	UNIQUE_VIOLATION_CODE      = "23505"
+	FOREIGN_KEY_VIOLATION_CODE = "23503"
+	CHECK_VIOLATION_CODE       = "23514"
+	SERIALIZATION_FAILURE_CODE = "40001"
+	DEADLOCK_DETECTED_CODE     = "40P01"
 )
 
+type ErrorKind uint8
+
+const (
+	UNKNOWN_ERROR ErrorKind = iota
+	UNIQUE_VIOLATION
+	FOREIGN_KEY_VIOLATION
+	CHECK_VIOLATION
+	SERIALIZATION_FAILURE
+	DEADLOCK_DETECTED
+)
+
+var errorKindByCode = map[string]ErrorKind{
+	UNIQUE_VIOLATION_CODE:      UNIQUE_VIOLATION,
+	FOREIGN_KEY_VIOLATION_CODE: FOREIGN_KEY_VIOLATION,
+	CHECK_VIOLATION_CODE:       CHECK_VIOLATION,
+	SERIALIZATION_FAILURE_CODE: SERIALIZATION_FAILURE,
+	DEADLOCK_DETECTED_CODE:     DEADLOCK_DETECTED,
+}
+
+type ClassifiedError struct {
+	Kind ErrorKind
+	// Name of violated constraint, empty for non-constraint errors
+	Constraint string
+}
+
 type PgxPoolIface interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
 	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
 	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
 	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
 	Begin(ctx context.Context) (pgx.Tx, error)
 	BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error)
 }
+
+// Classifies PostgreSQL server error by its SQLSTATE code, errors not
+// returned by server are classified as UNKNOWN_ERROR
+func ClassifyError(err error) ClassifiedError {
+	var pgErr *pgconn.PgError
+
+	if !errors.As(err, &pgErr) {
+		return ClassifiedError{Kind: UNKNOWN_ERROR}
+	}
+
+	return ClassifiedError{
+		Kind:       errorKindByCode[pgErr.Code],
+		Constraint: pgErr.ConstraintName,
+	}
+}
+
+func (self ClassifiedError) IsConstraintViolation() bool {
+	return self.Kind == UNIQUE_VIOLATION ||
+		self.Kind == FOREIGN_KEY_VIOLATION ||
+		self.Kind == CHECK_VIOLATION
+}
+
+// Transaction failed due to concurrent transactions and may succeed on retry
+func (self ClassifiedError) IsRetryable() bool {
+	return self.Kind == SERIALIZATION_FAILURE || self.Kind == DEADLOCK_DETECTED
+}
@@ -0,0 +1,79 @@
+package utils_pgx
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyError(t *testing.T) {
+	t.Parallel()
+
+	subtests := []struct {
+		name                  string
+		err                   error
+		kind                  ErrorKind
+		constraint            string
+		isConstraintViolation bool
+		isRetryable           bool
+	}{
+		{
+			name:                  `UniqueViolation`,
+			err:                   &pgconn.PgError{Code: UNIQUE_VIOLATION_CODE, ConstraintName: `users_login_key`},
+			kind:                  UNIQUE_VIOLATION,
+			constraint:            `users_login_key`,
+			isConstraintViolation: true,
+		},
+		{
+			name:                  `WrappedForeignKeyViolation`,
+			err:                   fmt.Errorf(`insert: %w`, &pgconn.PgError{Code: FOREIGN_KEY_VIOLATION_CODE, ConstraintName: `fk`}),
+			kind:                  FOREIGN_KEY_VIOLATION,
+			constraint:            `fk`,
+			isConstraintViolation: true,
+		},
+		{
+			name:                  `CheckViolation`,
+			err:                   &pgconn.PgError{Code: CHECK_VIOLATION_CODE, ConstraintName: `check`},
+			kind:                  CHECK_VIOLATION,
+			constraint:            `check`,
+			isConstraintViolation: true,
+		},
+		{
+			name:        `SerializationFailure`,
+			err:         &pgconn.PgError{Code: SERIALIZATION_FAILURE_CODE},
+			kind:        SERIALIZATION_FAILURE,
+			isRetryable: true,
+		},
+		{
+			name:        `DeadlockDetected`,
+			err:         &pgconn.PgError{Code: DEADLOCK_DETECTED_CODE},
+			kind:        DEADLOCK_DETECTED,
+			isRetryable: true,
+		},
+		{
+			name: `UnknownServerError`,
+			err:  &pgconn.PgError{Code: `42601`},
+			kind: UNKNOWN_ERROR,
+		},
+		{
+			name: `NonServerError`,
+			err:  errors.New(`duplicate key value violates unique constraint`),
+			kind: UNKNOWN_ERROR,
+		},
+	}
+
+	for _, test := range subtests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+			classified := ClassifyError(test.err)
+
+			require.Equal(test.kind, classified.Kind)
+			require.Equal(test.constraint, classified.Constraint)
+			require.Equal(test.isConstraintViolation, classified.IsConstraintViolation())
+			require.Equal(test.isRetryable, classified.IsRetryable())
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package utils_pgx
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Unit of work over PgxPoolIface. Transaction is propagated through context,
+// so repositories resolving their querier with QuerierFromContext
+// transparently join it.
+
+const (
+	DEFAULT_TX_MAX_RETRIES = 3
+)
+
+type txContextKey struct{}
+
+// Subset of pgx API shared by pool and transaction
+type PgxQuerierIface interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+}
+
+type TxManagerOptions struct {
+	// How many times transaction is retried after serialization failure or
+	// deadlock
+	MaxRetries uint
+}
+
+func NewTxManager(db PgxPoolIface, options *TxManagerOptions) *TxManager {
+	manager := &TxManager{
+		db:      db,
+		options: TxManagerOptions{MaxRetries: DEFAULT_TX_MAX_RETRIES},
+	}
+
+	if options != nil {
+		manager.options = *options
+	}
+
+	return manager
+}
+
+type TxManager struct {
+	db      PgxPoolIface
+	options TxManagerOptions
+}
+
+// Returns transaction bound to ctx or db when there is none
+func QuerierFromContext(ctx context.Context, db PgxPoolIface) PgxQuerierIface {
+	if tx, ok := ctx.Value(txContextKey{}).(pgx.Tx); ok {
+		return tx
+	}
+
+	return db
+}
+
+func (self *TxManager) RunInTx(
+	ctx context.Context,
+	fn func(ctx context.Context) error,
+) error {
+	return self.RunInTxWithOptions(ctx, pgx.TxOptions{}, fn)
+}
+
+// Runs fn in transaction and commits it when fn succeeds. Called inside
+// another transaction runs fn in a savepoint instead, txOptions are ignored
+// then. Top level transaction is retried on serialization failures, so fn
+// must be safe to run several times.
+func (self *TxManager) RunInTxWithOptions(
+	ctx context.Context,
+	txOptions pgx.TxOptions,
+	fn func(ctx context.Context) error,
+) error {
+	if parent, ok := ctx.Value(txContextKey{}).(pgx.Tx); ok {
+		return runTx(ctx, parent.Begin, fn)
+	}
+
+	begin := func(ctx context.Context) (pgx.Tx, error) {
+		return self.db.BeginTx(ctx, txOptions)
+	}
+
+	for attempt := uint(0); ; attempt++ {
+		err := runTx(ctx, begin, fn)
+
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= self.options.MaxRetries ||
+			!ClassifyError(err).IsRetryable() ||
+			ctx.Err() != nil {
+			return err
+		}
+	}
+}
+
+func runTx(
+	ctx context.Context,
+	begin func(ctx context.Context) (pgx.Tx, error),
+	fn func(ctx context.Context) error,
+) error {
+	tx, err := begin(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			tx.Rollback(ctx)
+			panic(recovered)
+		}
+	}()
+
+	if err = fn(context.WithValue(ctx, txContextKey{}, tx)); err != nil {
+		tx.Rollback(ctx)
+
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,125 @@
+package utils_pgx
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunInTx(t *testing.T) {
+	t.Parallel()
+
+	serializationFailure := &pgconn.PgError{Code: SERIALIZATION_FAILURE_CODE}
+
+	subtests := []struct {
+		name       string
+		fnErrors   []error
+		maxRetries uint
+		error      error
+	}{
+		{
+			name:     `CommitsOnSuccess`,
+			fnErrors: []error{nil},
+		},
+		{
+			name:     `RollsBackOnError`,
+			fnErrors: []error{errors.New(`UnknownError`)},
+			error:    errors.New(`UnknownError`),
+		},
+		{
+			name:       `RetriesSerializationFailure`,
+			fnErrors:   []error{serializationFailure, nil},
+			maxRetries: 1,
+		},
+		{
+			name:       `GivesUpAfterMaxRetries`,
+			fnErrors:   []error{serializationFailure, serializationFailure},
+			maxRetries: 1,
+			error:      serializationFailure,
+		},
+	}
+
+	for _, test := range subtests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+			db, err := pgxmock.NewPool()
+
+			require.Nil(err)
+
+			for _, fnError := range test.fnErrors {
+				db.ExpectBegin()
+
+				if fnError != nil {
+					db.ExpectRollback()
+				} else {
+					db.ExpectCommit()
+				}
+			}
+
+			manager := NewTxManager(db, &TxManagerOptions{MaxRetries: test.maxRetries})
+			calls := 0
+
+			err = manager.RunInTx(context.Background(), func(ctx context.Context) error {
+				_, inTx := QuerierFromContext(ctx, db).(pgx.Tx)
+				require.True(inTx)
+
+				err := test.fnErrors[calls]
+				calls++
+
+				return err
+			})
+
+			if test.error != nil {
+				require.EqualError(err, test.error.Error())
+			} else {
+				require.Nil(err)
+			}
+
+			require.Equal(len(test.fnErrors), calls)
+			require.Nil(db.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestRunInTxNested(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	db, err := pgxmock.NewPool()
+
+	require.Nil(err)
+
+	// Outer transaction and savepoint, failed savepoint doesn't abort outer one
+	db.ExpectBegin()
+	db.ExpectBegin()
+	db.ExpectRollback()
+	db.ExpectCommit()
+
+	manager := NewTxManager(db, nil)
+
+	err = manager.RunInTx(context.Background(), func(ctx context.Context) error {
+		nestedErr := manager.RunInTx(ctx, func(ctx context.Context) error {
+			return errors.New(`NestedError`)
+		})
+
+		require.EqualError(nestedErr, `NestedError`)
+
+		return nil
+	})
+
+	require.Nil(err)
+	require.Nil(db.ExpectationsWereMet())
+}
+
+func TestQuerierFromContext(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	db, err := pgxmock.NewPool()
+
+	require.Nil(err)
+	require.Equal(db, QuerierFromContext(context.Background(), db))
+}